@@ -1,6 +1,8 @@
 package readline
 
 const (
+	CharCtrlSpace = 0x00
+
 	CharCtrlA     = 0x01
 	CharLineStart = CharCtrlA
 
@@ -34,8 +36,9 @@ const (
 	CharCtrlK = 0x0B
 	CharKill  = CharCtrlK
 
-	CharCtrlL = 0x0C
-	CharClear = CharCtrlL
+	CharCtrlL       = 0x0C
+	CharClear       = CharCtrlL
+	CharClearScreen = CharCtrlL
 
 	CharCtrlM  = 0x0D
 	CharReturn = CharCtrlM
@@ -77,6 +80,9 @@ const (
 
 	CharEscape = 0x1B
 
+	CharCtrlUnderscore = 0x1F
+	CharUndo           = CharCtrlUnderscore
+
 	CharEscapeEx = 0x5B
 
 	CharBackspaceEx = 0x7F
@@ -0,0 +1,57 @@
+package readline
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+)
+
+// MaxClipboardSize is the max size of the clipboard content in bytes which can be pasted.
+const MaxClipboardSize = 1 << 20
+
+// ReadClipboard reads the text from the system clipboard. It uses xclip or xsel on Linux and the other Unix systems,
+// pbpaste on macOS, and the clipboard API on Windows.
+func ReadClipboard() ([]byte, error) {
+	return readClipboard()
+}
+
+// readClipboardCommand runs the command name with args, and returns its output up to MaxClipboardSize bytes.
+func readClipboardCommand(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p, err := ioutil.ReadAll(io.LimitReader(stdout, MaxClipboardSize+1))
+	if err == nil && len(p) > MaxClipboardSize {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrClipboardTooLarge, MaxClipboardSize)
+	}
+	if err2 := cmd.Wait(); err == nil && err2 != nil {
+		err = fmt.Errorf("%s: %w", name, err2)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// clipboardText returns the text which is pasted from the clipboard content p. The null bytes are removed, and the
+// line endings are converted into newlines. The trailing newline is removed, and the other newlines are replaced
+// with spaces if multiLine is false.
+func clipboardText(p []byte, multiLine bool) []byte {
+	p = bytes.ReplaceAll(p, []byte{0}, nil)
+	p = bytes.ReplaceAll(p, []byte("\r\n"), []byte("\n"))
+	p = bytes.ReplaceAll(p, []byte("\r"), []byte("\n"))
+	p = bytes.TrimSuffix(p, []byte("\n"))
+	if !multiLine {
+		p = bytes.ReplaceAll(p, []byte("\n"), []byte(" "))
+	}
+	return p
+}
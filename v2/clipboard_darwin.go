@@ -0,0 +1,13 @@
+package readline
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func readClipboard() ([]byte, error) {
+	if _, err := exec.LookPath("pbpaste"); err != nil {
+		return nil, fmt.Errorf("%w: pbpaste is required", ErrNoClipboard)
+	}
+	return readClipboardCommand("pbpaste")
+}
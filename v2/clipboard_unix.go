@@ -0,0 +1,18 @@
+// +build dragonfly freebsd netbsd openbsd linux,!appengine solaris
+
+package readline
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func readClipboard() ([]byte, error) {
+	if _, err := exec.LookPath("xclip"); err == nil {
+		return readClipboardCommand("xclip", "-o", "-sel", "clip")
+	}
+	if _, err := exec.LookPath("xsel"); err == nil {
+		return readClipboardCommand("xsel", "--clipboard", "--output")
+	}
+	return nil, fmt.Errorf("%w: xclip or xsel is required", ErrNoClipboard)
+}
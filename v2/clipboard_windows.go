@@ -0,0 +1,54 @@
+package readline
+
+import (
+	"fmt"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+const cfUnicodeText = 13
+
+var (
+	user32               = syscall.NewLazyDLL("user32.dll")
+	procOpenClipboard    = user32.NewProc("OpenClipboard")
+	procCloseClipboard   = user32.NewProc("CloseClipboard")
+	procGetClipboardData = user32.NewProc("GetClipboardData")
+	procGlobalLock       = kernel32.NewProc("GlobalLock")
+	procGlobalUnlock     = kernel32.NewProc("GlobalUnlock")
+	procGlobalSize       = kernel32.NewProc("GlobalSize")
+	procRtlMoveMemory    = kernel32.NewProc("RtlMoveMemory")
+)
+
+func readClipboard() ([]byte, error) {
+	if r, _, err := procOpenClipboard.Call(0); r == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNoClipboard, err)
+	}
+	defer procCloseClipboard.Call()
+	h, _, err := procGetClipboardData.Call(cfUnicodeText)
+	if h == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNoClipboard, err)
+	}
+	size, _, _ := procGlobalSize.Call(h)
+	// every UTF-16 unit is encoded in up to 3 bytes
+	if size/2*3 > MaxClipboardSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrClipboardTooLarge, size)
+	}
+	u := make([]uint16, size/2)
+	if len(u) == 0 {
+		return nil, nil
+	}
+	p, _, err := procGlobalLock.Call(h)
+	if p == 0 {
+		return nil, err
+	}
+	defer procGlobalUnlock.Call(h)
+	_, _, _ = procRtlMoveMemory.Call(uintptr(unsafe.Pointer(&u[0])), p, uintptr(len(u)*2))
+	for i, c := range u {
+		if c == 0 {
+			u = u[:i]
+			break
+		}
+	}
+	return []byte(string(utf16.Decode(u))), nil
+}
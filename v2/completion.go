@@ -0,0 +1,444 @@
+package readline
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/goinsane/readline/v2/runeutil"
+)
+
+// Completer provides completion candidates for the line.
+type Completer interface {
+	// Complete returns the completion candidates for line with the cursor at pos. A candidate is inserted into
+	// newLine at newPos, so newLine is typically line without the partial word which is being completed.
+	Complete(line []rune, pos int) (newLine []rune, completions []string, newPos int)
+}
+
+// CompleterFunc is an adapter to allow the use of ordinary functions as Completer.
+type CompleterFunc func(line []rune, pos int) (newLine []rune, completions []string, newPos int)
+
+// Complete calls f(line, pos).
+func (f CompleterFunc) Complete(line []rune, pos int) (newLine []rune, completions []string, newPos int) {
+	return f(line, pos)
+}
+
+// CompletionStyler is an optional interface of Completer which styles the candidates in the completion menu.
+type CompletionStyler interface {
+	// CompletionStyle returns the SGR parameter of candidate in the completion menu, like "1;34".
+	// The candidate isn't styled if it's empty.
+	CompletionStyle(candidate string) string
+}
+
+// CompletionTyper is an optional interface of Completer which returns the file types of the candidates, which are
+// passed to Config.CompletionIconProvider.
+type CompletionTyper interface {
+	// CompletionType returns the file type bits of candidate, like os.ModeDir, or 0 for a regular file or
+	// an unknown type.
+	CompletionType(candidate string) os.FileMode
+}
+
+// AsyncCompleter provides completion candidates for the line without blocking the input.
+type AsyncCompleter interface {
+	// CompleteAsync sends the completion candidates for line with the cursor at pos to the returned channel.
+	// A candidate is inserted at pos, so it's the rest of the partial word which is being completed. ctx is done
+	// when the completion is canceled or timed out, and the channel may be closed without sending then.
+	CompleteAsync(ctx context.Context, line []rune, pos int) <-chan []string
+}
+
+// AsyncCompleterFunc is an adapter to allow the use of ordinary functions as AsyncCompleter.
+type AsyncCompleterFunc func(ctx context.Context, line []rune, pos int) <-chan []string
+
+// CompleteAsync calls f(ctx, line, pos).
+func (f AsyncCompleterFunc) CompleteAsync(ctx context.Context, line []rune, pos int) <-chan []string {
+	return f(ctx, line, pos)
+}
+
+// DefaultAsyncCompletionTimeout is the default time limit of AsyncCompleter.
+const DefaultAsyncCompletionTimeout = 500 * time.Millisecond
+
+// asyncCompletionIndicator is appended to the prompt while waiting for AsyncCompleter.
+const asyncCompletionIndicator = "..."
+
+// completionMenuStyle is the SGR parameter of the selected candidate in the completion menu.
+const completionMenuStyle = "7"
+
+// completionState is the state of an ambiguous completion.
+type completionState struct {
+	line       []rune
+	pos        int
+	candidates []string
+	time       time.Time
+
+	// menu is true while the completion menu is displayed, and selected is the index of the selected candidate.
+	menu     bool
+	selected int
+	// labels are the candidates with their icons in the completion menu, they're nil until the menu is displayed.
+	labels []string
+}
+
+// asyncCompletionState is the state of a pending AsyncCompleter call.
+type asyncCompletionState struct {
+	line   []rune
+	pos    int
+	prompt string
+	cancel context.CancelFunc
+	ch     <-chan []string
+}
+
+// completionMenuKey processes key while the completion menu is displayed. The arrow keys move the selection in
+// the grid, and Enter confirms the selected candidate. It returns false if key should exit the menu.
+func (t *Terminal) completionMenuKey(key KeyEvent) bool {
+	c := t.completion
+	n := len(c.candidates)
+	_, rows := completionMenuSize(t.completionLabels(), t.rb.ScreenWidth(), t.config.CompletionColumns)
+	switch key {
+	case "\t", "\x0e", "\x1b[B", "\x1bOB":
+		c.selected = (c.selected + 1) % n
+
+	case "\x10", "\x1b[A", "\x1bOA":
+		c.selected = (c.selected + n - 1) % n
+
+	case "\x1b[C", "\x1bOC":
+		if c.selected+rows < n {
+			c.selected += rows
+		} else {
+			c.selected %= rows
+		}
+
+	case "\x1b[D", "\x1bOD":
+		if c.selected-rows >= 0 {
+			c.selected -= rows
+		} else {
+			for c.selected+rows < n {
+				c.selected += rows
+			}
+		}
+
+	case "\r", "\n":
+		t.completionExit()
+		return true
+
+	default:
+		return false
+
+	}
+	t.completionRender()
+	return true
+}
+
+func (t *Terminal) completionTab() {
+	c := t.completion
+	if c != nil {
+		if t.config.CompletionTimeout <= 0 || time.Since(c.time) <= t.config.CompletionTimeout {
+			c.menu = true
+			c.selected = 0
+			t.completionRender()
+			return
+		}
+		t.completion = nil
+	}
+
+	if t.config.AsyncCompleter != nil {
+		t.asyncCompletionStart()
+		return
+	}
+	if t.config.Completer == nil {
+		t.bell()
+		return
+	}
+	if t.config.FuzzyCompletion {
+		t.fuzzyCompletion()
+		return
+	}
+	line, candidates, pos := t.config.Completer.Complete(t.rb.Runes(), t.rb.Index())
+	t.completionApply(line, candidates, pos)
+}
+
+// fuzzyCompletion calls Completer without the word at the cursor, and ranks the candidates by FuzzyScore with that
+// word. The best candidate is selected in the completion menu if its score is higher than the others.
+func (t *Terminal) fuzzyCompletion() {
+	line, idx := t.rb.Runes(), t.rb.Index()
+	start, end := idx, idx
+	for start > 0 && !unicode.IsSpace(line[start-1]) {
+		start--
+	}
+	for end < len(line) && !unicode.IsSpace(line[end]) {
+		end++
+	}
+	query := runeutil.Copy(line[start:end])
+	line = append(line[:start], line[end:]...)
+	line, candidates, pos := t.config.Completer.Complete(line, start)
+	if pos < 0 || pos > len(line) {
+		t.bell()
+		return
+	}
+	candidates, scores := fuzzyRank(query, candidates)
+	switch len(candidates) {
+	case 0:
+		t.bell()
+
+	case 1:
+		t.completionInsert(line, pos, []rune(candidates[0]))
+
+	default:
+		t.completion = &completionState{
+			line:       runeutil.Copy(line),
+			pos:        pos,
+			candidates: candidates,
+			time:       time.Now(),
+		}
+		if scores[0] > scores[1] {
+			t.completion.menu = true
+			t.completionRender()
+			return
+		}
+		t.bell()
+
+	}
+}
+
+// fuzzyRank returns the candidates which match query with their scores, from the highest score to the lowest.
+// The shorter candidate comes first if the scores are equal.
+func fuzzyRank(query []rune, candidates []string) ([]string, []int) {
+	type match struct {
+		candidate string
+		score     int
+	}
+	var matches []match
+	for _, candidate := range candidates {
+		if score := runeutil.FuzzyScore(query, []rune(candidate)); score > 0 {
+			matches = append(matches, match{candidate, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return utf8.RuneCountInString(matches[i].candidate) < utf8.RuneCountInString(matches[j].candidate)
+	})
+	ranked, scores := make([]string, len(matches)), make([]int, len(matches))
+	for i, m := range matches {
+		ranked[i], scores[i] = m.candidate, m.score
+	}
+	return ranked, scores
+}
+
+// completionApply inserts the candidate if it's only one, or starts the completion mode if they are ambiguous.
+func (t *Terminal) completionApply(line []rune, candidates []string, pos int) {
+	if pos < 0 || pos > len(line) {
+		t.bell()
+		return
+	}
+	switch len(candidates) {
+	case 0:
+		t.bell()
+
+	case 1:
+		t.completionInsert(line, pos, []rune(candidates[0]))
+
+	default:
+		t.completion = &completionState{
+			line:       runeutil.Copy(line),
+			pos:        pos,
+			candidates: candidates,
+			time:       time.Now(),
+		}
+		t.completionInsert(line, pos, commonPrefix(candidates))
+		t.bell()
+
+	}
+}
+
+// asyncCompletionStart calls AsyncCompleter in another goroutine, and shows the indicator in the prompt while waiting.
+func (t *Terminal) asyncCompletionStart() {
+	timeout := t.config.AsyncCompletionTimeout
+	if timeout <= 0 {
+		timeout = DefaultAsyncCompletionTimeout
+	}
+	ctx, cancel := context.WithTimeout(t.ctx, timeout)
+	ch := make(chan []string, 1)
+	a := &asyncCompletionState{
+		line:   t.rb.Runes(),
+		pos:    t.rb.Index(),
+		prompt: t.rb.Prompt(),
+		cancel: cancel,
+		ch:     ch,
+	}
+	t.asyncCompletion = a
+	completer, line := t.config.AsyncCompleter, runeutil.Copy(a.line)
+	go func() {
+		defer close(ch)
+		select {
+		case candidates, ok := <-completer.CompleteAsync(ctx, line, a.pos):
+			if ok {
+				ch <- candidates
+			}
+		case <-ctx.Done():
+		}
+	}()
+	t.rb.SetPrompt(a.prompt + asyncCompletionIndicator)
+}
+
+// asyncCompletionCh returns the channel of the pending AsyncCompleter call, or nil if there isn't one.
+func (t *Terminal) asyncCompletionCh() <-chan []string {
+	if t.asyncCompletion == nil {
+		return nil
+	}
+	return t.asyncCompletion.ch
+}
+
+// asyncCompletionDone applies the result of the pending AsyncCompleter call. ok is false if it's canceled or timed
+// out without a result.
+func (t *Terminal) asyncCompletionDone(candidates []string, ok bool) {
+	a := t.asyncCompletion
+	t.asyncCompletionCancel()
+	if !ok {
+		t.bell()
+		return
+	}
+	t.completionApply(a.line, candidates, a.pos)
+}
+
+// asyncCompletionCancel cancels the pending AsyncCompleter call, and restores the prompt.
+func (t *Terminal) asyncCompletionCancel() {
+	a := t.asyncCompletion
+	if a == nil {
+		return
+	}
+	t.asyncCompletion = nil
+	a.cancel()
+	t.rb.SetPrompt(a.prompt)
+}
+
+// completionExit exits the completion mode, and keeps the inserted candidate.
+func (t *Terminal) completionExit() {
+	c := t.completion
+	t.completion = nil
+	if c.menu {
+		t.rb.SetMenu(nil)
+	}
+}
+
+func (t *Terminal) completionInsert(line []rune, pos int, s []rune) {
+	buf := make([]rune, 0, len(line)+len(s))
+	buf = append(buf, line[:pos]...)
+	buf = append(buf, s...)
+	buf = append(buf, line[pos:]...)
+	t.rb.Set(pos+len(s), buf)
+}
+
+func (t *Terminal) completionRender() {
+	c := t.completion
+	t.completionInsert(c.line, c.pos, []rune(c.candidates[c.selected]))
+	var style func(string) string
+	if styler, ok := t.config.Completer.(CompletionStyler); ok && t.config.AsyncCompleter == nil {
+		style = styler.CompletionStyle
+	}
+	t.rb.SetMenu(completionMenu(c.candidates, t.completionLabels(), c.selected, t.rb.ScreenWidth(),
+		t.config.CompletionColumns, style))
+}
+
+// completionLabels returns the labels of the candidates in the completion menu, which are prefixed with the icons
+// of CompletionIconProvider.
+func (t *Terminal) completionLabels() []string {
+	c := t.completion
+	if c.labels != nil {
+		return c.labels
+	}
+	c.labels = c.candidates
+	if provider := t.config.CompletionIconProvider; provider != nil {
+		typer, _ := t.config.Completer.(CompletionTyper)
+		if t.config.AsyncCompleter != nil {
+			typer = nil
+		}
+		c.labels = make([]string, len(c.candidates))
+		for i, candidate := range c.candidates {
+			var typ os.FileMode
+			if typer != nil {
+				typ = typer.CompletionType(candidate)
+			}
+			c.labels[i] = provider(candidate, typ) + candidate
+		}
+	}
+	return c.labels
+}
+
+// completionMenuSize returns the column width and the number of rows of the completion menu of labels, with
+// columns at most if it's positive. The labels are placed in columns from top to bottom.
+func completionMenuSize(labels []string, screenWidth int, columns int) (colWidth, rows int) {
+	for _, label := range labels {
+		if w := runeutil.WidthAll([]rune(label)); w > colWidth {
+			colWidth = w
+		}
+	}
+	colWidth += 2
+	cols := screenWidth / colWidth
+	if cols <= 0 {
+		cols = 1
+	}
+	if columns > 0 && cols > columns {
+		cols = columns
+	}
+	return colWidth, (len(labels) + cols - 1) / cols
+}
+
+// completionMenu returns the lines of the completion menu which displays candidates in a grid. labels are displayed
+// instead of the candidates if it isn't nil. The candidates are styled by style if it isn't nil.
+func completionMenu(candidates []string, labels []string, selected int, screenWidth int, columns int,
+	style func(string) string) []string {
+	if labels == nil {
+		labels = candidates
+	}
+	colWidth, rows := completionMenuSize(labels, screenWidth, columns)
+	cols := (len(candidates) + rows - 1) / rows
+
+	lines := make([]string, rows)
+	for row := range lines {
+		var sb strings.Builder
+		for col := 0; col < cols; col++ {
+			i := col*rows + row
+			if i >= len(candidates) {
+				break
+			}
+			if col > 0 {
+				sb.WriteString(strings.Repeat(" ", colWidth-runeutil.WidthAll([]rune(labels[i-rows]))))
+			}
+			var s string
+			if style != nil {
+				s = style(candidates[i])
+			}
+			if i == selected {
+				if s != "" {
+					s += ";"
+				}
+				s += completionMenuStyle
+			}
+			if s != "" {
+				sb.WriteString("\033[" + s + "m" + labels[i] + "\033[0m")
+			} else {
+				sb.WriteString(labels[i])
+			}
+		}
+		lines[row] = sb.String()
+	}
+	return lines
+}
+
+// commonPrefix returns the longest common prefix of candidates.
+func commonPrefix(candidates []string) []rune {
+	prefix := []rune(candidates[0])
+	for _, candidate := range candidates[1:] {
+		s := []rune(candidate)
+		n := 0
+		for n < len(prefix) && n < len(s) && prefix[n] == s[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return prefix
+}
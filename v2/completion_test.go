@@ -0,0 +1,208 @@
+package readline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCompleter(words ...string) Completer {
+	return CompleterFunc(func(line []rune, pos int) ([]rune, []string, int) {
+		start := pos
+		for start > 0 && line[start-1] != ' ' {
+			start--
+		}
+		prefix := string(line[start:pos])
+		var completions []string
+		for _, word := range words {
+			if strings.HasPrefix(word, prefix) {
+				completions = append(completions, word)
+			}
+		}
+		newLine := append(append([]rune{}, line[:start]...), line[pos:]...)
+		return newLine, completions, start
+	})
+}
+
+func TestTerminalCompletion(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		Completer: newTestCompleter("apple", "apricot", "banana"),
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"b\t\r", "banana"},
+		{"eat b\t!\r", "eat banana!"},
+		{"a\t\r", "ap"},
+		{"a\t\t\r\r", "apple"},
+		{"a\t\t\t\r\r", "apricot"},
+		{"a\t\t\t\t\r\r", "apple"},
+		{"a\t\tx\r", "applex"},
+		{"z\t\r", "z"},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
+
+func TestCompletionMenu(t *testing.T) {
+	lines := completionMenu([]string{"a", "bb", "ccc", "d", "e"}, nil, 1, 12, 0, nil)
+	expected := []string{
+		"a    d",
+		"\033[7mbb\033[0m   e",
+		"ccc",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("menu %q, expected %q", lines, expected)
+	}
+}
+
+func TestCompletionMenuIcons(t *testing.T) {
+	candidates := []string{"a", "bb", "ccc", "d", "e"}
+	labels := []string{"世a", "世bb", "世ccc", "世d", "世e"}
+	lines := completionMenu(candidates, labels, 1, 80, 2, nil)
+	expected := []string{
+		"世a    世d",
+		"\033[7m世bb\033[0m   世e",
+		"世ccc",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("menu %q, expected %q", lines, expected)
+	}
+}
+
+func TestTerminalCompletionIcons(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{
+		Completer: newTestCompleter("apple", "apricot"),
+		CompletionIconProvider: func(completion string, entryType os.FileMode) string {
+			return "[" + completion[:2] + "] "
+		},
+		DisableAutoSaveHistory: true,
+		ForceUseInteractive:    true,
+	}, out)
+
+	if line := writeAndReadLine(t, term, stdin, "a\t\t\r\r"); line != "apple" {
+		t.Fatalf("line %q, expected \"apple\"", line)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "[ap] apricot")
+	})
+}
+
+func TestTerminalCompletionMenuNavigation(t *testing.T) {
+	var words []string
+	for i := 0; i < 10; i++ {
+		words = append(words, fmt.Sprintf("completion-candidate-%02d", i))
+	}
+	// the menu has 3 columns and 4 rows in 80 columns
+	term, stdin := newTestTerminal(t, Config{
+		Completer:              newTestCompleter(words...),
+		DisableAutoSaveHistory: true,
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"\t\t\x1b[B\x1b[B\x1b[B\r\r", words[3]},
+		{"\t\t\x1bOB\x0e\x1b[A\r\r", words[1]},
+		{"\t\t\x1b[A\r\r", words[9]},
+		{"\t\t\x1b[C\x1b[C\r\r", words[8]},
+		{"\t\t\x1b[C\x1b[C\x1b[C\r\r", words[0]},
+		{"\t\t\x1b[B\x1b[D\r\r", words[9]},
+		{"\t\t\x1b[B\t\r\r", words[2]},
+		{"\t\t\x1b[Bx\r", words[1] + "x"},
+		{"\t\t\x1b[B\x01\r", words[1]},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
+
+func TestTerminalAsyncCompletion(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	term, stdin := newTestTerminal(t, Config{
+		DisableAutoSaveHistory: true,
+		AsyncCompletionTimeout: time.Second,
+		AsyncCompleter: AsyncCompleterFunc(func(ctx context.Context, line []rune, pos int) <-chan []string {
+			ch := make(chan []string, 1)
+			go func() {
+				switch string(line[:pos]) {
+				case "ap":
+					time.Sleep(10 * time.Millisecond)
+					ch <- []string{"ple"}
+				case "slow":
+					<-ctx.Done()
+					canceled <- struct{}{}
+				}
+			}()
+			return ch
+		}),
+	})
+
+	if _, err := io.WriteString(stdin, "ap\t"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return term.rb.String() == "apple"
+	})
+	if line := writeAndReadLine(t, term, stdin, "!\r"); line != "apple!" {
+		t.Fatalf("line %q, expected \"apple!\"", line)
+	}
+
+	if _, err := io.WriteString(stdin, "slow\t"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return term.rb.Prompt() == asyncCompletionIndicator
+	})
+	if line := writeAndReadLine(t, term, stdin, "x\r"); line != "slowx" {
+		t.Fatalf("line %q, expected \"slowx\"", line)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("completion isn't canceled")
+	}
+	if prompt := term.rb.Prompt(); prompt != "" {
+		t.Fatalf("prompt %q, expected the restored prompt", prompt)
+	}
+}
+
+func TestTerminalFuzzyCompletion(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		Completer:       newTestCompleter("format", "for", "foreach", "fork", "fmt"),
+		FuzzyCompletion: true,
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fot\t\r", "format"},
+		{"go fm\t\r\r", "go fmt"},
+		{"fm\t\t\r\r", "format"},
+		{"fr\t\r", "fr"},
+		{"fr\t\t\r\r", "for"},
+		{"fr\t\t\t\t\r\r", "format"},
+		{"fr\t\t\t\t\t\r\r", "foreach"},
+		{"xyz\t\r", "xyz"},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
@@ -1,34 +1,210 @@
 package readline
 
-import "io"
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/goinsane/readline/v2/runeutil"
+)
+
+// StyleRange is the SGR parameter Style of the runes from Start to End in the line.
+type StyleRange = runeutil.StyleRange
+
+// Style is the text style which is written by an SGR sequence, its SGR method returns the SGR parameter of StyleRange.
+type Style = runeutil.Style
+
+// Color is a foreground or background color of a Style.
+type Color = runeutil.Color
+
+// ColorMode is the kind of a Color.
+type ColorMode = runeutil.ColorMode
+
+const (
+	ColorDefault = runeutil.ColorDefault
+	Color16      = runeutil.Color16
+	Color256     = runeutil.Color256
+	ColorTrue    = runeutil.ColorTrue
+)
 
 type Config struct {
 	// prompt supports ANSI escape sequence, so we can color some characters
 	Prompt string
+	// PromptFunc is called before reading each line to get the prompt, it takes precedence over Prompt if it's not nil
+	PromptFunc func() string
+	// RightPrompt returns the prompt which is displayed at the right edge of the terminal, it's hidden if the line
+	// reaches it
+	RightPrompt func() string
+	// TransientPrompt returns the prompt which replaces the prompt of the line when the line is accepted, like a
+	// shorter prompt or a timestamp
+	TransientPrompt func(line string) string
 
 	InterruptPrompt string
 	EOFPrompt       string
+	// ConfirmInterrupt is called with the line when Ctrl+C is pressed, and the line isn't interrupted if it returns
+	// false. It's called in the input loop, so it must return quickly, and it must not call the reads of the Terminal
+	// like ReadLine.
+	ConfirmInterrupt func(line string) bool
+	// the prompt which replaces the prompt to confirm the interrupt by Ctrl+C, the line is interrupted if "y" or Ctrl+C
+	// is pressed then, and the other keys cancel it
+	ConfirmInterruptPrompt string
 
-	Stdin  *io.ReadCloser
-	Stdout *io.Writer
-	Stderr *io.Writer
+	Stdin  *os.File
+	Stdout *os.File
+	Stderr *os.File
+	// the input and the output of the terminal instead of Stdin and Stdout if it isn't nil, like a network connection
+	// or a pipe. The raw mode and the screen size are used only if it implements TerminalFD, otherwise the terminal
+	// isn't interactive unless ForceUseInteractive is set
+	ReadWriter io.ReadWriter
+	// the errors which don't stop reading lines, like the errors of History, are written to ErrorWriter if it isn't nil
+	ErrorWriter io.Writer
+	// the input is written to TraceWriter if it isn't nil, a JSON TraceEvent per line before the input is processed,
+	// so the input can be replayed by TerminalReplay, the input which is read while echoing is disabled, like by
+	// ReadPassword, is redacted
+	TraceWriter io.Writer
+	// the terminator of the OSC sequences which Terminal.EmitOSC writes, OSCTerminatorBEL by default,
+	// OSCTerminatorST for tmux
+	OSCTerminator string
+	// wrap the OSC sequences and the sequences of the bracketed paste mode by WrapTMux, so tmux passes them to
+	// the outer terminal, it can be set to IsTMux()
+	TMuxPassthrough bool
 
 	Mask rune
 
 	ForceUseInteractive bool
+	// disable the escape sequences like a dumb terminal, it's enabled if $TERM is dumb
+	ForceDumb bool
+	// disable the ANSI colors and styles, it's enabled if $NO_COLOR is set
+	NoColor bool
+
+	// enable bracketed paste mode, pasted text is inserted as is, newlines don't submit the line
+	BracketedPaste bool
+	// enable xterm modifyOtherKeys mode, the modified keys like Ctrl+Shift+A are reported as "\x1b[27;mod;char~"
+	// sequences, and the ones which have a traditional encoding like Ctrl+A are translated into it
+	EnableModifyOtherKeys bool
+	// the DECSCUSR parameters of the cursor shapes in the insert and the overwrite modes, like "6" for a bar and "2"
+	// for a block, the cursor shape isn't changed if they're empty, and it's reset to the default on exiting
+	InsertModeCursorShape    string
+	OverwriteModeCursorShape string
+	// the escape key is processed as a bare escape if an escape sequence isn't completed in EscapeTimeout, like
+	// entering the vi normal mode, it's 100ms by default, set it to -1 to wait for the next key
+	EscapeTimeout time.Duration
+
+	// enable multi-line editing, the keys which aren't SubmitKey among Enter and Ctrl+J insert a newline
+	MultiLine bool
+	// the prompt of the lines after the first one in the multi-line editing
+	ContinuationPrompt string
+	// specify the key which submits the line in the multi-line editing, it's Enter by default
+	SubmitKey KeyEvent
+	// Validator is called with the line when the line is submitted. If it returns an error, a newline is inserted
+	// instead of submitting the line, which is useful with MultiLine. It's called in the input loop, so it should
+	// return quickly, and a long-running validation should be done in another goroutine which sends its result over
+	// a channel.
+	Validator func(line string) error
+	// Expander is called with the line when the line is submitted, before Validator. The line it returns is validated,
+	// added to the history and returned instead of the typed one, like an alias or a spelling correction. It's called
+	// in the input loop, so it should return quickly.
+	Expander func(line string) string
+	// display the expanded line in place of the typed one when Expander changes it
+	ShowExpansion bool
 
 	// readline will persist historys to file where HistoryFile specified
 	HistoryFile string
+	// specify how the lines are written to HistoryFile, it's HistoryFileRewrite by default
+	HistoryFileMode HistoryFileMode
+	// load the lines which are added by the other terminals from the history backend when a read starts, not only
+	// when a history navigation or search starts
+	HistoryAutoRefresh bool
+	// History stores the history lines instead of HistoryFile or the memory if it isn't nil
+	History HistoryBackend
+	// lines which start with HistoryCommentPrefix in the history file are ignored, it's disabled if empty
+	HistoryCommentPrefix string
 	// specify the max length of historys, it's 500 by default, set it to -1 to disable history
 	HistoryLimit           int
 	DisableAutoSaveHistory bool
+	// specify how the duplicate lines are added to history, it's HistoryDupAll by default
+	HistoryDuplicates HistoryDupPolicy
+	// lines which start with a space aren't added to history if HistoryIgnoreSpace is true
+	HistoryIgnoreSpace bool
+	// partition the history by the value of HistoryContextKey in the context of ReadLineContext if both are
+	// non-nil, the lines added in a context are navigated and searched only in the contexts with an equal value.
+	// The context without the key uses HistoryContextValue. The lines loaded from the history file or History are
+	// visible in all contexts, they aren't loaded again after the first read. The values must be comparable
+	HistoryContextKey   interface{}
+	HistoryContextValue interface{}
 	// enable case-insensitive history searching
 	HistorySearchFold bool
+	// match the pattern of the history grep, which is Meta+!, as a regular expression instead of a substring
+	HistoryRegexSearch bool
+	// navigate only the history lines which start with the line typed before the navigation, if the cursor is at
+	// the end of the line when the navigation starts
+	HistoryPrefixSearch bool
+
+	// characters which separate words, non-alphanumeric characters separate words if it's empty
+	WordBreakChars string
+	// the distance of the tab stops, it's 8 by default
+	TabWidth int
+
+	// enable vi editing mode, escape enters the normal mode
+	VimMode bool
+	// VimModeIndicator returns the prefix of the prompt for the current vi mode, normal is true in the normal mode
+	VimModeIndicator func(normal bool) string
 
-	// AutoCompleter will called once user press TAB
-	//AutoComplete AutoCompleter
+	// specify the number of entries in the kill ring, it's 10 by default
+	KillRingSize int
+	// specify the max number of undo steps, it's 100 by default
+	UndoDepth int
+	// the line can't be edited, but the cursor can be moved and the line can be accepted. the keys bound by
+	// Terminal.Bind still work, so the terminal can act like a pager
+	ReadOnly bool
+
+	// HintProvider returns the hint which is displayed after the line, right arrow at the end of the line accepts it
+	HintProvider func(line []rune, pos int) []rune
+	// HintProvider is called after the line hasn't changed for HintDebounce if it isn't zero, and no hint is displayed
+	// until then, it's useful if HintProvider is slow
+	HintDebounce time.Duration
+	// suggest the rest of the most recent history line which starts with the line as the hint, if the cursor is at
+	// the end of the line and HintProvider doesn't return a hint
+	HistoryAutoSuggest bool
+	// Highlighter returns the style ranges of the line, it's called when the line changes
+	Highlighter func(buf []rune) []StyleRange
+	// ClipboardReader reads the text which is pasted with Meta+V, ReadClipboard is used if it's nil
+	ClipboardReader func() ([]byte, error)
+
+	// Completer will be called once user press TAB
+	Completer Completer
+	// the second TAB within CompletionTimeout displays the completion menu, there is no time limit if it's zero
+	CompletionTimeout time.Duration
+	// CompletionIconProvider returns the icon which is displayed before the completion in the completion menu, like
+	// a Nerd Font glyph. entryType is the file type which Completer returns if it implements CompletionTyper, like
+	// FilePathCompleter, or 0 otherwise
+	CompletionIconProvider func(completion string, entryType os.FileMode) string
+	// the maximum number of the columns of the completion menu, as many as fit in the screen if it's zero
+	CompletionColumns int
+	// Completer is called without the word at the cursor if FuzzyCompletion is true, and its candidates are ranked
+	// by runeutil.FuzzyScore with that word, the best one is selected in the completion menu if it's unique
+	FuzzyCompletion bool
+	// AsyncCompleter is called in another goroutine once user press TAB, it takes precedence over Completer if it's
+	// not nil. typing a key while waiting cancels the completion
+	AsyncCompleter AsyncCompleter
+	// specify the time limit of AsyncCompleter, it's 500ms by default
+	AsyncCompletionTimeout time.Duration
 
 	// filter input runes (may be used to disable CtrlZ or for translating some keys to different actions)
 	// -> output = new (translated) rune and true/false if continue with processing this one
 	FuncFilterInputRune func(rune) (rune, bool)
+
+	// OnKeyPress is called with each key before it's processed, it returns the key to process instead, or nil to
+	// ignore the key
+	OnKeyPress func(key []byte) []byte
+	// OnLineAccepted is called with the line before it's returned, it isn't called on EOF or interrupt
+	OnLineAccepted func(line string)
+	// OSC7Provider returns the current working directory which is emitted by OSC 7 after a line is accepted, so
+	// the terminal emulator can open a new tab in it. Nothing is emitted if it returns an empty string
+	OSC7Provider func() string
+	// the callbacks above are called in the input loop, so they must not block. if CallbackTimeout is positive,
+	// the input loop waits for a callback until the timeout and continues as if it's not set. the callback which
+	// times out isn't cancelled and keeps running, and the next callbacks are skipped until it returns, so
+	// the callbacks don't run concurrently
+	CallbackTimeout time.Duration
 }
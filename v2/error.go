@@ -6,7 +6,17 @@ import (
 
 var (
 	ErrInterrupted = errors.New("interrupted")
+	ErrClosed      = errors.New("terminal closed")
+	ErrInvalidOSC  = errors.New("invalid OSC sequence")
+	ErrReadOnly    = errors.New("buffer is read-only")
+
+	ErrHistoryIndex      = errors.New("history index out of range")
+	ErrHistoryAppendOnly = errors.New("history file is append-only")
 
 	ErrAlreadyInRawMode = errors.New("already in raw mode")
 	ErrNotInRawMode     = errors.New("not in raw mode")
+	ErrNotTerminal      = errors.New("not a terminal")
+
+	ErrNoClipboard       = errors.New("clipboard is not available")
+	ErrClipboardTooLarge = errors.New("clipboard content is too large")
 )
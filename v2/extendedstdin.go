@@ -6,89 +6,105 @@ import (
 	"sync"
 )
 
+// stdinBufferLimit is the size of the data which extendedStdin reads from the real stdin ahead of the reads.
+const stdinBufferLimit = 64 * 1024
+
 // extendedStdin is a stdin reader which can prepend some data before
 // reading into the real stdin.
 type extendedStdin struct {
-	stdin          io.Reader
-	pipe1Reader    *io.PipeReader
-	pipe2Reader    *io.PipeReader
-	pipe2Writer    *io.PipeWriter
-	wg             sync.WaitGroup
-	mu             sync.Mutex
-	buf            *bytes.Buffer
-	pipe1ReaderErr error
+	stdin    io.Reader
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      *bytes.Buffer
+	stdinBuf *bytes.Buffer
+	stdinErr error
+	closed   bool
 }
 
 // newExtendedStdin gives you extendedStdin
 func newExtendedStdin(stdin io.Reader) (io.ReadCloser, io.Writer) {
-	r1, w1 := io.Pipe()
-	r2, w2 := io.Pipe()
 	s := &extendedStdin{
-		stdin:       stdin,
-		pipe1Reader: r1,
-		pipe2Reader: r2,
-		pipe2Writer: w2,
-		buf:         bytes.NewBuffer(make([]byte, 0, 4096)),
+		stdin:    stdin,
+		buf:      bytes.NewBuffer(make([]byte, 0, 4096)),
+		stdinBuf: bytes.NewBuffer(make([]byte, 0, 4096)),
 	}
-	s.wg.Add(1)
-	go s.pipe1Loop()
-	go s.pipe2Loop()
-	return s, w1
+	s.cond = sync.NewCond(&s.mu)
+	go s.stdinLoop()
+	return s, &extendedStdinWriter{s}
 }
 
-func (s *extendedStdin) pipe1Loop() {
-	defer s.wg.Done()
+// stdinLoop reads the real stdin into stdinBuf until an error occurs. It waits while stdinBuf holds
+// stdinBufferLimit bytes, so the real stdin isn't read faster than the reads.
+func (s *extendedStdin) stdinLoop() {
 	buf := make([]byte, 4096)
 	for {
-		var err error
-		var n int
-		n, err = s.pipe1Reader.Read(buf)
 		s.mu.Lock()
-		if n > 0 {
-			_, _ = s.buf.Write(buf[:n])
+		for s.stdinBuf.Len() >= stdinBufferLimit && !s.closed {
+			s.cond.Wait()
 		}
-		s.pipe1ReaderErr = err
+		closed := s.closed
 		s.mu.Unlock()
-		if err != nil {
+		if closed {
 			break
 		}
-	}
-}
-
-func (s *extendedStdin) pipe2Loop() {
-	buf := make([]byte, 4096)
-	for {
-		var err error
-		var n int
-		n, err = s.stdin.Read(buf)
-		var werr error
+		n, err := s.stdin.Read(buf)
+		s.mu.Lock()
 		if n > 0 {
-			_, werr = s.pipe2Writer.Write(buf[:n])
+			_, _ = s.stdinBuf.Write(buf[:n])
 		}
-		if err != nil {
-			_ = s.pipe2Writer.CloseWithError(err)
-			break
-		}
-		if werr != nil {
+		s.stdinErr = err
+		s.cond.Broadcast()
+		closed = s.closed
+		s.mu.Unlock()
+		if err != nil || closed {
 			break
 		}
 	}
 }
 
-// Read will read from the local buffer and if no data, read from stdin
+// Read will read from the local buffer and if no data, read from stdin. It waits until some data is written into
+// the local buffer or read from stdin.
 func (s *extendedStdin) Read(p []byte) (n int, err error) {
 	s.mu.Lock()
-	n, err = s.buf.Read(p)
-	if err == nil || s.pipe1ReaderErr != nil {
-		s.mu.Unlock()
-		return
+	defer s.mu.Unlock()
+	for {
+		switch {
+		case s.closed:
+			return 0, io.EOF
+		case s.buf.Len() > 0:
+			return s.buf.Read(p)
+		case s.stdinBuf.Len() > 0:
+			// stdinLoop may be waiting for the space in stdinBuf
+			s.cond.Broadcast()
+			return s.stdinBuf.Read(p)
+		case s.stdinErr != nil:
+			return 0, s.stdinErr
+		}
+		s.cond.Wait()
 	}
-	s.mu.Unlock()
-	return s.pipe2Reader.Read(p)
 }
 
 func (s *extendedStdin) Close() error {
-	_ = s.pipe1Reader.Close()
-	_ = s.pipe2Writer.Close()
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
 	return nil
 }
+
+// extendedStdinWriter writes into the local buffer of extendedStdin. The written data is available to the next read
+// when Write returns.
+type extendedStdinWriter struct {
+	s *extendedStdin
+}
+
+func (w *extendedStdinWriter) Write(p []byte) (n int, err error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	if w.s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err = w.s.buf.Write(p)
+	w.s.cond.Broadcast()
+	return
+}
@@ -0,0 +1,37 @@
+package readline
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingReader is an endless reader which counts the bytes read from it.
+type countingReader struct {
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	atomic.AddInt64(&r.n, int64(len(p)))
+	return len(p), nil
+}
+
+func TestExtendedStdinLimit(t *testing.T) {
+	r := &countingReader{}
+	stdin, _ := newExtendedStdin(r)
+	defer stdin.Close()
+
+	// the real stdin isn't read further while the buffered data isn't read
+	time.Sleep(50 * time.Millisecond)
+	n := atomic.LoadInt64(&r.n)
+	if n < stdinBufferLimit || n > stdinBufferLimit+4096 {
+		t.Fatalf("%d bytes read from stdin, expected %d", n, stdinBufferLimit)
+	}
+	p := make([]byte, stdinBufferLimit)
+	if _, err := stdin.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return atomic.LoadInt64(&r.n) > n
+	})
+}
@@ -0,0 +1,103 @@
+package readline
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// filePathSeparators is the separators of the directories in the paths. The slash is a separator on all systems.
+const filePathSeparators = "/" + string(filepath.Separator)
+
+// FilePathCompleter is a Completer which completes the file path at the cursor. The path is separated from the rest of
+// the line by spaces, and "~/" at its start is the home directory of the user. The names of the directories are
+// completed with a trailing slash, and the hidden files are completed only if the typed name starts with a dot.
+type FilePathCompleter struct {
+	// Filter returns false if entry isn't a candidate. All entries are candidates if it's nil.
+	Filter func(entry os.DirEntry) bool
+
+	// Style returns the SGR parameter of entry in the completion menu, like "1;34". The entries aren't styled
+	// if it's nil.
+	Style func(entry os.DirEntry) string
+
+	mu     sync.Mutex
+	styles map[string]string
+	types  map[string]os.FileMode
+}
+
+// NewFilePathCompleter creates a new FilePathCompleter.
+func NewFilePathCompleter() *FilePathCompleter {
+	return &FilePathCompleter{}
+}
+
+// Complete implements Completer. The candidates are the names of the entries in the directory of the path, which
+// start with the typed name. An inaccessible directory has no candidates.
+func (c *FilePathCompleter) Complete(line []rune, pos int) (newLine []rune, completions []string, newPos int) {
+	start := pos
+	for start > 0 && !unicode.IsSpace(line[start-1]) {
+		start--
+	}
+	path := string(line[start:pos])
+	dir, name := "", path
+	if i := strings.LastIndexAny(path, filePathSeparators); i >= 0 {
+		dir, name = path[:i+1], path[i+1:]
+	}
+	newPos = pos - len([]rune(name))
+	newLine = append(append([]rune{}, line[:newPos]...), line[pos:]...)
+
+	styles := make(map[string]string)
+	types := make(map[string]os.FileMode)
+	defer func() {
+		c.mu.Lock()
+		c.styles, c.types = styles, types
+		c.mu.Unlock()
+	}()
+
+	readDir := dir
+	if len(readDir) >= 2 && readDir[0] == '~' && strings.IndexByte(filePathSeparators, readDir[1]) >= 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return newLine, nil, newPos
+		}
+		readDir = filepath.Join(home, readDir[2:])
+	}
+	if readDir == "" {
+		readDir = "."
+	}
+	// the entries which are read before an error are still candidates
+	entries, _ := os.ReadDir(readDir)
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), name) || strings.HasPrefix(entry.Name(), ".") && !strings.HasPrefix(name, ".") {
+			continue
+		}
+		if c.Filter != nil && !c.Filter(entry) {
+			continue
+		}
+		completion := entry.Name()
+		if entry.IsDir() {
+			completion += "/"
+		}
+		completions = append(completions, completion)
+		types[completion] = entry.Type()
+		if c.Style != nil {
+			styles[completion] = c.Style(entry)
+		}
+	}
+	return newLine, completions, newPos
+}
+
+// CompletionStyle implements CompletionStyler with Style for the candidates of the last Complete call.
+func (c *FilePathCompleter) CompletionStyle(candidate string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.styles[candidate]
+}
+
+// CompletionType implements CompletionTyper with the types of the entries of the last Complete call.
+func (c *FilePathCompleter) CompletionType(candidate string) os.FileMode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.types[candidate]
+}
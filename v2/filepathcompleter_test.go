@@ -0,0 +1,76 @@
+package readline
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestFilePathCompleter(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"alpha", "beta", ".hidden"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"alps.txt", "beta/gamma.txt", ".profile"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir = filepath.ToSlash(dir)
+
+	c := NewFilePathCompleter()
+	tests := []struct {
+		line        string
+		completions []string
+		newLine     string
+	}{
+		{"cat " + dir + "/al", []string{"alpha/", "alps.txt"}, "cat " + dir + "/"},
+		{"cat " + dir + "/", []string{"alpha/", "alps.txt", "beta/"}, "cat " + dir + "/"},
+		{"cat " + dir + "/.", []string{".hidden/", ".profile"}, "cat " + dir + "/"},
+		{"cat " + dir + "/beta/g", []string{"gamma.txt"}, "cat " + dir + "/beta/"},
+		{"cat " + dir + "/missing/", nil, "cat " + dir + "/missing/"},
+	}
+	for _, test := range tests {
+		line := []rune(test.line)
+		newLine, completions, newPos := c.Complete(line, len(line))
+		if !reflect.DeepEqual(completions, test.completions) {
+			t.Errorf("line %q: completions %q, expected %q", test.line, completions, test.completions)
+		}
+		if string(newLine) != test.newLine || newPos != len([]rune(test.newLine)) {
+			t.Errorf("line %q: new line %q %d, expected %q", test.line, string(newLine), newPos, test.newLine)
+		}
+	}
+
+	c.Filter = func(entry os.DirEntry) bool {
+		return entry.IsDir()
+	}
+	c.Style = func(entry os.DirEntry) string {
+		return "1;34"
+	}
+	line := []rune(dir + "/")
+	if _, completions, _ := c.Complete(line, len(line)); !reflect.DeepEqual(completions, []string{"alpha/", "beta/"}) {
+		t.Fatalf("filtered completions %q, expected [alpha/ beta/]", completions)
+	}
+	if style := c.CompletionStyle("beta/"); style != "1;34" {
+		t.Fatalf("style %q, expected \"1;34\"", style)
+	}
+	if typ := c.CompletionType("beta/"); typ != os.ModeDir {
+		t.Fatalf("type %v, expected %v", typ, os.ModeDir)
+	}
+	lines := completionMenu([]string{"alpha/", "beta/"}, nil, 1, 80, 0, c.CompletionStyle)
+	if expected := []string{"\033[1;34malpha/\033[0m  \033[1;34;7mbeta/\033[0m"}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("menu %q, expected %q", lines, expected)
+	}
+
+	if runtime.GOOS != "windows" {
+		defer setenv(t, "HOME", dir)()
+		line = []rune("~/be")
+		if newLine, completions, _ := c.Complete(line, len(line)); !reflect.DeepEqual(completions, []string{"beta/"}) || string(newLine) != "~/" {
+			t.Fatalf("home completions %q %q, expected [beta/] \"~/\"", string(newLine), completions)
+		}
+	}
+}
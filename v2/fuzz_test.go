@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+package readline
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf8"
+)
+
+// FuzzDecodeEscapeKeyPair decodes arbitrary bytes after the escape character, like the input of WriteStdin.
+func FuzzDecodeEscapeKeyPair(f *testing.F) {
+	for _, seed := range []string{
+		"[A", "[B", "[C", "[D", "OA", "OH", "OF",
+		"OP", "OQ", "[15~", "[24~",
+		"[3~", "[5~", "[200~", "[1;5C", "[27;5;13~",
+		"b", "f", "\x7f", "\n", "[", "[1;", "\xff",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, p []byte) {
+		pair := decodeEscapeKeyPair(p)
+		if pair == nil {
+			if len(p) > 0 {
+				t.Fatalf("%q isn't decoded", p)
+			}
+			return
+		}
+		if !bytes.HasSuffix(p, pair.Remainder) {
+			t.Fatalf("remainder %q isn't a suffix of %q", pair.Remainder, p)
+		}
+		seq := p[:len(p)-len(pair.Remainder)]
+		if len(seq) <= 0 {
+			t.Fatalf("nothing of %q is decoded", p)
+		}
+		// Char is the first rune of the sequence, it's a control character for the Meta+Ctrl keys
+		if r, _ := utf8.DecodeRune(seq); pair.Char != r {
+			t.Fatalf("char %q of %q, expected %q", pair.Char, p, r)
+		}
+		if pair.Type != 0 {
+			if r, _ := utf8.DecodeLastRune(seq); pair.Type != r {
+				t.Fatalf("type %q of %q, expected %q", pair.Type, p, r)
+			}
+		}
+	})
+}
@@ -1,6 +1,6 @@
 module github.com/goinsane/readline/v2
 
-go 1.13
+go 1.16
 
 require (
 	github.com/goinsane/xcontext v1.3.0
@@ -0,0 +1,171 @@
+package readline
+
+import (
+	"unicode/utf8"
+
+	"github.com/goinsane/readline/v2/runeutil"
+)
+
+const (
+	// grepMenuStyle is the SGR parameter of the selected line in the history grep results.
+	grepMenuStyle = "7"
+	// grepMenuRows is the maximum number of the history grep results which are displayed at once.
+	grepMenuRows = 10
+)
+
+// grepState is the state of the history grep, which lists all the history lines matching the pattern.
+type grepState struct {
+	pattern []rune
+	failed  bool
+	// results are the matching lines from the newest to the oldest, and selected is the index of the selected one.
+	results  []string
+	selected int
+
+	// prompt, buf and idx hold the state before the history grep.
+	prompt string
+	buf    []rune
+	idx    int
+}
+
+// grepKey processes key in history grep mode. It returns false if the history grep has been exited and
+// key should be processed normally.
+func (t *Terminal) grepKey(key KeyEvent) bool {
+	g := t.grep
+	switch key {
+	case "\r", "\n":
+		t.grepExit(len(g.results) <= 0)
+
+	case "\x03", "\x07":
+		t.grepExit(true)
+
+	case "\x08", "\x7f":
+		if len(g.pattern) <= 0 {
+			t.bell()
+			break
+		}
+		g.pattern = g.pattern[:len(g.pattern)-1]
+		t.grepUpdate()
+
+	case "\x10", "\x1b[A", "\x1bOA":
+		if g.selected <= 0 {
+			t.bell()
+			break
+		}
+		g.selected--
+		t.grepRender()
+
+	case "\x0e", "\x1b[B", "\x1bOB":
+		if g.selected >= len(g.results)-1 {
+			t.bell()
+			break
+		}
+		g.selected++
+		t.grepRender()
+
+	default:
+		if key[0] < 0x20 {
+			t.grepExit(true)
+			return false
+		}
+		p := []byte(key)
+		for len(p) > 0 {
+			r, size := utf8.DecodeRune(p)
+			g.pattern = append(g.pattern, r)
+			p = p[size:]
+		}
+		t.grepUpdate()
+
+	}
+	return true
+}
+
+func (t *Terminal) grepStart() {
+	t.historySync()
+	t.grep = &grepState{
+		prompt: t.rb.Prompt(),
+		buf:    t.rb.Runes(),
+		idx:    t.rb.Index(),
+	}
+	t.grepRender()
+}
+
+// grepUpdate searches the history by the pattern, and renders the results.
+func (t *Terminal) grepUpdate() {
+	g := t.grep
+	g.failed = false
+	g.results = nil
+	g.selected = 0
+	if len(g.pattern) > 0 {
+		results, err := t.history.SearchWith(string(g.pattern), SearchOptions{
+			CaseInsensitive: t.config.HistorySearchFold,
+			Substring:       true,
+			Regex:           t.config.HistoryRegexSearch,
+		})
+		g.results = results
+		if err != nil || len(results) <= 0 {
+			g.failed = true
+			t.bell()
+		}
+	}
+	t.grepRender()
+}
+
+func (t *Terminal) grepRender() {
+	g := t.grep
+	prompt := "(history-grep) "
+	if g.failed {
+		prompt = "(failed history-grep) "
+	}
+	t.rb.SetPrompt(prompt)
+	t.rb.Set(len(g.pattern), g.pattern)
+	t.rb.SetMenu(grepMenu(g.results, g.selected, t.rb.ScreenWidth()))
+}
+
+// grepExit exits the history grep mode. If restore is true, the buffer before the history grep is restored, and
+// the selected line is set to the buffer otherwise.
+func (t *Terminal) grepExit(restore bool) {
+	g := t.grep
+	t.grep = nil
+	t.rb.SetMenu(nil)
+	t.rb.SetPrompt(g.prompt)
+	if restore {
+		t.rb.Set(g.idx, g.buf)
+		return
+	}
+	line := []rune(g.results[g.selected])
+	t.rb.Set(len(line), line)
+}
+
+// grepMenu returns the menu lines of the history grep results around selected. The lines are cut to fit in
+// screenWidth.
+func grepMenu(results []string, selected int, screenWidth int) []string {
+	start := 0
+	if selected >= grepMenuRows {
+		start = selected - grepMenuRows + 1
+	}
+	end := start + grepMenuRows
+	if end > len(results) {
+		end = len(results)
+	}
+	lines := make([]string, 0, end-start)
+	for i := start; i < end; i++ {
+		line := string(cutWidth([]rune(results[i]), screenWidth-1))
+		if i == selected {
+			line = "\033[" + grepMenuStyle + "m" + line + "\033[0m"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// cutWidth returns the prefix of s which fits in width columns.
+func cutWidth(s []rune, width int) []rune {
+	w := 0
+	for i, r := range s {
+		w += runeutil.Width(r)
+		if w > width {
+			return s[:i]
+		}
+	}
+	return s
+}
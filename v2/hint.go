@@ -0,0 +1,99 @@
+package readline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/goinsane/readline/v2/runeutil"
+)
+
+// hintDebounceState debounces the hint provider. The provider is called in the input loop after the line hasn't
+// changed for delay, and the hint of the line is displayed until the line changes again.
+type hintDebounceState struct {
+	provider func(line []rune, pos int) []rune
+	delay    time.Duration
+	// ch is notified when the timer fires
+	ch chan struct{}
+
+	mu sync.Mutex
+	// line and pos are the last displayed line, and hint is its hint which is valid if ready is true
+	line  []rune
+	pos   int
+	hint  []rune
+	ready bool
+	timer *time.Timer
+}
+
+func newHintDebounceState(provider func(line []rune, pos int) []rune, delay time.Duration) *hintDebounceState {
+	return &hintDebounceState{
+		provider: provider,
+		delay:    delay,
+		ch:       make(chan struct{}, 1),
+		pos:      -1,
+	}
+}
+
+// get is the hint provider of the buffer. It returns the hint of the line if it's ready, and restarts the timer
+// if the line has changed.
+func (h *hintDebounceState) get(line []rune, pos int) []rune {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if pos == h.pos && runeutil.Equal(line, h.line) {
+		return h.hint
+	}
+	h.line, h.pos, h.hint, h.ready = line, pos, nil, false
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	h.timer = time.AfterFunc(h.delay, func() {
+		select {
+		case h.ch <- struct{}{}:
+		default:
+		}
+	})
+	return nil
+}
+
+// update calls the provider with the last displayed line unless its hint is ready. It returns true if the hint is
+// updated.
+func (h *hintDebounceState) update() bool {
+	h.mu.Lock()
+	line, pos, ready := h.line, h.pos, h.ready
+	h.mu.Unlock()
+	if ready {
+		return false
+	}
+	hint := runeutil.Copy(h.provider(runeutil.Copy(line), pos))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if pos != h.pos || !runeutil.Equal(line, h.line) {
+		// the line has changed while the provider is called, and the timer is restarted
+		return false
+	}
+	h.hint, h.ready = hint, true
+	return true
+}
+
+func (h *hintDebounceState) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+}
+
+// hintDebounceCh returns the channel which is notified when the debounced hint should be updated, or nil if the hint
+// provider isn't debounced.
+func (t *Terminal) hintDebounceCh() <-chan struct{} {
+	if t.hintDebounce == nil {
+		return nil
+	}
+	return t.hintDebounce.ch
+}
+
+// hintDebounceDone updates the debounced hint, and redraws the line with it.
+func (t *Terminal) hintDebounceDone() {
+	if t.hintDebounce.update() {
+		t.rb.Refresh(nil)
+	}
+}
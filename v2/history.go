@@ -1,3 +1,792 @@
 package readline
 
-type History []string
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/goinsane/readline/v2/runeutil"
+)
+
+// HistoryFileMode specifies how Terminal writes the lines to Config.HistoryFile.
+type HistoryFileMode int
+
+const (
+	// HistoryFileRewrite rewrites the history file atomically like SaveHistory when a line is added.
+	HistoryFileRewrite HistoryFileMode = iota
+	// HistoryFileAppend appends the added line to the history file after its timestamp line like "#1600000000",
+	// so the lines of the concurrent terminals aren't lost. The file isn't truncated to the history limit. It can be
+	// loaded by LoadHistory with the CommentPrefix "#". History.PopLast and History.Replace change only their line in
+	// the file, and History.Replace returns ErrHistoryAppendOnly with HistoryDupErase, since the file keeps
+	// the duplicates.
+	HistoryFileAppend
+)
+
+// DefaultHistoryLimit is the history limit used when Config.HistoryLimit is zero.
+const DefaultHistoryLimit = 500
+
+// HistorySuggestionStyle is the SGR parameter of the history suggestions of Config.HistoryAutoSuggest.
+const HistorySuggestionStyle = "90"
+
+// MaxHistoryLineLength is the maximum length of a line in bytes written by SaveHistory.
+// Longer lines are truncated before they're escaped.
+const MaxHistoryLineLength = 4096
+
+// HistoryDupPolicy specifies how History.Add handles the duplicate lines.
+type HistoryDupPolicy int
+
+const (
+	// HistoryDupAll adds all lines including the duplicates.
+	HistoryDupAll HistoryDupPolicy = iota
+	// HistoryDupIgnore doesn't add a line which equals the most recent line.
+	HistoryDupIgnore
+	// HistoryDupErase removes the earlier occurrences of a line before adding it.
+	HistoryDupErase
+)
+
+// History holds accepted lines and the state of an in-progress history navigation.
+// It is safe for concurrent use.
+type History struct {
+	mu    sync.Mutex
+	lines []string
+	// tags are the context tags of the lines, the lines without a tag are visible in all contexts
+	tags    []interface{}
+	limit   int
+	backend HistoryBackend
+	// local are the lines which aren't stored in the backend, like after an error of the backend. They're added to
+	// the backend again and kept by sync.
+	local []string
+	// tag is the context tag of the line being read if partitioned is true, only the lines with that tag or without
+	// a tag are visible
+	tag         interface{}
+	partitioned bool
+
+	// CommentPrefix specifies the prefix of the comment lines in history files, like timestamps.
+	// Comment lines are skipped by LoadHistory. It is disabled if it is empty.
+	CommentPrefix string
+
+	// Duplicates specifies how Add handles the duplicate lines.
+	Duplicates HistoryDupPolicy
+
+	// IgnoreSpace specifies whether Add ignores the lines which start with a space.
+	IgnoreSpace bool
+
+	// pos is the navigation position: 0 means the draft, n means the n-th most recent line.
+	pos   int
+	draft []rune
+	// searchPrefix filters the navigation lines if it isn't nil
+	searchPrefix []rune
+	// searchRegexp is the last compiled pattern of SearchWith, it's reused while the pattern is the same
+	searchRegexp *regexp.Regexp
+
+	// prefixIndex maps the prefixes of the lines to the most recent lines which start with them, it's built by
+	// Suggest when it's nil
+	prefixIndex map[string]string
+	// suggestPrefix and suggestLine are the last result of Suggest, it's valid if suggestSet is true
+	suggestPrefix string
+	suggestLine   string
+	suggestOK     bool
+	suggestSet    bool
+}
+
+// SearchOptions specifies how SearchWith matches the history lines.
+type SearchOptions struct {
+	// CaseInsensitive compares the runes with the simple Unicode case folding, like 'k' and 'K'. The full case
+	// folding like "ß" and "SS" isn't supported.
+	CaseInsensitive bool
+	// Substring matches the lines which contain the query, instead of the lines which start with it.
+	Substring bool
+	// Regex matches the lines by the query as a regular expression, Substring is ignored with it.
+	Regex bool
+}
+
+// NewHistory creates a new History which keeps at most limit lines.
+// If limit is zero, DefaultHistoryLimit is used. If limit is negative, history is disabled.
+func NewHistory(limit int) *History {
+	if limit == 0 {
+		limit = DefaultHistoryLimit
+	}
+	return &History{
+		limit: limit,
+	}
+}
+
+// Add appends line to the history according to Duplicates and IgnoreSpace, and resets the navigation.
+// The oldest line is discarded when the limit is reached. The line is added to the backend of Terminal too, and
+// the backend is rewritten without the duplicates by HistoryRewriter if HistoryDupErase removes them.
+func (h *History) Add(line string) {
+	_ = h.addLine(line)
+}
+
+// addLine is Add, and it returns the error of the backend.
+func (h *History) addLine(line string) error {
+	h.mu.Lock()
+	n := len(h.lines)
+	added := h.addPolicy(line)
+	h.reset()
+	backend, all := h.backend, []string(nil)
+	if added && h.Duplicates == HistoryDupErase && len(h.lines) != n+1 {
+		// the duplicates are erased from the backend too
+		all = h.copyLines()
+	}
+	h.mu.Unlock()
+	if !added || backend == nil {
+		return nil
+	}
+	return h.store(backend, []string{line}, all)
+}
+
+// store adds the added lines to backend. If all isn't nil, backend is rewritten with all, which are all history lines
+// after the lines are added, if it implements HistoryRewriter. The lines which can't be stored are kept as local lines.
+func (h *History) store(backend HistoryBackend, added, all []string) error {
+	if rewriter, ok := backend.(HistoryRewriter); ok && all != nil {
+		err := rewriter.Rewrite(all)
+		if err != ErrHistoryAppendOnly {
+			if err != nil {
+				h.keepLocal(added)
+			}
+			return err
+		}
+	}
+	for i, line := range added {
+		if err := backend.Add(line); err != nil {
+			h.keepLocal(added[i:])
+			return err
+		}
+	}
+	return nil
+}
+
+// keepLocal keeps lines as local lines, which aren't stored in the backend.
+func (h *History) keepLocal(lines []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.local = append(h.local, lines...)
+}
+
+// addPolicy appends line according to Duplicates and IgnoreSpace. It returns false if line is ignored.
+func (h *History) addPolicy(line string) bool {
+	if h.limit < 0 || h.IgnoreSpace && strings.HasPrefix(line, " ") {
+		return false
+	}
+	switch h.Duplicates {
+	case HistoryDupIgnore:
+		i := len(h.lines) - 1
+		for i >= 0 && !h.visible(i) {
+			i--
+		}
+		if i >= 0 && h.lines[i] == line {
+			return false
+		}
+
+	case HistoryDupErase:
+		lines, tags := h.lines[:0], h.tags[:0]
+		for i, l := range h.lines {
+			if l != line || !h.visible(i) {
+				lines, tags = append(lines, l), append(tags, h.tags[i])
+			}
+		}
+		if len(lines) != len(h.lines) {
+			h.invalidateIndex()
+		}
+		h.lines, h.tags = lines, tags
+
+	}
+	h.add(line, h.tag)
+	return true
+}
+
+// load replaces the history lines with lines according to Duplicates and IgnoreSpace, and resets the navigation.
+func (h *History) load(lines []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reset()
+	h.lines, h.tags = nil, nil
+	h.invalidateIndex()
+	for _, line := range lines {
+		h.addPolicy(line)
+	}
+}
+
+// add appends line with tag, and discards the oldest lines to keep at most limit lines.
+func (h *History) add(line string, tag interface{}) {
+	n := len(h.lines)
+	h.lines = appendHistoryLine(h.lines, line, h.limit)
+	h.tags = append(h.tags, tag)
+	h.tags = h.tags[len(h.tags)-len(h.lines):]
+	h.suggestSet = false
+	if len(h.lines) != n+1 {
+		// the oldest lines are discarded
+		h.invalidateIndex()
+		return
+	}
+	if h.prefixIndex != nil {
+		h.indexLine(line)
+	}
+}
+
+// visible returns true if the line at idx has the context tag of the line being read or no tag.
+func (h *History) visible(idx int) bool {
+	return h.tags[idx] == nil || h.tags[idx] == h.tag
+}
+
+// setTag sets the context tag of the line being read, which partitions the history. Only the lines with tag or
+// without a tag are visible, and the added lines have tag.
+func (h *History) setTag(tag interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.partitioned = true
+	if tag != h.tag {
+		h.tag = tag
+		h.reset()
+		h.invalidateIndex()
+	}
+}
+
+// invalidateIndex discards the prefix index after the lines are removed, it's built again by Suggest.
+func (h *History) invalidateIndex() {
+	h.prefixIndex = nil
+	h.suggestSet = false
+}
+
+// indexLine maps the prefixes of line to it in the prefix index.
+func (h *History) indexLine(line string) {
+	for i := range line {
+		if i > 0 {
+			h.prefixIndex[line[:i]] = line
+		}
+	}
+	if line != "" {
+		h.prefixIndex[line] = line
+	}
+}
+
+// Suggest returns the most recent line which starts with prefix, if it's longer than prefix. It looks the line up
+// in the prefix index, and the result is reused while prefix is extended by the suggested line, like while typing it.
+func (h *History) Suggest(prefix string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if prefix == "" {
+		return "", false
+	}
+	// the most recent line which starts with the last prefix is also the most recent one which starts with prefix
+	// if it starts with prefix
+	if !h.suggestSet || !strings.HasPrefix(prefix, h.suggestPrefix) || h.suggestOK && !strings.HasPrefix(h.suggestLine, prefix) {
+		if h.prefixIndex == nil {
+			h.prefixIndex = make(map[string]string)
+			for i, line := range h.lines {
+				if h.visible(i) {
+					h.indexLine(line)
+				}
+			}
+		}
+		h.suggestLine, h.suggestOK = h.prefixIndex[prefix]
+		h.suggestPrefix, h.suggestSet = prefix, true
+	}
+	if !h.suggestOK || len(h.suggestLine) <= len(prefix) {
+		return "", false
+	}
+	return h.suggestLine, true
+}
+
+// sync loads the lines from the backend if there is one. The local lines are added to the backend before, and
+// the lines which can't be added are kept after the lines of the backend. The lines aren't loaded again after
+// the history is partitioned, since the backend doesn't store the context tags.
+func (h *History) sync() error {
+	h.mu.Lock()
+	backend, partitioned, local := h.backend, h.partitioned, h.local
+	if backend == nil || partitioned {
+		h.mu.Unlock()
+		return nil
+	}
+	h.local = nil
+	h.mu.Unlock()
+	err := h.store(backend, local, nil)
+	lines, eerr := backend.Entries()
+	if eerr != nil {
+		return eerr
+	}
+	h.mu.Lock()
+	local = h.local
+	h.mu.Unlock()
+	h.load(append(lines, local...))
+	return err
+}
+
+// Len returns the number of lines in the history, including the lines of the other contexts.
+func (h *History) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.lines)
+}
+
+// Entries returns a copy of the history lines from the oldest to the most recent. Only the lines which are visible
+// in the context of the last read are returned, if the history is partitioned by Config.HistoryContextKey.
+func (h *History) Entries() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make([]string, 0, len(h.lines))
+	for i, line := range h.lines {
+		if h.visible(i) {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// AllEntries returns a copy of all history lines from the oldest to the most recent, including the lines of
+// the other contexts, like for saving the history.
+func (h *History) AllEntries() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make([]string, len(h.lines))
+	copy(result, h.lines)
+	return result
+}
+
+// Clear removes all lines and resets the navigation.
+func (h *History) Clear() {
+	h.mu.Lock()
+	h.reset()
+	h.lines, h.tags = nil, nil
+	h.invalidateIndex()
+	backend := h.backend
+	h.mu.Unlock()
+	if backend != nil {
+		_ = backend.Clear()
+	}
+}
+
+// Reset resets the navigation to the draft without changing the history lines.
+func (h *History) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reset()
+}
+
+func (h *History) reset() {
+	h.pos = 0
+	h.draft = nil
+	h.searchPrefix = nil
+}
+
+// SetSearchPrefix sets the prefix of the lines which Older and Newer return, the lines aren't filtered if prefix is
+// nil. The prefix is cleared when the navigation is reset.
+func (h *History) SetSearchPrefix(prefix []rune) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.searchPrefix = runeutil.Copy(prefix)
+}
+
+// SearchPrefix returns the prefix which is set by SetSearchPrefix, or nil if the lines aren't filtered.
+func (h *History) SearchPrefix() []rune {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return runeutil.Copy(h.searchPrefix)
+}
+
+// match returns true if the n-th most recent line is visible and starts with the search prefix.
+func (h *History) match(n int) bool {
+	idx := len(h.lines) - n
+	return h.visible(idx) && (h.searchPrefix == nil || runeutil.HasPrefix([]rune(h.lines[idx]), h.searchPrefix))
+}
+
+// navigating returns true if the navigation isn't at the draft.
+func (h *History) navigating() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.pos > 0
+}
+
+// Older moves the navigation one line back and returns that line.
+// current is saved as the draft when the navigation starts.
+// It returns false if there is no older line.
+func (h *History) Older(current []rune) ([]rune, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pos := h.pos + 1
+	for pos <= len(h.lines) && !h.match(pos) {
+		pos++
+	}
+	if pos > len(h.lines) {
+		return nil, false
+	}
+	if h.pos == 0 {
+		h.draft = runeutil.Copy(current)
+	}
+	h.pos = pos
+	return []rune(h.lines[len(h.lines)-h.pos]), true
+}
+
+// Newer moves the navigation one line forward and returns that line.
+// It returns the saved draft when the navigation reaches the end of history,
+// and false if the navigation is already at the draft.
+func (h *History) Newer() ([]rune, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pos <= 0 {
+		return nil, false
+	}
+	h.pos--
+	for h.pos > 0 && !h.match(h.pos) {
+		h.pos--
+	}
+	if h.pos == 0 {
+		draft := h.draft
+		h.draft = nil
+		return draft, true
+	}
+	return []rune(h.lines[len(h.lines)-h.pos]), true
+}
+
+// LoadHistory reads the history file at path and appends its lines to the history according to Duplicates and
+// IgnoreSpace. Only the most recent lines are kept up to the history limit. The lines are added to the backend of
+// Terminal too.
+func (h *History) LoadHistory(path string) error {
+	lines, err := readHistoryFile(path, h.CommentPrefix)
+
+	h.mu.Lock()
+	h.reset()
+	var added []string
+	for _, line := range lines {
+		if h.addPolicy(line) {
+			added = append(added, line)
+		}
+	}
+	backend, all := h.backend, h.copyLines()
+	h.mu.Unlock()
+	if backend != nil && len(added) > 0 {
+		if serr := h.store(backend, added, all); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// SaveHistory writes all history lines to the file at path with permission 0600.
+// The file is replaced atomically by writing to a temporary file and renaming it.
+// The newlines, the carriage returns and the backslashes in the lines are escaped as \n, \r and \\, and LoadHistory
+// unescapes them, so a multi-line entry is kept as one line. The file starts with a header line which marks that its
+// lines are escaped, the lines of the files without it, like the files written before the escaping, aren't unescaped.
+func (h *History) SaveHistory(path string) error {
+	return writeHistoryFile(path, h.AllEntries())
+}
+
+// readHistoryFile reads the lines of the history file at path. The empty lines and the lines which start with
+// commentPrefix are skipped, if commentPrefix isn't empty. It returns the lines which are read before an error.
+func readHistoryFile(path string, commentPrefix string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var escaped bool
+	return readHistoryLines(f, commentPrefix, &escaped)
+}
+
+// readHistoryLines reads the lines of a history file from r like readHistoryFile. The header line sets escaped to
+// true, and the lines are unescaped if escaped is true.
+func readHistoryLines(r io.Reader, commentPrefix string, escaped *bool) ([]string, error) {
+	var lines []string
+	var err error
+	br := bufio.NewReader(r)
+	for {
+		var line string
+		line, err = br.ReadString('\n')
+		line = trimHistoryLine(line)
+		switch {
+		case line == historyFileHeader:
+			*escaped = true
+		case line != "" && (commentPrefix == "" || !strings.HasPrefix(line, commentPrefix)):
+			if *escaped {
+				line = unescapeHistoryLine(line)
+			}
+			lines = append(lines, line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != io.EOF {
+		return lines, err
+	}
+	return lines, nil
+}
+
+// trimHistoryLine trims the line ending of a raw line of a history file.
+func trimHistoryLine(raw string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(raw, "\n"), "\r")
+}
+
+// writeHistoryFile writes lines to the history file at path like SaveHistory.
+func writeHistoryFile(path string, lines []string) error {
+	return replaceHistoryFile(path, func(bw *bufio.Writer) {
+		_, _ = bw.WriteString(historyFileHeader + "\n")
+		for _, line := range lines {
+			_, _ = bw.WriteString(formatHistoryLine(line, true))
+			_ = bw.WriteByte('\n')
+		}
+	}, nil)
+}
+
+// replaceHistoryFile replaces the history file at path atomically with the data which is written by write. If check
+// isn't nil, it's called before the rename, and the file isn't replaced if it returns an error.
+func replaceHistoryFile(path string, write func(bw *bufio.Writer), check func() error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+
+	bw := bufio.NewWriter(f)
+	write(bw)
+	err = bw.Flush()
+	if err == nil {
+		err = f.Chmod(0600)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && check != nil {
+		err = check()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// historyFileHeader is the first line of the history files whose lines are escaped by escapeHistoryLine.
+const historyFileHeader = "#readline-history escaped"
+
+// historyLineEscaper escapes the characters of a line which break it in a history file.
+var historyLineEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+
+// escapeHistoryLine escapes the backslashes, the newlines and the carriage returns in line, so it's written as one
+// line to a history file.
+func escapeHistoryLine(line string) string {
+	return historyLineEscaper.Replace(line)
+}
+
+// unescapeHistoryLine reverses escapeHistoryLine. The other backslashes are kept as they are.
+func unescapeHistoryLine(line string) string {
+	if !strings.Contains(line, "\\") {
+		return line
+	}
+	var sb strings.Builder
+	sb.Grow(len(line))
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' && i+1 < len(line) {
+			switch line[i+1] {
+			case '\\':
+				c = '\\'
+				i++
+			case 'n':
+				c = '\n'
+				i++
+			case 'r':
+				c = '\r'
+				i++
+			}
+		}
+		_ = sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// formatHistoryLine returns line as it's written to a history file. It's escaped if escaped is true, otherwise its
+// newlines and carriage returns are replaced with spaces, so it's kept as one line.
+func formatHistoryLine(line string, escaped bool) string {
+	line = truncateHistoryLine(line)
+	if escaped {
+		return escapeHistoryLine(line)
+	}
+	return strings.NewReplacer("\n", " ", "\r", " ").Replace(line)
+}
+
+func truncateHistoryLine(line string) string {
+	if len(line) <= MaxHistoryLineLength {
+		return line
+	}
+	line = line[:MaxHistoryLineLength]
+	for len(line) > 0 && !utf8.ValidString(line) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// Line returns the history line at idx. idx 0 is the oldest line.
+func (h *History) Line(idx int) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if idx < 0 || idx >= len(h.lines) {
+		return "", false
+	}
+	return h.lines[idx], true
+}
+
+// PopLast removes the most recent line and returns it, like after the command of the line failed. It returns false
+// if the history is empty. The navigation keeps its line, or moves to the draft if its line is removed.
+// The line is removed from the backend of Terminal too.
+func (h *History) PopLast() (string, bool) {
+	h.mu.Lock()
+	n := len(h.lines)
+	if n <= 0 {
+		h.mu.Unlock()
+		return "", false
+	}
+	line := h.lines[n-1]
+	h.lines, h.tags = h.lines[:n-1], h.tags[:n-1]
+	if h.pos > 0 {
+		h.pos--
+	}
+	h.invalidateIndex()
+	// the most recent line is the most recent line of the backend with HistoryDupErase too
+	backend, lines := h.backend, h.copyLines()
+	h.mu.Unlock()
+	_ = editHistoryBackend(backend, false, lines, func(editor HistoryEditor) error {
+		return editor.PopLast()
+	})
+	return line, true
+}
+
+// Replace replaces the history line at idx with line, like after the alias expansion. idx 0 is the oldest line.
+// It returns ErrHistoryIndex if idx is out of range. The line is replaced in the backend of Terminal too, and the
+// error of the backend is returned.
+func (h *History) Replace(idx int, line string) error {
+	h.mu.Lock()
+	if idx < 0 || idx >= len(h.lines) {
+		h.mu.Unlock()
+		return ErrHistoryIndex
+	}
+	h.lines[idx] = line
+	h.invalidateIndex()
+	backend, erase, lines := h.backend, h.Duplicates == HistoryDupErase, h.copyLines()
+	h.mu.Unlock()
+	return editHistoryBackend(backend, erase, lines, func(editor HistoryEditor) error {
+		return editor.Replace(idx, line)
+	})
+}
+
+// copyLines returns a copy of the history lines if there is a backend.
+func (h *History) copyLines() []string {
+	if h.backend == nil {
+		return nil
+	}
+	lines := make([]string, len(h.lines))
+	copy(lines, h.lines)
+	return lines
+}
+
+// editHistoryBackend applies edit to backend if it implements HistoryEditor, otherwise it rewrites backend with
+// lines, which are the history lines after the edit. The backend keeps the duplicates which HistoryDupErase removes
+// from the history lines, so their indexes differ and it's rewritten when erase is true too. The backend is rewritten
+// at once by HistoryRewriter if it implements it.
+func editHistoryBackend(backend HistoryBackend, erase bool, lines []string, edit func(HistoryEditor) error) error {
+	if backend == nil {
+		return nil
+	}
+	if editor, ok := backend.(HistoryEditor); ok && !erase {
+		return edit(editor)
+	}
+	if rewriter, ok := backend.(HistoryRewriter); ok {
+		return rewriter.Rewrite(lines)
+	}
+	if err := backend.Clear(); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if err := backend.Add(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search searches query in the history lines beginning from the line at start, moving to the older
+// lines if backward is true or to the newer lines otherwise. It returns the index of the matched line and
+// the position of query in that line. If there is no match, it returns -1 for both.
+func (h *History) Search(query []rune, start int, backward, fold bool) (idx int, pos int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if backward {
+		if start >= len(h.lines) {
+			start = len(h.lines) - 1
+		}
+		for i := start; i >= 0; i-- {
+			if !h.visible(i) {
+				continue
+			}
+			if pos = searchLine([]rune(h.lines[i]), query, backward, fold); pos >= 0 {
+				return i, pos
+			}
+		}
+	} else {
+		if start < 0 {
+			start = 0
+		}
+		for i := start; i < len(h.lines); i++ {
+			if !h.visible(i) {
+				continue
+			}
+			if pos = searchLine([]rune(h.lines[i]), query, backward, fold); pos >= 0 {
+				return i, pos
+			}
+		}
+	}
+	return -1, -1
+}
+
+// SearchWith returns the history lines which match query by opts, from the newest to the oldest. It returns
+// the error if opts.Regex is true and query isn't a valid regular expression.
+func (h *History) SearchWith(query string, opts SearchOptions) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var match func(line string) bool
+	if opts.Regex {
+		expr := query
+		if opts.CaseInsensitive {
+			expr = "(?i)" + expr
+		}
+		if h.searchRegexp == nil || h.searchRegexp.String() != expr {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, err
+			}
+			h.searchRegexp = re
+		}
+		match = h.searchRegexp.MatchString
+	} else {
+		q := []rune(query)
+		match = func(line string) bool {
+			l := []rune(line)
+			switch {
+			case opts.Substring && opts.CaseInsensitive:
+				return runeutil.IndexAllFold(l, q) >= 0
+			case opts.Substring:
+				return runeutil.IndexAll(l, q) >= 0
+			case opts.CaseInsensitive:
+				return runeutil.HasPrefixFold(l, q)
+			default:
+				return runeutil.HasPrefix(l, q)
+			}
+		}
+	}
+	var lines []string
+	for i := len(h.lines) - 1; i >= 0; i-- {
+		if h.visible(i) && match(h.lines[i]) {
+			lines = append(lines, h.lines[i])
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,769 @@
+package readline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHistoryOlderNewer(t *testing.T) {
+	h := NewHistory(0)
+	if _, ok := h.Older([]rune("draft")); ok {
+		t.Fatal("older succeeded on empty history")
+	}
+	h.Add("a")
+	h.Add("b")
+
+	line, ok := h.Older([]rune("draft"))
+	if !ok || string(line) != "b" {
+		t.Fatalf("older %q %v, expected \"b\" true", string(line), ok)
+	}
+	line, ok = h.Older([]rune("ignored"))
+	if !ok || string(line) != "a" {
+		t.Fatalf("older %q %v, expected \"a\" true", string(line), ok)
+	}
+	if _, ok = h.Older(nil); ok {
+		t.Fatal("older succeeded past the oldest line")
+	}
+	line, ok = h.Newer()
+	if !ok || string(line) != "b" {
+		t.Fatalf("newer %q %v, expected \"b\" true", string(line), ok)
+	}
+	line, ok = h.Newer()
+	if !ok || string(line) != "draft" {
+		t.Fatalf("newer %q %v, expected \"draft\" true", string(line), ok)
+	}
+	if _, ok = h.Newer(); ok {
+		t.Fatal("newer succeeded past the draft")
+	}
+}
+
+func TestHistoryPopLastReplace(t *testing.T) {
+	h := NewHistory(0)
+	if _, ok := h.PopLast(); ok {
+		t.Fatal("PopLast succeeded on empty history")
+	}
+	h.Add("a")
+	h.Add("b")
+	h.Add("c")
+	if line, ok := h.PopLast(); !ok || line != "c" {
+		t.Fatalf("PopLast %q %v, expected \"c\" true", line, ok)
+	}
+	if entries := h.Entries(); !reflect.DeepEqual(entries, []string{"a", "b"}) {
+		t.Fatalf("entries %q, expected [a b]", entries)
+	}
+	if line, ok := h.Older([]rune("draft")); !ok || string(line) != "b" {
+		t.Fatalf("older %q %v, expected \"b\" true", string(line), ok)
+	}
+	if line, ok := h.Older(nil); !ok || string(line) != "a" {
+		t.Fatalf("older %q %v, expected \"a\" true", string(line), ok)
+	}
+	if line, ok := h.Newer(); !ok || string(line) != "b" {
+		t.Fatalf("newer %q %v, expected \"b\" true", string(line), ok)
+	}
+
+	// the navigation keeps its line "a" after "b" is removed
+	h.Older(nil)
+	if line, ok := h.PopLast(); !ok || line != "b" {
+		t.Fatalf("PopLast %q %v, expected \"b\" true", line, ok)
+	}
+	if _, ok := h.Older(nil); ok {
+		t.Fatal("older succeeded past the oldest line")
+	}
+	if line, ok := h.Newer(); !ok || string(line) != "draft" {
+		t.Fatalf("newer %q %v, expected \"draft\" true", string(line), ok)
+	}
+
+	if s, ok := h.Suggest("x"); ok {
+		t.Fatalf("suggestion %q, expected none", s)
+	}
+	if err := h.Replace(0, "xy"); err != nil {
+		t.Fatal(err)
+	}
+	if line, ok := h.Line(0); !ok || line != "xy" {
+		t.Fatalf("line %q %v, expected \"xy\" true", line, ok)
+	}
+	if s, ok := h.Suggest("x"); !ok || s != "xy" {
+		t.Fatalf("suggestion %q %v, expected \"xy\" true", s, ok)
+	}
+	for _, idx := range []int{-1, 1} {
+		if err := h.Replace(idx, "y"); err != ErrHistoryIndex {
+			t.Errorf("Replace(%d): error %v, expected ErrHistoryIndex", idx, err)
+		}
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	h := NewHistory(2)
+	h.Add("a")
+	h.Add("b")
+	h.Add("c")
+	if entries := h.Entries(); !reflect.DeepEqual(entries, []string{"b", "c"}) {
+		t.Fatalf("entries %q, expected [b c]", entries)
+	}
+
+	h = NewHistory(-1)
+	h.Add("a")
+	if n := h.Len(); n != 0 {
+		t.Fatalf("length %d of disabled history, expected 0", n)
+	}
+}
+
+func TestHistoryDuplicates(t *testing.T) {
+	tests := []struct {
+		policy   HistoryDupPolicy
+		expected []string
+	}{
+		{HistoryDupAll, []string{"a", "b", "b", "a", "c", "a"}},
+		{HistoryDupIgnore, []string{"a", "b", "a", "c", "a"}},
+		{HistoryDupErase, []string{"b", "c", "a"}},
+	}
+	for _, test := range tests {
+		h := NewHistory(0)
+		h.Duplicates = test.policy
+		for _, line := range []string{"a", "b", "b", "a", "c", "a"} {
+			h.Add(line)
+		}
+		if entries := h.Entries(); !reflect.DeepEqual(entries, test.expected) {
+			t.Errorf("policy %d: entries %q, expected %q", test.policy, entries, test.expected)
+		}
+	}
+}
+
+func TestHistoryContext(t *testing.T) {
+	h := NewHistory(0)
+	h.Add("shared")
+	h.setTag("sql")
+	h.Add("select 1")
+	h.setTag("shell")
+	h.Add("ls")
+	h.setTag("sql")
+	h.Add("select 2")
+
+	if entries := h.Entries(); !reflect.DeepEqual(entries, []string{"shared", "select 1", "select 2"}) {
+		t.Fatalf("sql entries %q", entries)
+	}
+	if entries := h.AllEntries(); !reflect.DeepEqual(entries, []string{"shared", "select 1", "ls", "select 2"}) {
+		t.Fatalf("all entries %q", entries)
+	}
+	for _, expected := range []string{"select 2", "select 1", "shared"} {
+		if line, ok := h.Older(nil); !ok || string(line) != expected {
+			t.Fatalf("sql older %q %v, expected %q true", string(line), ok, expected)
+		}
+	}
+	if _, ok := h.Older(nil); ok {
+		t.Fatal("sql older succeeded past the oldest line")
+	}
+
+	h.setTag("shell")
+	for _, expected := range []string{"ls", "shared"} {
+		if line, ok := h.Older(nil); !ok || string(line) != expected {
+			t.Fatalf("shell older %q %v, expected %q true", string(line), ok, expected)
+		}
+	}
+	if idx, _ := h.Search([]rune("select"), h.Len(), true, false); idx != -1 {
+		t.Fatalf("shell search found the sql line %d", idx)
+	}
+	if s, ok := h.Suggest("sel"); ok {
+		t.Fatalf("shell suggestion %q", s)
+	}
+	h.setTag("sql")
+	if idx, _ := h.Search([]rune("select"), h.Len(), true, false); idx != 3 {
+		t.Fatalf("sql search index %d, expected 3", idx)
+	}
+	if s, ok := h.Suggest("sel"); !ok || s != "select 2" {
+		t.Fatalf("sql suggestion %q %v, expected \"select 2\" true", s, ok)
+	}
+}
+
+func TestHistoryIgnoreSpace(t *testing.T) {
+	h := NewHistory(0)
+	h.Add(" a")
+	h.IgnoreSpace = true
+	h.Add(" b")
+	h.Add("c ")
+	if entries := h.Entries(); !reflect.DeepEqual(entries, []string{" a", "c "}) {
+		t.Fatalf("entries %q, expected [\" a\" \"c \"]", entries)
+	}
+}
+
+func TestHistorySaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+
+	h := NewHistory(0)
+	h.Add("first")
+	h.Add("ikinci satır")
+	h.Add(strings.Repeat("x", MaxHistoryLineLength+10))
+	if err := h.SaveHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0600 {
+			t.Fatalf("file permission %o, expected 600", perm)
+		}
+	}
+
+	h = NewHistory(0)
+	if err := h.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"first", "ikinci satır", strings.Repeat("x", MaxHistoryLineLength)}
+	if entries := h.Entries(); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("entries %q, expected %q", entries, expected)
+	}
+}
+
+func TestHistorySaveLoadMultiLine(t *testing.T) {
+	dir := t.TempDir()
+	lines := []string{"for i in 1 2\ndo echo $i\ndone", `echo a\nb\\`, "x\r\\"}
+
+	path := filepath.Join(dir, "history")
+	h := NewHistory(0)
+	for _, line := range lines {
+		h.Add(line)
+	}
+	if err := h.SaveHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	p, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := historyFileHeader + "\n" + `for i in 1 2\ndo echo $i\ndone` + "\n" + `echo a\\nb\\\\` + "\n" + `x\r\\` + "\n"; string(p) != expected {
+		t.Fatalf("history file %q, expected %q", p, expected)
+	}
+	h = NewHistory(0)
+	if err := h.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if entries := h.Entries(); !reflect.DeepEqual(entries, lines) {
+		t.Fatalf("entries %q, expected %q", entries, lines)
+	}
+
+	for _, backend := range []HistoryBackend{
+		newFileHistory(filepath.Join(dir, "rewrite"), "", 10),
+		newAppendFileHistory(filepath.Join(dir, "append"), "", 10),
+	} {
+		for _, line := range lines {
+			if err := backend.Add(line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if entries, err := backend.Entries(); err != nil || !reflect.DeepEqual(entries, lines) {
+			t.Fatalf("entries %q %v, expected %q", entries, err, lines)
+		}
+	}
+
+	// the lines of the files written before the escaping aren't unescaped
+	legacy := []string{`dir C:\tmp\`, `cd C:\new`, `ls \\server`}
+	if err := os.WriteFile(path, []byte(strings.Join(legacy, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h = NewHistory(0)
+	if err := h.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if entries := h.Entries(); !reflect.DeepEqual(entries, legacy) {
+		t.Fatalf("entries %q, expected %q", entries, legacy)
+	}
+	if err := h.SaveHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	h = NewHistory(0)
+	if err := h.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if entries := h.Entries(); !reflect.DeepEqual(entries, legacy) {
+		t.Fatalf("entries %q after saving, expected %q", entries, legacy)
+	}
+
+	// the lines appended to a file without the header aren't escaped
+	legacyPath := filepath.Join(dir, "legacy")
+	if err := os.WriteFile(legacyPath, []byte(`cd C:\new`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	backend := newAppendFileHistory(legacyPath, "", 10)
+	if err := backend.Add("a\nb"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := backend.Entries(); err != nil || !reflect.DeepEqual(entries, []string{`cd C:\new`, "a b"}) {
+		t.Fatalf("entries %q %v, expected [cd C:\\new \"a b\"]", entries, err)
+	}
+}
+
+func TestHistoryLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := NewHistory(0).LoadHistory(path); !os.IsNotExist(err) {
+		t.Fatalf("error %v, expected not exist error", err)
+	}
+
+	if err := os.WriteFile(path, []byte("#1600000000\na\r\n\n#1600000001\nb\nc"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHistory(2)
+	h.CommentPrefix = "#"
+	if err := h.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if entries := h.Entries(); !reflect.DeepEqual(entries, []string{"b", "c"}) {
+		t.Fatalf("entries %q, expected [b c]", entries)
+	}
+
+	if err := os.WriteFile(path, []byte("a\n b\nc\na\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h = NewHistory(0)
+	h.Duplicates = HistoryDupErase
+	h.IgnoreSpace = true
+	if err := h.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if entries := h.Entries(); !reflect.DeepEqual(entries, []string{"c", "a"}) {
+		t.Fatalf("entries %q, expected [c a]", entries)
+	}
+}
+
+func TestTerminalHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	term, stdin := newTestTerminal(t, Config{HistoryFile: path})
+
+	if line := writeAndReadLine(t, term, stdin, "\x10\r"); line != "old" {
+		t.Fatalf("line %q, expected \"old\"", line)
+	}
+	writeAndReadLine(t, term, stdin, "new\r")
+	p, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := historyFileHeader + "\nold\nold\nnew\n"; string(p) != expected {
+		t.Fatalf("history file %q, expected %q", p, expected)
+	}
+}
+
+func TestFileHistoryRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	f := newFileHistory(path, "", 2)
+	if err := f.Add("old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Rewrite([]string{"a", "b\nc", "d"}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := historyFileHeader + "\n" + `b\nc` + "\nd\n"; string(p) != expected {
+		t.Fatalf("history file %q, expected %q", p, expected)
+	}
+}
+
+func TestTerminalLoadHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	term, stdin := newTestTerminal(t, Config{})
+	if err := term.History().LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if line := writeAndReadLine(t, term, stdin, "\x10\r"); line != "b" {
+		t.Fatalf("line %q, expected \"b\"", line)
+	}
+	if line := writeAndReadLine(t, term, stdin, "\x10\x10\x10\r"); line != "a" {
+		t.Fatalf("line %q, expected \"a\"", line)
+	}
+	if entries := term.History().Entries(); !reflect.DeepEqual(entries, []string{"a", "b", "b", "a"}) {
+		t.Fatalf("entries %q, expected [a b b a]", entries)
+	}
+}
+
+func TestTerminalHistoryFileDupErase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	config := Config{HistoryFile: path, HistoryLimit: 4, HistoryDuplicates: HistoryDupErase}
+	term, stdin := newTestTerminal(t, config)
+	for _, input := range []string{"keep1\r", "keep2\r", "ls\r", "cd\r", "ls\r", "cd\r"} {
+		writeAndReadLine(t, term, stdin, input)
+	}
+	expected := []string{"keep1", "keep2", "ls", "cd"}
+	term.historySync()
+	if entries := term.History().Entries(); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("entries %q, expected %q", entries, expected)
+	}
+
+	term, _ = newTestTerminal(t, config)
+	if entries := term.History().Entries(); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("entries %q after reopening, expected %q", entries, expected)
+	}
+}
+
+func TestAppendFileHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	f := newAppendFileHistory(path, "", 3)
+	if lines, err := f.Entries(); err != nil || len(lines) != 0 {
+		t.Fatalf("entries %q %v of the missing file, expected none", lines, err)
+	}
+	for _, line := range []string{"a", "b"} {
+		if err := f.Add(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(p), "\n")
+	if len(lines) != 6 || lines[0] != historyFileHeader || !isHistoryTimestamp(lines[1]) || lines[2] != "a" ||
+		!isHistoryTimestamp(lines[3]) || lines[4] != "b" {
+		t.Fatalf("history file %q", p)
+	}
+
+	// the incomplete line is read after it's completed
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString("#1\nc"); err != nil {
+		t.Fatal(err)
+	}
+	if lines, err := f.Entries(); err != nil || !reflect.DeepEqual(lines, []string{"a", "b"}) {
+		t.Fatalf("entries %q %v, expected [a b]", lines, err)
+	}
+	if _, err := file.WriteString("d\n#x\n"); err != nil {
+		t.Fatal(err)
+	}
+	if lines, err := f.Entries(); err != nil || !reflect.DeepEqual(lines, []string{"b", "cd", "#x"}) {
+		t.Fatalf("entries %q %v, expected [b cd #x]", lines, err)
+	}
+
+	if err := f.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Len(); err != nil || n != 0 {
+		t.Fatalf("length %d %v after clear, expected 0", n, err)
+	}
+	if err := f.Add("e"); err != nil {
+		t.Fatal(err)
+	}
+	if lines, err := f.Entries(); err != nil || !reflect.DeepEqual(lines, []string{"e"}) {
+		t.Fatalf("entries %q %v, expected [e]", lines, err)
+	}
+}
+
+func TestAppendFileHistoryEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte("#1\na\n#2\nb\n#3\nc\n#4\nd\n#5\ne"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f := newAppendFileHistory(path, "", 2)
+	if err := f.PopLast(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Replace(0, "B"); err != nil {
+		t.Fatal(err)
+	}
+	for _, idx := range []int{-1, 2} {
+		if err := f.Replace(idx, "x"); err != ErrHistoryIndex {
+			t.Errorf("Replace(%d): error %v, expected ErrHistoryIndex", idx, err)
+		}
+	}
+	// the lines beyond the limit, the timestamps and the incomplete line are kept
+	p, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "#1\na\n#2\nB\n#3\nc\n#5\ne"; string(p) != expected {
+		t.Fatalf("history file %q, expected %q", p, expected)
+	}
+	if lines, err := f.Entries(); err != nil || !reflect.DeepEqual(lines, []string{"B", "c"}) {
+		t.Fatalf("entries %q %v, expected [B c]", lines, err)
+	}
+	if err := f.Rewrite([]string{"a"}); err != ErrHistoryAppendOnly {
+		t.Fatalf("Rewrite: error %v, expected ErrHistoryAppendOnly", err)
+	}
+}
+
+func TestTerminalHistoryFileAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	config := Config{HistoryFile: path, HistoryFileMode: HistoryFileAppend, HistoryAutoRefresh: true}
+	term1, stdin1 := newTestTerminal(t, config)
+	term2, stdin2 := newTestTerminal(t, config)
+
+	writeAndReadLine(t, term1, stdin1, "one\r")
+	writeAndReadLine(t, term2, stdin2, "two\r")
+	writeAndReadLine(t, term1, stdin1, "three\r")
+	// the lines of the other terminal are loaded when a read starts
+	writeAndReadLine(t, term2, stdin2, "four\r")
+	expected := []string{"one", "two", "three", "four"}
+	if entries := term2.History().Entries(); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("entries %q, expected %q", entries, expected)
+	}
+	if line := writeAndReadLine(t, term1, stdin1, "\x10\r"); line != "four" {
+		t.Fatalf("line %q, expected \"four\"", line)
+	}
+
+	expected = append(expected, "four")
+	for i, term := range []*Terminal{term1, term2} {
+		term.historySync()
+		if entries := term.History().Entries(); !reflect.DeepEqual(entries, expected) {
+			t.Errorf("terminal %d: entries %q, expected %q", i+1, entries, expected)
+		}
+	}
+
+	h := NewHistory(0)
+	h.CommentPrefix = "#"
+	if err := h.LoadHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	if entries := h.Entries(); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("loaded entries %q, expected %q", entries, expected)
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	h := NewHistory(0)
+	for _, s := range []string{"abc", "xAbx", "abab"} {
+		h.Add(s)
+	}
+	tests := []struct {
+		query    string
+		start    int
+		backward bool
+		fold     bool
+		idx, pos int
+	}{
+		{"ab", 10, true, false, 2, 2},
+		{"ab", 1, true, false, 0, 0},
+		{"ab", 1, true, true, 1, 1},
+		{"ab", 0, false, false, 0, 0},
+		{"ab", 1, false, false, 2, 0},
+		{"zz", 2, true, false, -1, -1},
+	}
+	for _, test := range tests {
+		idx, pos := h.Search([]rune(test.query), test.start, test.backward, test.fold)
+		if idx != test.idx || pos != test.pos {
+			t.Errorf("search %+v: got %d %d", test, idx, pos)
+		}
+	}
+}
+
+func TestHistorySearchWith(t *testing.T) {
+	h := NewHistory(0)
+	for _, s := range []string{"git status", "Git log", "ls -l", "straße", "STRAẞE", "STRASSE", "go test ./..."} {
+		h.Add(s)
+	}
+	tests := []struct {
+		query    string
+		opts     SearchOptions
+		expected []string
+	}{
+		{"git", SearchOptions{}, []string{"git status"}},
+		{"git", SearchOptions{CaseInsensitive: true}, []string{"Git log", "git status"}},
+		{"LOG", SearchOptions{Substring: true}, nil},
+		{"LOG", SearchOptions{CaseInsensitive: true, Substring: true}, []string{"Git log"}},
+		{"straße", SearchOptions{CaseInsensitive: true}, []string{"STRAẞE", "straße"}},
+		{"t", SearchOptions{Substring: true}, []string{"go test ./...", "straße", "Git log", "git status"}},
+		{"^g.t ", SearchOptions{Regex: true}, []string{"git status"}},
+		{"^g.t ", SearchOptions{Regex: true, CaseInsensitive: true}, []string{"Git log", "git status"}},
+		{`\./\.\.\.$`, SearchOptions{Regex: true, Substring: true}, []string{"go test ./..."}},
+	}
+	for _, test := range tests {
+		lines, err := h.SearchWith(test.query, test.opts)
+		if err != nil {
+			t.Errorf("search %q %+v: %v", test.query, test.opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(lines, test.expected) {
+			t.Errorf("search %q %+v: lines %q, expected %q", test.query, test.opts, lines, test.expected)
+		}
+	}
+
+	if _, err := h.SearchWith("(git", SearchOptions{Regex: true}); err == nil {
+		t.Error("no error for the invalid pattern")
+	}
+}
+
+func TestHistorySuggest(t *testing.T) {
+	h := NewHistory(3)
+	suggest := func(prefix, expected string) {
+		t.Helper()
+		line, ok := h.Suggest(prefix)
+		if line != expected || ok != (expected != "") {
+			t.Errorf("suggestion for %q: %q %v, expected %q", prefix, line, ok, expected)
+		}
+	}
+	for _, s := range []string{"git status", "go test", "git log"} {
+		h.Add(s)
+	}
+	suggest("", "")
+	suggest("g", "git log")
+	suggest("gi", "git log")
+	suggest("git s", "git status")
+	suggest("git status", "")
+	suggest("go", "go test")
+	suggest("x", "")
+	suggest("xy", "")
+
+	// the added line is indexed, and the oldest line is discarded by the limit
+	h.Add("gist")
+	suggest("gi", "gist")
+	suggest("git s", "")
+	suggest("git", "git log")
+
+	h.Duplicates = HistoryDupErase
+	h.Add("go test")
+	h.Add("git log")
+	suggest("g", "git log")
+	if entries := h.Entries(); !reflect.DeepEqual(entries, []string{"gist", "go test", "git log"}) {
+		t.Fatalf("entries %q", entries)
+	}
+
+	h.Clear()
+	suggest("g", "")
+	h.Add("世界")
+	suggest("世", "世界")
+}
+
+// recordingBackend is a HistoryBackend which records the added lines.
+type recordingBackend struct {
+	mu      sync.Mutex
+	lines   []string
+	adds    []string
+	failErr error
+}
+
+func (b *recordingBackend) Add(entry string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.adds = append(b.adds, entry)
+	b.lines = append(b.lines, entry)
+	return nil
+}
+
+func (b *recordingBackend) Entries() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failErr != nil {
+		return nil, b.failErr
+	}
+	return append([]string(nil), b.lines...), nil
+}
+
+func (b *recordingBackend) Clear() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lines = nil
+	return nil
+}
+
+func (b *recordingBackend) Len() (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.lines), nil
+}
+
+func TestTerminalHistoryBackend(t *testing.T) {
+	backend := &recordingBackend{lines: []string{"old"}}
+	errOut := &syncBuffer{}
+	term, stdin := newTestTerminal(t, Config{
+		History:            backend,
+		HistoryIgnoreSpace: true,
+		ErrorWriter:        errOut,
+	})
+
+	writeAndReadLine(t, term, stdin, "a\r")
+	writeAndReadLine(t, term, stdin, " secret\r")
+	backend.Add("other")
+	if line := writeAndReadLine(t, term, stdin, "\x10\r"); line != "other" {
+		t.Fatalf("line %q, expected \"other\"", line)
+	}
+	if line := writeAndReadLine(t, term, stdin, "\x10\x10\x10\r"); line != "a" {
+		t.Fatalf("line %q, expected \"a\"", line)
+	}
+	backend.mu.Lock()
+	adds := backend.adds
+	backend.mu.Unlock()
+	if expected := []string{"a", "other", "other", "a"}; !reflect.DeepEqual(adds, expected) {
+		t.Fatalf("added lines %q, expected %q", adds, expected)
+	}
+
+	backend.mu.Lock()
+	backend.failErr = errors.New("unavailable")
+	backend.mu.Unlock()
+	if line := writeAndReadLine(t, term, stdin, "\x10\r"); line != "a" {
+		t.Fatalf("line %q, expected \"a\"", line)
+	}
+	if s := errOut.String(); s != "readline: unavailable\n" {
+		t.Fatalf("error output %q, expected \"readline: unavailable\\n\"", s)
+	}
+}
+
+func TestTerminalHistoryPopLastReplace(t *testing.T) {
+	for _, config := range []Config{
+		{},
+		{HistoryFile: filepath.Join(t.TempDir(), "history")},
+		{HistoryFile: filepath.Join(t.TempDir(), "history"), HistoryFileMode: HistoryFileAppend},
+		{History: &recordingBackend{}},
+		{HistoryDuplicates: HistoryDupErase},
+		{HistoryFile: filepath.Join(t.TempDir(), "history"), HistoryDuplicates: HistoryDupErase},
+	} {
+		term, stdin := newTestTerminal(t, config)
+		for _, input := range []string{"a\r", "b\r", "c\r"} {
+			writeAndReadLine(t, term, stdin, input)
+		}
+		if line, ok := term.History().PopLast(); !ok || line != "c" {
+			t.Fatalf("PopLast %q %v, expected \"c\" true", line, ok)
+		}
+		if err := term.History().Replace(0, "A"); err != nil {
+			t.Fatal(err)
+		}
+		if line := writeAndReadLine(t, term, stdin, "\x10\r"); line != "b" {
+			t.Fatalf("config %+v: line %q, expected \"b\"", config, line)
+		}
+		if line := writeAndReadLine(t, term, stdin, "\x10\x10\x10\r"); line != "A" {
+			t.Fatalf("config %+v: line %q, expected \"A\"", config, line)
+		}
+	}
+}
+
+func TestTerminalHistoryPrefixSearch(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		HistoryPrefixSearch:    true,
+		DisableAutoSaveHistory: true,
+	})
+	for _, line := range []string{"git status", "ls", "git commit", "make"} {
+		term.History().Add(line)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"git\x1b[A\r", "git commit"},
+		{"git\x1b[A\x1b[A\r", "git status"},
+		{"git\x1b[A\x1b[A\x1b[A\r", "git status"},
+		{"git\x1b[A\x1b[A\x1b[B\r", "git commit"},
+		{"git\x1b[A\x1b[B\r", "git"},
+		{"git\x1b[Ax\x1b[A\r", "ls"},
+		{"\x1b[A\r", "make"},
+		{"gitx\x1b[D\x1b[A\r", "make"},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package readline
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryBackend stores the history lines of Terminal. The lines are added by Add after applying Duplicates and
+// IgnoreSpace of History, and Terminal loads them by Entries when a history navigation or search starts.
+// It must be safe for concurrent use.
+type HistoryBackend interface {
+	// Add appends entry to the history.
+	Add(entry string) error
+	// Entries returns the history lines from the oldest to the most recent.
+	Entries() ([]string, error)
+	// Clear removes all lines.
+	Clear() error
+	// Len returns the number of lines.
+	Len() (int, error)
+}
+
+// HistoryEditor is an optional interface of HistoryBackend, which is used by History.PopLast and History.Replace
+// to change the stored lines. The backend is rewritten if it doesn't implement HistoryEditor.
+type HistoryEditor interface {
+	// PopLast removes the most recent line.
+	PopLast() error
+	// Replace replaces the line at idx with entry. idx 0 is the oldest line.
+	Replace(idx int, entry string) error
+}
+
+// HistoryRewriter is an optional interface of HistoryBackend, which is used to rewrite the stored lines at once when
+// History.PopLast and History.Replace can't use HistoryEditor. The backend is rewritten by Clear and Add if it doesn't
+// implement HistoryRewriter.
+type HistoryRewriter interface {
+	// Rewrite replaces all lines with entries from the oldest to the most recent.
+	Rewrite(entries []string) error
+}
+
+// memoryHistory is a HistoryBackend which keeps at most limit lines in memory.
+type memoryHistory struct {
+	mu    sync.Mutex
+	lines []string
+	limit int
+}
+
+func newMemoryHistory(limit int) *memoryHistory {
+	return &memoryHistory{
+		limit: limit,
+	}
+}
+
+func (m *memoryHistory) Add(entry string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lines = appendHistoryLine(m.lines, entry, m.limit)
+	return nil
+}
+
+func (m *memoryHistory) Entries() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]string, len(m.lines))
+	copy(result, m.lines)
+	return result, nil
+}
+
+func (m *memoryHistory) Clear() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lines = nil
+	return nil
+}
+
+func (m *memoryHistory) Len() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.lines), nil
+}
+
+func (m *memoryHistory) Rewrite(entries []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lines = nil
+	for _, entry := range entries {
+		m.lines = appendHistoryLine(m.lines, entry, m.limit)
+	}
+	return nil
+}
+
+func (m *memoryHistory) PopLast() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.lines) <= 0 {
+		return ErrHistoryIndex
+	}
+	m.lines = m.lines[:len(m.lines)-1]
+	return nil
+}
+
+func (m *memoryHistory) Replace(idx int, entry string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if idx < 0 || idx >= len(m.lines) {
+		return ErrHistoryIndex
+	}
+	m.lines[idx] = entry
+	return nil
+}
+
+// fileHistory is a HistoryBackend which keeps at most limit lines in the history file at path. The file is
+// rewritten like History.SaveHistory by each Add, and the comment lines are skipped like History.LoadHistory.
+type fileHistory struct {
+	mu            sync.Mutex
+	path          string
+	commentPrefix string
+	limit         int
+}
+
+func newFileHistory(path string, commentPrefix string, limit int) *fileHistory {
+	return &fileHistory{
+		path:          path,
+		commentPrefix: commentPrefix,
+		limit:         limit,
+	}
+}
+
+func (f *fileHistory) Add(entry string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines, err := f.entries()
+	if err != nil {
+		return err
+	}
+	return writeHistoryFile(f.path, appendHistoryLine(lines, entry, f.limit))
+}
+
+func (f *fileHistory) Entries() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.entries()
+}
+
+func (f *fileHistory) entries() ([]string, error) {
+	lines, err := readHistoryFile(f.path, f.commentPrefix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return lines, err
+}
+
+func (f *fileHistory) Clear() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return writeHistoryFile(f.path, nil)
+}
+
+func (f *fileHistory) Len() (int, error) {
+	lines, err := f.Entries()
+	return len(lines), err
+}
+
+func (f *fileHistory) Rewrite(entries []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var lines []string
+	for _, entry := range entries {
+		lines = appendHistoryLine(lines, entry, f.limit)
+	}
+	return writeHistoryFile(f.path, lines)
+}
+
+func (f *fileHistory) PopLast() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines, err := f.entries()
+	if err != nil {
+		return err
+	}
+	if len(lines) <= 0 {
+		return ErrHistoryIndex
+	}
+	return writeHistoryFile(f.path, lines[:len(lines)-1])
+}
+
+func (f *fileHistory) Replace(idx int, entry string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines, err := f.entries()
+	if err != nil {
+		return err
+	}
+	if idx < 0 || idx >= len(lines) {
+		return ErrHistoryIndex
+	}
+	lines[idx] = entry
+	return writeHistoryFile(f.path, lines)
+}
+
+// appendFileHistory is a HistoryBackend which appends the lines to the history file at path with their timestamps
+// by HistoryFileAppend. Entries reads only the lines which are appended after its last call, and keeps at most limit
+// lines. The timestamp lines are skipped, and the comment lines are skipped like History.LoadHistory.
+type appendFileHistory struct {
+	mu            sync.Mutex
+	path          string
+	commentPrefix string
+	limit         int
+	// lines are the lines which are read from the file up to offset
+	lines  []string
+	offset int64
+	// escaped is true if the file starts with the header line of the escaped lines
+	escaped bool
+}
+
+func newAppendFileHistory(path string, commentPrefix string, limit int) *appendFileHistory {
+	return &appendFileHistory{
+		path:          path,
+		commentPrefix: commentPrefix,
+		limit:         limit,
+	}
+}
+
+func (f *appendFileHistory) Add(entry string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.limit < 0 {
+		return nil
+	}
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	var header string
+	escaped, err := historyFileEscaped(file)
+	if err == io.EOF {
+		// the new file starts with the header line
+		header, escaped, err = historyFileHeader+"\n", true, nil
+	}
+	if err == nil {
+		// the timestamp and the line are written at once, so the lines of the other terminals don't come between them
+		line := formatHistoryLine(entry, escaped)
+		_, err = file.WriteString(header + "#" + strconv.FormatInt(time.Now().Unix(), 10) + "\n" + line + "\n")
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *appendFileHistory) Entries() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.refresh(); err != nil {
+		return nil, err
+	}
+	result := make([]string, len(f.lines))
+	copy(result, f.lines)
+	return result, nil
+}
+
+// refresh reads the complete lines which are appended to the file after offset. The lines are read again if the file
+// is shorter than offset, like after it's cleared.
+func (f *appendFileHistory) refresh() error {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		f.lines, f.offset = nil, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < f.offset {
+		f.lines, f.offset = nil, 0
+	}
+	if f.offset == 0 {
+		f.escaped = false
+	}
+	if _, err := file.Seek(f.offset, io.SeekStart); err != nil {
+		return err
+	}
+	p, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	// the last line may be being appended by another terminal
+	p = p[:bytes.LastIndexByte(p, '\n')+1]
+	lines, err := readHistoryLines(bytes.NewReader(p), f.commentPrefix, &f.escaped)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if !isHistoryTimestamp(line) {
+			f.lines = appendHistoryLine(f.lines, line, f.limit)
+		}
+	}
+	f.offset += int64(len(p))
+	return nil
+}
+
+func (f *appendFileHistory) Clear() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lines, f.offset = nil, 0
+	return writeHistoryFile(f.path, nil)
+}
+
+func (f *appendFileHistory) Len() (int, error) {
+	lines, err := f.Entries()
+	return len(lines), err
+}
+
+// PopLast removes the most recent line and its timestamp line from the file.
+func (f *appendFileHistory) PopLast() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.edit(-1, nil)
+}
+
+// Replace replaces the line at idx in the file, and keeps its timestamp line.
+func (f *appendFileHistory) Replace(idx int, entry string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if idx < 0 {
+		return ErrHistoryIndex
+	}
+	return f.edit(idx, &entry)
+}
+
+// Rewrite returns ErrHistoryAppendOnly, since the lines of the file can't be matched with entries without losing
+// their timestamps and the lines of the other terminals.
+func (f *appendFileHistory) Rewrite(entries []string) error {
+	return ErrHistoryAppendOnly
+}
+
+// edit rewrites the file with the line at idx of Entries removed if entry is nil, or replaced with entry otherwise.
+// idx -1 is the most recent line. The other lines, the timestamp lines and the lines beyond the limit are kept as
+// they are. The file is read and edited again if another terminal appends to it before it's replaced.
+func (f *appendFileHistory) edit(idx int, entry *string) error {
+	for {
+		p, err := os.ReadFile(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// the last line may be being appended by another terminal
+		complete := p[:bytes.LastIndexByte(p, '\n')+1]
+		rawLines := strings.SplitAfter(string(complete), "\n")
+		var entries []int
+		for i, raw := range rawLines {
+			line := trimHistoryLine(raw)
+			if line != "" && line != historyFileHeader && (f.commentPrefix == "" || !strings.HasPrefix(line, f.commentPrefix)) && !isHistoryTimestamp(line) {
+				entries = append(entries, i)
+			}
+		}
+		n := len(entries)
+		if f.limit < 0 {
+			n = 0
+		} else if n > f.limit {
+			n = f.limit
+		}
+		if idx < 0 {
+			idx = n - 1
+		}
+		if idx < 0 || idx >= n {
+			return ErrHistoryIndex
+		}
+		i := entries[len(entries)-n+idx]
+		if entry == nil {
+			rawLines[i] = ""
+			if i > 0 && isHistoryTimestamp(trimHistoryLine(rawLines[i-1])) {
+				rawLines[i-1] = ""
+			}
+		} else {
+			escaped := bytes.HasPrefix(p, []byte(historyFileHeader+"\n"))
+			rawLines[i] = formatHistoryLine(*entry, escaped) + "\n"
+		}
+
+		err = replaceHistoryFile(f.path, func(bw *bufio.Writer) {
+			for _, raw := range rawLines {
+				_, _ = bw.WriteString(raw)
+			}
+			_, _ = bw.Write(p[len(complete):])
+		}, func() error {
+			fi, err := os.Stat(f.path)
+			if err != nil {
+				return err
+			}
+			if fi.Size() != int64(len(p)) {
+				return errHistoryFileChanged
+			}
+			return nil
+		})
+		if err != errHistoryFileChanged {
+			// the lines are read again by the next refresh
+			f.lines, f.offset = nil, 0
+			return err
+		}
+	}
+}
+
+// errHistoryFileChanged is returned by the check of appendFileHistory.edit if the file is changed while it's being
+// edited.
+var errHistoryFileChanged = errors.New("history file changed")
+
+// historyFileEscaped returns true if file starts with the header line of the escaped lines. It returns io.EOF if
+// file is empty.
+func historyFileEscaped(file *os.File) (bool, error) {
+	p := make([]byte, len(historyFileHeader)+1)
+	n, err := file.ReadAt(p, 0)
+	if n == 0 && err == io.EOF {
+		return false, io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return string(p[:n]) == historyFileHeader+"\n", nil
+}
+
+// isHistoryTimestamp returns true if line is a timestamp line of HistoryFileAppend, like "#1600000000".
+func isHistoryTimestamp(line string) bool {
+	if len(line) < 2 || line[0] != '#' {
+		return false
+	}
+	for _, c := range line[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// appendHistoryLine appends line to lines, and discards the oldest lines to keep at most limit lines.
+func appendHistoryLine(lines []string, line string, limit int) []string {
+	if limit < 0 {
+		return lines
+	}
+	lines = append(lines, line)
+	if len(lines) > limit {
+		lines = append(lines[:0], lines[len(lines)-limit:]...)
+	}
+	return lines
+}
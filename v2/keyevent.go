@@ -0,0 +1,124 @@
+package readline
+
+import (
+	"unicode/utf8"
+)
+
+// Modifier is a bit mask of the modifier keys of a key event.
+type Modifier uint8
+
+// The modifier keys. Their values are the same with the xterm modifier parameter minus one.
+const (
+	ModShift Modifier = 1 << iota
+	ModAlt
+	ModCtrl
+)
+
+// ParseBytes returns the key event of the raw input p, which is a rune, a control character or an escape sequence.
+// The sequences of the function keys and the modified arrow keys of the other terminal emulators are translated
+// into the xterm sequences. It returns false if p isn't exactly one complete key.
+func ParseBytes(p []byte) (KeyEvent, bool) {
+	if len(p) <= 0 {
+		return "", false
+	}
+	if p[0] != CharEscape || len(p) == 1 {
+		r, size := utf8.DecodeRune(p)
+		if r == utf8.RuneError && size <= 1 || size != len(p) {
+			return "", false
+		}
+		return KeyEvent(p), true
+	}
+	escKeyPair := decodeEscapeKeyPair(p[1:])
+	if escKeyPair == nil || len(escKeyPair.Remainder) > 0 {
+		return "", false
+	}
+	if (escKeyPair.Char == 'O' || escKeyPair.Char == '[') && escKeyPair.Type == '\x00' {
+		return "", false
+	}
+	if escKeyPair.Key != "" {
+		return escKeyPair.Key, true
+	}
+	return KeyEvent(p), true
+}
+
+// Modifiers returns the modifier keys of k. A control character has ModCtrl, and a key which is prefixed with
+// escape has ModAlt.
+func (k KeyEvent) Modifiers() Modifier {
+	if len(k) > 1 && k[0] == CharEscape {
+		if escKeyPair := decodeEscapeKeyPair([]byte(k[1:])); escKeyPair != nil && escKeyPair.isModifiedKey() {
+			return Modifier(escKeyPair.Attribute2 - 1)
+		}
+	}
+	if key, ok := ParseBytes([]byte(k)); ok {
+		k = key
+	}
+	if len(k) <= 1 || k[0] != CharEscape {
+		r, _ := utf8.DecodeRuneInString(string(k))
+		if r < 0x20 && r != CharEscape {
+			return ModCtrl
+		}
+		return 0
+	}
+	escKeyPair := decodeEscapeKeyPair([]byte(k[1:]))
+	if escKeyPair != nil && (escKeyPair.Char == 'O' || escKeyPair.Char == '[') && escKeyPair.Type != '\x00' {
+		if escKeyPair.Attribute2 > 1 {
+			return Modifier(escKeyPair.Attribute2 - 1)
+		}
+		return 0
+	}
+	return ModAlt | k[1:].Modifiers()
+}
+
+// Rune returns the character of k without the modifier keys, like 'a' for Ctrl+A and Meta+A. So Tab is 'i' and
+// Enter is 'm'. It returns 0 for the function keys and the cursor keys.
+func (k KeyEvent) Rune() rune {
+	if len(k) > 1 && k[0] == CharEscape {
+		escKeyPair := decodeEscapeKeyPair([]byte(k[1:]))
+		if escKeyPair != nil && escKeyPair.isModifiedKey() {
+			return rune(escKeyPair.Attribute3)
+		}
+		if escKeyPair == nil || (escKeyPair.Char == 'O' || escKeyPair.Char == '[') && escKeyPair.Type != '\x00' {
+			return 0
+		}
+		return k[1:].Rune()
+	}
+	r, _ := utf8.DecodeRuneInString(string(k))
+	switch {
+	case r == CharCtrlSpace:
+		return ' '
+	case r < CharEscape:
+		return r + 'a' - 1
+	case r > CharEscape && r < 0x20:
+		return r + 0x40
+	}
+	return r
+}
+
+// modifiedKeyEvent returns the traditional key event of the rune r with the modifier keys mods, which is reported
+// in the modifyOtherKeys mode. It returns "" if there isn't a traditional encoding, like for Ctrl+Shift+A.
+func modifiedKeyEvent(mods Modifier, r rune) KeyEvent {
+	var prefix KeyEvent
+	if mods&ModAlt != 0 {
+		prefix = "\x1b"
+		mods &^= ModAlt
+	}
+	switch {
+	case mods == 0, mods == ModShift && r >= 0x20:
+		return prefix + KeyEvent(string(r))
+
+	case mods == ModCtrl:
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		switch {
+		case r >= '@' && r <= '_':
+			return prefix + KeyEvent(string(r-'@'))
+		case r == ' ':
+			return prefix + "\x00"
+		case r == '?':
+			return prefix + "\x7f"
+		}
+
+	}
+	return ""
+}
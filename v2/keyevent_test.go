@@ -0,0 +1,95 @@
+package readline
+
+import (
+	"io"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		p        string
+		expected KeyEvent
+		ok       bool
+	}{
+		{"a", "a", true},
+		{"ş", "ş", true},
+		{"\x01", "\x01", true},
+		{"\x1b", "\x1b", true},
+		{"\x1bf", "\x1bf", true},
+		{"\x1b[A", "\x1b[A", true},
+		{"\x1b[11~", KeyF1, true},
+		{"\x1bOc", KeyCtrlArrowRight, true},
+		{"\x1b[27;5;65~", "\x01", true},
+		{"\x1b[27;5;32~", "\x00", true},
+		{"\x1b[27;2;65~", "A", true},
+		{"\x1b[27;3;102~", "\x1bf", true},
+		{"\x1b[27;7;104~", "\x1b\x08", true},
+		{"\x1b[27;6;65~", "\x1b[27;6;65~", true},
+		{"\x1b[27;2;13~", "\x1b[27;2;13~", true},
+		{"\x1b[27;5;", "", false},
+		{"", "", false},
+		{"ab", "", false},
+		{"\xff", "", false},
+		{"\x1b[", "", false},
+		{"\x1b[1;5", "", false},
+		{"\x1b[Ax", "", false},
+	}
+	for _, test := range tests {
+		key, ok := ParseBytes([]byte(test.p))
+		if key != test.expected || ok != test.ok {
+			t.Errorf("bytes %q: key %q %v, expected %q %v", test.p, key, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestKeyEventModifiers(t *testing.T) {
+	tests := []struct {
+		key  KeyEvent
+		mods Modifier
+		r    rune
+	}{
+		{"a", 0, 'a'},
+		{"\x01", ModCtrl, 'a'},
+		{"\t", ModCtrl, 'i'},
+		{"\x00", ModCtrl, ' '},
+		{"\x1f", ModCtrl, '_'},
+		{"\x1b", 0, CharEscape},
+		{"\x1bf", ModAlt, 'f'},
+		{"\x1b\x08", ModAlt | ModCtrl, 'h'},
+		{"\x1b[A", 0, 0},
+		{KeyF5, 0, 0},
+		{KeyShiftArrowUp, ModShift, 0},
+		{KeyAltArrowLeft, ModAlt, 0},
+		{KeyCtrlArrowRight, ModCtrl, 0},
+		{"\x1b[1;8C", ModShift | ModAlt | ModCtrl, 0},
+		{"\x1bOc", ModCtrl, 0},
+		{"\x1b[27;5;65~", ModCtrl, 'A'},
+		{"\x1b[27;6;65~", ModShift | ModCtrl, 'A'},
+		{"\x1b[27;2;13~", ModShift, '\r'},
+	}
+	for _, test := range tests {
+		if mods, r := test.key.Modifiers(), test.key.Rune(); mods != test.mods || r != test.r {
+			t.Errorf("key %q: modifiers %b rune %q, expected %b %q", test.key, mods, r, test.mods, test.r)
+		}
+	}
+}
+
+func TestTerminalModifyOtherKeys(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		DisableAutoSaveHistory: true,
+		EnableModifyOtherKeys:  true,
+	})
+
+	term.Bind("\x1b[27;6;65~", func(t *Terminal) {
+		t.rb.WriteString("<C-S-A>")
+	})
+	if line := writeAndReadLine(t, term, stdin, "ab\x1b[27;5;65~X\x1b[27;6;65~\x1b[27;2;66~\r"); line != "X<C-S-A>Bab" {
+		t.Fatalf("line %q, expected \"X<C-S-A>Bab\"", line)
+	}
+	if _, err := io.WriteString(stdin, "a\x1b[27;5;99~"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := term.ReadLine(); err != ErrInterrupted {
+		t.Fatalf("error %v, expected ErrInterrupted", err)
+	}
+}
@@ -0,0 +1,254 @@
+package readline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// KeyEvent is the byte sequence of a key, like a control character or an escape sequence.
+type KeyEvent string
+
+// The function keys and the modified arrow keys. Their values are the xterm sequences, and the sequences of the other
+// terminal emulators like rxvt are translated into them before looking up the key bindings.
+const (
+	KeyF1  KeyEvent = "\x1bOP"
+	KeyF2  KeyEvent = "\x1bOQ"
+	KeyF3  KeyEvent = "\x1bOR"
+	KeyF4  KeyEvent = "\x1bOS"
+	KeyF5  KeyEvent = "\x1b[15~"
+	KeyF6  KeyEvent = "\x1b[17~"
+	KeyF7  KeyEvent = "\x1b[18~"
+	KeyF8  KeyEvent = "\x1b[19~"
+	KeyF9  KeyEvent = "\x1b[20~"
+	KeyF10 KeyEvent = "\x1b[21~"
+	KeyF11 KeyEvent = "\x1b[23~"
+	KeyF12 KeyEvent = "\x1b[24~"
+
+	KeyShiftArrowUp    KeyEvent = "\x1b[1;2A"
+	KeyShiftArrowDown  KeyEvent = "\x1b[1;2B"
+	KeyShiftArrowRight KeyEvent = "\x1b[1;2C"
+	KeyShiftArrowLeft  KeyEvent = "\x1b[1;2D"
+	KeyAltArrowUp      KeyEvent = "\x1b[1;3A"
+	KeyAltArrowDown    KeyEvent = "\x1b[1;3B"
+	KeyAltArrowRight   KeyEvent = "\x1b[1;3C"
+	KeyAltArrowLeft    KeyEvent = "\x1b[1;3D"
+	KeyCtrlArrowUp     KeyEvent = "\x1b[1;5A"
+	KeyCtrlArrowDown   KeyEvent = "\x1b[1;5B"
+	KeyCtrlArrowRight  KeyEvent = "\x1b[1;5C"
+	KeyCtrlArrowLeft   KeyEvent = "\x1b[1;5D"
+)
+
+// escapeKeyEvents maps the escape sequences of the function keys and the modified arrow keys to their key events.
+var escapeKeyEvents = map[string]KeyEvent{
+	// xterm, VT100
+	string(KeyF1): KeyF1, string(KeyF2): KeyF2, string(KeyF3): KeyF3, string(KeyF4): KeyF4,
+	string(KeyF5): KeyF5, string(KeyF6): KeyF6, string(KeyF7): KeyF7, string(KeyF8): KeyF8,
+	string(KeyF9): KeyF9, string(KeyF10): KeyF10, string(KeyF11): KeyF11, string(KeyF12): KeyF12,
+	string(KeyShiftArrowUp): KeyShiftArrowUp, string(KeyShiftArrowDown): KeyShiftArrowDown,
+	string(KeyShiftArrowRight): KeyShiftArrowRight, string(KeyShiftArrowLeft): KeyShiftArrowLeft,
+	string(KeyAltArrowUp): KeyAltArrowUp, string(KeyAltArrowDown): KeyAltArrowDown,
+	string(KeyAltArrowRight): KeyAltArrowRight, string(KeyAltArrowLeft): KeyAltArrowLeft,
+	string(KeyCtrlArrowUp): KeyCtrlArrowUp, string(KeyCtrlArrowDown): KeyCtrlArrowDown,
+	string(KeyCtrlArrowRight): KeyCtrlArrowRight, string(KeyCtrlArrowLeft): KeyCtrlArrowLeft,
+
+	// rxvt, old xterm
+	"\x1b[11~": KeyF1, "\x1b[12~": KeyF2, "\x1b[13~": KeyF3, "\x1b[14~": KeyF4,
+	"\x1b[a": KeyShiftArrowUp, "\x1b[b": KeyShiftArrowDown, "\x1b[c": KeyShiftArrowRight, "\x1b[d": KeyShiftArrowLeft,
+	"\x1bOa": KeyCtrlArrowUp, "\x1bOb": KeyCtrlArrowDown, "\x1bOc": KeyCtrlArrowRight, "\x1bOd": KeyCtrlArrowLeft,
+	"\x1b[5A": KeyCtrlArrowUp, "\x1b[5B": KeyCtrlArrowDown, "\x1b[5C": KeyCtrlArrowRight, "\x1b[5D": KeyCtrlArrowLeft,
+}
+
+// KeyMap maps key events to their handlers.
+type KeyMap map[KeyEvent]func(*Terminal)
+
+// defaultKeyMap is the built-in key bindings. The keys which change the input loop state, like Ctrl+C and Ctrl+D,
+// and the vi normal mode keys aren't in it.
+var defaultKeyMap = KeyMap{
+	"\x01": (*Terminal).opLineStart,
+	"\x02": (*Terminal).opBackward,
+	"\x05": (*Terminal).opLineEnd,
+	"\x06": (*Terminal).opForward,
+	"\x07": (*Terminal).bell,
+	"\x08": (*Terminal).opBackspace,
+	"\x7f": (*Terminal).opBackspace,
+	"\x09": (*Terminal).opTab,
+	"\x0a": (*Terminal).opReturn,
+	"\x0d": (*Terminal).opReturn,
+	"\x0b": (*Terminal).opKill,
+	"\x0c": (*Terminal).opClear,
+	"\x0e": (*Terminal).opNext,
+	"\x10": (*Terminal).opPrev,
+	"\x12": (*Terminal).opBckSearch,
+	"\x13": (*Terminal).opFwdSearch,
+	"\x14": (*Terminal).opTranspose,
+	"\x15": (*Terminal).opKillFront,
+	"\x00": (*Terminal).opSetMark,
+	"\x17": (*Terminal).opKillRegion,
+	"\x19": (*Terminal).opYank,
+	"\x1f": (*Terminal).opUndo,
+
+	"\x1b\x08": (*Terminal).opKillWordFront,
+	"\x1b\x7f": (*Terminal).opKillWordFront,
+	"\x1b\x14": (*Terminal).opTranspose,
+	"\x1b ":    (*Terminal).opSetMark,
+	"\x1b!":    (*Terminal).opHistoryGrep,
+	"\x1bw":    (*Terminal).opCopyRegion,
+	"\x1bb":    (*Terminal).opBackwardWord,
+	"\x1bc":    (*Terminal).opCapitalizeWord,
+	"\x1bC":    (*Terminal).opCapitalizeWord,
+	"\x1bd":    (*Terminal).opKillWord,
+	"\x1bf":    (*Terminal).opForwardWord,
+	"\x1bl":    (*Terminal).opLowerCaseWord,
+	"\x1bL":    (*Terminal).opLowerCaseWord,
+	"\x1br":    (*Terminal).opRedo,
+	"\x1bu":    (*Terminal).opUpperCaseWord,
+	"\x1bv":    (*Terminal).opPasteClipboard,
+	"\x1bt":    (*Terminal).opTransposeWords,
+	"\x1bU":    (*Terminal).opUpperCaseWord,
+	"\x1by":    (*Terminal).opYankPop,
+
+	"\x1b[A": (*Terminal).opPrev,
+	"\x1b[B": (*Terminal).opNext,
+	"\x1b[C": (*Terminal).opForward,
+	"\x1b[D": (*Terminal).opBackward,
+	"\x1b[F": (*Terminal).opLineEnd,
+	"\x1b[H": (*Terminal).opLineStart,
+	"\x1bOA": (*Terminal).opPrev,
+	"\x1bOB": (*Terminal).opNext,
+	"\x1bOC": (*Terminal).opForward,
+	"\x1bOD": (*Terminal).opBackward,
+	"\x1bOF": (*Terminal).opLineEnd,
+	"\x1bOH": (*Terminal).opLineStart,
+
+	KeyCtrlArrowRight: (*Terminal).opForwardWord,
+	KeyCtrlArrowLeft:  (*Terminal).opBackwardWord,
+	KeyAltArrowRight:  (*Terminal).opForwardWord,
+	KeyAltArrowLeft:   (*Terminal).opBackwardWord,
+
+	"\x1b[1~": (*Terminal).opLineStart,
+	"\x1b[2~": (*Terminal).opInsertKey,
+	"\x1b[3~": (*Terminal).opDelete,
+	"\x1b[4~": (*Terminal).opLineEnd,
+	"\x1b[7~": (*Terminal).opLineStart,
+	"\x1b[8~": (*Terminal).opLineEnd,
+}
+
+// DefaultKeyMap returns a copy of the built-in key bindings.
+func (t *Terminal) DefaultKeyMap() KeyMap {
+	result := make(KeyMap, len(defaultKeyMap))
+	for key, fn := range defaultKeyMap {
+		result[key] = fn
+	}
+	return result
+}
+
+// Bind binds key to fn. fn is called in the input loop instead of the built-in binding of key.
+func (t *Terminal) Bind(key KeyEvent, fn func(*Terminal)) {
+	t.keyMapMu.Lock()
+	defer t.keyMapMu.Unlock()
+	if t.keyMap == nil {
+		t.keyMap = make(KeyMap)
+	}
+	t.keyMap[key] = fn
+}
+
+// Unbind removes the binding of key which is bound by Bind, and restores the built-in binding.
+func (t *Terminal) Unbind(key KeyEvent) {
+	t.keyMapMu.Lock()
+	defer t.keyMapMu.Unlock()
+	delete(t.keyMap, key)
+}
+
+// keyHandler returns the handler of key from the user bindings or the built-in bindings.
+// It returns nil if key isn't bound.
+func (t *Terminal) keyHandler(key KeyEvent) func(*Terminal) {
+	t.keyMapMu.RLock()
+	fn := t.keyMap[key]
+	t.keyMapMu.RUnlock()
+	if fn != nil {
+		return fn
+	}
+	if t.config.MultiLine {
+		switch key {
+		case t.config.SubmitKey:
+			return (*Terminal).opReturn
+		case "\r", "\n":
+			return (*Terminal).opNewline
+		}
+	}
+	return defaultKeyMap[key]
+}
+
+// ParseKeyEvent parses the key notation s. "C-x" is the control character of x, "M-x" is x prefixed with escape,
+// and the other strings are taken literally after unescaping the backslash sequences like "\e", "\t" and "\x7f".
+func ParseKeyEvent(s string) (KeyEvent, error) {
+	switch {
+	case strings.HasPrefix(s, "M-") && len(s) > 2:
+		key, err := ParseKeyEvent(s[2:])
+		if err != nil {
+			return "", err
+		}
+		return "\x1b" + key, nil
+
+	case strings.HasPrefix(s, "C-") && len(s) > 2:
+		r, size := utf8.DecodeRuneInString(s[2:])
+		if size != len(s)-2 {
+			return "", fmt.Errorf("invalid key event %q: control key must be a single character", s)
+		}
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		r ^= 0x40
+		if r >= 0x20 && r != CharBackspaceEx {
+			return "", fmt.Errorf("invalid key event %q: unknown control key", s)
+		}
+		return KeyEvent([]rune{r}), nil
+
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", fmt.Errorf("invalid key event %q: trailing backslash", s)
+		}
+		switch s[i] {
+		case 'e', 'E':
+			sb.WriteByte(CharEscape)
+		case 'a':
+			sb.WriteByte(CharBell)
+		case 'b':
+			sb.WriteByte(CharBackspace)
+		case 't':
+			sb.WriteByte(CharTab)
+		case 'n':
+			sb.WriteByte(CharFeed)
+		case 'r':
+			sb.WriteByte(CharReturn)
+		case '\\':
+			sb.WriteByte('\\')
+		case 'x':
+			if i+2 >= len(s) {
+				return "", fmt.Errorf("invalid key event %q: short hex escape", s)
+			}
+			b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid key event %q: invalid hex escape", s)
+			}
+			sb.WriteByte(byte(b))
+			i += 2
+		default:
+			return "", fmt.Errorf("invalid key event %q: unknown escape \\%c", s, s[i])
+		}
+	}
+	if sb.Len() <= 0 {
+		return "", fmt.Errorf("invalid key event %q: empty", s)
+	}
+	return KeyEvent(sb.String()), nil
+}
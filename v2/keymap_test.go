@@ -0,0 +1,139 @@
+package readline
+
+import (
+	"testing"
+)
+
+func TestParseKeyEvent(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected KeyEvent
+	}{
+		{"a", "a"},
+		{"C-a", "\x01"},
+		{"C-A", "\x01"},
+		{"C-_", "\x1f"},
+		{"C-?", "\x7f"},
+		{"M-f", "\x1bf"},
+		{"M-C-h", "\x1b\x08"},
+		{"\\e[3~", "\x1b[3~"},
+		{"\\x1bOA", "\x1bOA"},
+		{"\\t", "\t"},
+		{"\\\\", "\\"},
+	}
+	for _, test := range tests {
+		key, err := ParseKeyEvent(test.s)
+		if err != nil {
+			t.Errorf("%q: %v", test.s, err)
+			continue
+		}
+		if key != test.expected {
+			t.Errorf("%q: key %q, expected %q", test.s, key, test.expected)
+		}
+	}
+
+	for _, s := range []string{"", "C-ab", "C-1", "M-\\q", "\\", "\\x1"} {
+		if key, err := ParseKeyEvent(s); err == nil {
+			t.Errorf("%q: key %q, expected error", s, key)
+		}
+	}
+}
+
+func TestTerminalBind(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	if fn := term.DefaultKeyMap()["\x01"]; fn == nil {
+		t.Fatal("Ctrl+A isn't in the default key map")
+	}
+
+	called := 0
+	insertX := func(t *Terminal) {
+		called++
+		t.rb.WriteRune('X')
+	}
+	term.Bind("\x01", insertX)
+	term.Bind("\x1b[3~", insertX)
+	term.Bind("\x1bq", insertX)
+
+	if line := writeAndReadLine(t, term, stdin, "abc\x01\x1b[3~\x1bq\r"); line != "abcXXX" {
+		t.Fatalf("line %q, expected \"abcXXX\"", line)
+	}
+	if called != 3 {
+		t.Fatalf("called %d times, expected 3", called)
+	}
+
+	term.Unbind("\x01")
+	term.Unbind("\x1b[3~")
+	term.Unbind("\x1bq")
+	if line := writeAndReadLine(t, term, stdin, "abc\x01\x1b[3~\r"); line != "bc" {
+		t.Fatalf("line %q after unbinding, expected \"bc\"", line)
+	}
+	if called != 3 {
+		t.Fatalf("called %d times after unbinding, expected 3", called)
+	}
+}
+
+func TestDecodeEscapeKeyEvent(t *testing.T) {
+	tests := []struct {
+		seq      string
+		expected KeyEvent
+	}{
+		{"\x1bOP", KeyF1},
+		{"\x1bOQ", KeyF2},
+		{"\x1bOR", KeyF3},
+		{"\x1bOS", KeyF4},
+		{"\x1b[11~", KeyF1},
+		{"\x1b[12~", KeyF2},
+		{"\x1b[13~", KeyF3},
+		{"\x1b[14~", KeyF4},
+		{"\x1b[15~", KeyF5},
+		{"\x1b[17~", KeyF6},
+		{"\x1b[18~", KeyF7},
+		{"\x1b[19~", KeyF8},
+		{"\x1b[20~", KeyF9},
+		{"\x1b[21~", KeyF10},
+		{"\x1b[23~", KeyF11},
+		{"\x1b[24~", KeyF12},
+		{"\x1b[1;2A", KeyShiftArrowUp},
+		{"\x1b[1;3B", KeyAltArrowDown},
+		{"\x1b[1;5C", KeyCtrlArrowRight},
+		{"\x1b[1;5D", KeyCtrlArrowLeft},
+		{"\x1b[c", KeyShiftArrowRight},
+		{"\x1bOd", KeyCtrlArrowLeft},
+		{"\x1b[5C", KeyCtrlArrowRight},
+		{"\x1b[A", ""},
+		{"\x1b[3~", ""},
+	}
+	for _, test := range tests {
+		pair := decodeEscapeKeyPair([]byte(test.seq[1:]))
+		if pair == nil || pair.Key != test.expected || len(pair.Remainder) != 0 {
+			t.Errorf("sequence %q: decoded %+v, expected key %q", test.seq, pair, test.expected)
+		}
+	}
+}
+
+func TestTerminalMetaNewline(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	// the newline was decoded as no escape sequence, and it panicked
+	if line := writeAndReadLine(t, term, stdin, "ab\x1b\nc\r"); line != "abc" {
+		t.Fatalf("line %q, expected \"abc\"", line)
+	}
+}
+
+func TestTerminalFunctionKeys(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	term.Bind(KeyF1, func(t *Terminal) {
+		t.rb.WriteString("<F1>")
+	})
+	term.Bind(KeyF12, func(t *Terminal) {
+		t.rb.WriteString("<F12>")
+	})
+	if line := writeAndReadLine(t, term, stdin, "\x1bOP\x1b[11~\x1b[24~\r"); line != "<F1><F1><F12>" {
+		t.Fatalf("line %q, expected \"<F1><F1><F12>\"", line)
+	}
+	if line := writeAndReadLine(t, term, stdin, "one two\x1b[1;5D!\x1bOc?\r"); line != "one !two?" {
+		t.Fatalf("line %q, expected \"one !two?\"", line)
+	}
+}
@@ -0,0 +1,63 @@
+package readline
+
+// ctrlXKey processes the key p after Ctrl+X like GNU readline. Ctrl+X exchanges the cursor and the mark, '(' and ')'
+// start and stop recording the keyboard macro, and 'e' plays it back.
+func (t *Terminal) ctrlXKey(p []byte) {
+	switch string(p) {
+	case "\x18":
+		t.opExchangeMark()
+
+	case "(":
+		if t.macroRecord || t.macroBusy {
+			t.bell()
+			break
+		}
+		t.macroRecord = true
+		t.macroRecording = nil
+
+	case ")":
+		if !t.macroRecord {
+			t.bell()
+			break
+		}
+		t.macroRecord = false
+
+	case "e", "E":
+		t.macroPlay()
+
+	default:
+		t.bell()
+
+	}
+}
+
+// macroInput is called with the input p which isn't a Ctrl+X command. p is recorded while recording, and it isn't
+// recorded if it's from the playback.
+func (t *Terminal) macroInput(p []byte) {
+	if t.macroBusy {
+		t.macroPending -= len(p)
+		if t.macroPending <= 0 {
+			t.macroBusy = false
+		}
+		return
+	}
+	if t.macroRecord {
+		t.macroRecording = append(t.macroRecording, append([]byte(nil), p...))
+	}
+}
+
+// macroPlay plays the recorded keyboard macro back by writing it to the stdin.
+func (t *Terminal) macroPlay() {
+	if t.macroBusy || t.macroRecord || len(t.macroRecording) <= 0 {
+		t.bell()
+		return
+	}
+	n := 0
+	for _, p := range t.macroRecording {
+		if _, err := t.WriteStdin(p); err != nil {
+			break
+		}
+		n += len(p)
+	}
+	t.macroBusy, t.macroPending = n > 0, n
+}
@@ -0,0 +1,23 @@
+package readline
+
+import (
+	"io"
+	"testing"
+)
+
+func TestTerminalMacro(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	if line := writeAndReadLine(t, term, stdin, "\x18(ab\x1b[D!\x18)\r"); line != "a!b" {
+		t.Fatalf("line %q, expected \"a!b\"", line)
+	}
+	if _, err := io.WriteString(stdin, "x\x18e"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return term.rb.String() == "xa!b"
+	})
+	if line := writeAndReadLine(t, term, stdin, "\r"); line != "xa!b" {
+		t.Fatalf("line %q, expected \"xa!b\"", line)
+	}
+}
@@ -0,0 +1,81 @@
+package readline
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// OSC terminators for Config.OSCTerminator.
+const (
+	// OSCTerminatorBEL terminates the OSC sequences with BEL, which is the default.
+	OSCTerminatorBEL = "\a"
+	// OSCTerminatorST terminates the OSC sequences with ST, which tmux and the terminals strictly following ECMA-48
+	// expect.
+	OSCTerminatorST = "\033\\"
+)
+
+// WrapTMux wraps seq in the DCS passthrough sequence of tmux, so tmux passes seq to the outer terminal instead of
+// interpreting it. The escape bytes in seq are doubled. tmux passes the sequence only if its allow-passthrough
+// option is on.
+func WrapTMux(seq []byte) []byte {
+	wrapped := make([]byte, 0, len(seq)+bytes.Count(seq, []byte{'\033'})+len("\033Ptmux;\033\\"))
+	wrapped = append(wrapped, "\033Ptmux;"...)
+	for _, b := range seq {
+		if b == '\033' {
+			wrapped = append(wrapped, '\033')
+		}
+		wrapped = append(wrapped, b)
+	}
+	return append(wrapped, "\033\\"...)
+}
+
+// passthrough returns seq wrapped by WrapTMux if Config.TMuxPassthrough is set.
+func (t *Terminal) passthrough(seq []byte) []byte {
+	if t.config.TMuxPassthrough {
+		return WrapTMux(seq)
+	}
+	return seq
+}
+
+// EmitOSC writes the OSC sequence "\033]<code>;<data>" terminated by Config.OSCTerminator, like OSC 2 which sets
+// the window title, or OSC 8 which starts a hyperlink. Nothing is written if the terminal isn't interactive. data
+// mustn't contain BEL or ESC, since they terminate the sequence. The sequence is wrapped by WrapTMux if
+// Config.TMuxPassthrough is set. It's safe for concurrent use.
+func (t *Terminal) EmitOSC(code int, data string) error {
+	if code < 0 {
+		return ErrInvalidOSC
+	}
+	if strings.ContainsAny(data, "\a\033") {
+		return ErrInvalidOSC
+	}
+	terminator := t.config.OSCTerminator
+	if terminator == "" {
+		terminator = OSCTerminatorBEL
+	}
+	return t.rb.WriteSequence(t.passthrough([]byte("\033]" + strconv.Itoa(code) + ";" + data + terminator)))
+}
+
+// emitOSC7 emits the current working directory which Config.OSC7Provider returns by OSC 7.
+func (t *Terminal) emitOSC7() {
+	if t.config.OSC7Provider == nil {
+		return
+	}
+	ch := make(chan string, 1)
+	if !t.callback(func() {
+		ch <- t.config.OSC7Provider()
+	}) {
+		return
+	}
+	dir := <-ch
+	if dir == "" {
+		return
+	}
+	host, _ := os.Hostname()
+	u := url.URL{Scheme: "file", Host: host, Path: dir}
+	if err := t.EmitOSC(7, u.String()); err != nil {
+		t.logError(err)
+	}
+}
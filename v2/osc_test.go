@@ -0,0 +1,93 @@
+package readline
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTerminalEmitOSC(t *testing.T) {
+	tests := []struct {
+		terminator string
+		code       int
+		data       string
+		expected   string
+	}{
+		{"", 2, "title", "\033]2;title\a"},
+		{OSCTerminatorST, 2, "title", "\033]2;title\033\\"},
+		{OSCTerminatorBEL, 8, ";https://example.com", "\033]8;;https://example.com\a"},
+		{"", 52, "c;aGVsbG8=", "\033]52;c;aGVsbG8=\a"},
+	}
+	for _, test := range tests {
+		out := &syncBuffer{}
+		term, _ := newTestTerminalOutput(t, Config{ForceUseInteractive: true, OSCTerminator: test.terminator}, out)
+		if err := term.EmitOSC(test.code, test.data); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			return strings.Contains(out.String(), test.expected)
+		})
+	}
+
+	term, _ := newTestTerminal(t, Config{ForceUseInteractive: true})
+	for _, data := range []string{"a\ab", "a\033\\b"} {
+		if err := term.EmitOSC(2, data); err != ErrInvalidOSC {
+			t.Errorf("EmitOSC with %q: error %v, expected ErrInvalidOSC", data, err)
+		}
+	}
+	if err := term.EmitOSC(-1, ""); err != ErrInvalidOSC {
+		t.Errorf("EmitOSC with the code -1: error %v, expected ErrInvalidOSC", err)
+	}
+}
+
+func TestTerminalOSC7(t *testing.T) {
+	host, _ := os.Hostname()
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{
+		Prompt:                 "> ",
+		ForceUseInteractive:    true,
+		DisableAutoSaveHistory: true,
+		OSC7Provider: func() string {
+			return "/tmp/a b"
+		},
+	}, out)
+
+	if line := writeAndReadLine(t, term, stdin, "ls\r"); line != "ls" {
+		t.Fatalf("line %q, expected \"ls\"", line)
+	}
+	expected := "\033]7;file://" + host + "/tmp/a%20b\a"
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), expected)
+	})
+	if s := out.String(); strings.Index(s, "ls") > strings.Index(s, expected) {
+		t.Errorf("output %q, expected the OSC 7 sequence after the line", s)
+	}
+}
+
+func TestWrapTMux(t *testing.T) {
+	tests := []struct {
+		seq      string
+		expected string
+	}{
+		{"\033]52;c;aGVsbG8=\a", "\033Ptmux;\033\033]52;c;aGVsbG8=\a\033\\"},
+		{"\033]2;title\033\\", "\033Ptmux;\033\033]2;title\033\033\\\033\\"},
+		{"\033[?2004h", "\033Ptmux;\033\033[?2004h\033\\"},
+		{"", "\033Ptmux;\033\\"},
+	}
+	for _, test := range tests {
+		if wrapped := string(WrapTMux([]byte(test.seq))); wrapped != test.expected {
+			t.Errorf("WrapTMux(%q) = %q, expected %q", test.seq, wrapped, test.expected)
+		}
+	}
+}
+
+func TestTerminalTMuxPassthrough(t *testing.T) {
+	out := &syncBuffer{}
+	term, _ := newTestTerminalOutput(t, Config{ForceUseInteractive: true, TMuxPassthrough: true}, out)
+	if err := term.EmitOSC(2, "title"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "\033Ptmux;\033\033]2;title\a\033\\")
+	})
+}
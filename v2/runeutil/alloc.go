@@ -0,0 +1,80 @@
+package runeutil
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// AllocStats is the number of the rune slices which are allocated by CopyAndGrow, and freed by the buffers to be
+// reused by CopyAndGrow.
+type AllocStats struct {
+	Allocs, Frees uint64
+}
+
+var (
+	runePool   sync.Pool
+	allocCount uint64
+	freeCount  uint64
+)
+
+// maxOutputPoolSize is the max capacity of the output buffers which are kept in outputPool.
+const maxOutputPoolSize = 64 * 1024
+
+// outputPool holds the buffers which the output of the RuneBuffer is written into before writing it at once.
+var outputPool = sync.Pool{New: func() interface{} { return bytes.NewBuffer(make([]byte, 0, 256)) }}
+
+// RuneBufferAllocStats returns the allocation statistics of the rune slices since the program started. It's useful
+// for benchmarking.
+func RuneBufferAllocStats() AllocStats {
+	return AllocStats{
+		Allocs: atomic.LoadUint64(&allocCount),
+		Frees:  atomic.LoadUint64(&freeCount),
+	}
+}
+
+// makeRunes returns a rune slice with length n and at least capacity c. A freed slice is reused if its capacity
+// is enough, so the runes of the returned slice aren't zeroed.
+func makeRunes(n, c int) []rune {
+	if p, ok := runePool.Get().(*[]rune); ok {
+		if cap(*p) >= c {
+			return (*p)[:n]
+		}
+		runePool.Put(p)
+	}
+	atomic.AddUint64(&allocCount, 1)
+	return make([]rune, n, c)
+}
+
+// freeRunes frees s to be reused by makeRunes. s must not be used after that.
+func freeRunes(s []rune) {
+	if cap(s) <= 0 {
+		return
+	}
+	atomic.AddUint64(&freeCount, 1)
+	runePool.Put(&s)
+}
+
+// growCap returns the capacity of a slice for n runes, which is grown from a slice with capacity c. It's 2*c if n is
+// within 25% of c, so the sequential growths allocate O(log n) times.
+func growCap(n, c int) int {
+	if n*4 >= c*3 && 2*c > n {
+		return 2 * c
+	}
+	return n
+}
+
+// getOutputBuffer returns an empty buffer from outputPool.
+func getOutputBuffer() *bytes.Buffer {
+	return outputPool.Get().(*bytes.Buffer)
+}
+
+// putOutputBuffer resets buf and puts it back into outputPool unless it's grown too large. buf must not be used
+// after that.
+func putOutputBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxOutputPoolSize {
+		return
+	}
+	buf.Reset()
+	outputPool.Put(buf)
+}
@@ -5,5 +5,7 @@ import (
 )
 
 var(
-	ErrInvalidScreenWidth = errors.New("invalid screen width")
+	ErrInvalidScreenWidth  = errors.New("invalid screen width")
+	ErrInvalidScreenHeight = errors.New("invalid screen height")
+	ErrInvalidTabWidth     = errors.New("invalid tab width")
 )
@@ -12,11 +12,13 @@ func Copy(s []rune) []rune {
 	return result
 }
 
+// CopyAndGrow returns a copy of s which has the capacity to append grow runes without allocating. The capacity is
+// doubled if the required capacity is close to the capacity of s.
 func CopyAndGrow(s []rune, grow int) []rune {
 	if grow < 0 {
 		grow = 0
 	}
-	result := make([]rune, len(s), len(s)+grow)
+	result := makeRunes(len(s), growCap(len(s)+grow, cap(s)))
 	copy(result, s)
 	return result
 }
@@ -31,8 +33,12 @@ func EqualRune(a, b rune, fold bool) bool {
 	if a > b {
 		a, b = b, a
 	}
-	if b < utf8.RuneSelf && 'A' <= a && a <= 'Z' {
-		if b == a+'a'-'A' {
+	if b < utf8.RuneSelf {
+		return 'A' <= a && a <= 'Z' && b == a+'a'-'A'
+	}
+	// the runes which are equivalent under the simple Unicode case folding, like strings.EqualFold
+	for r := unicode.SimpleFold(a); r != a; r = unicode.SimpleFold(r) {
+		if r == b {
 			return true
 		}
 	}
@@ -145,6 +151,28 @@ func HasPrefixFold(s, prefix []rune) bool {
 	return EqualFold(s[:len(prefix)], prefix)
 }
 
+// FuzzyScore returns the score of candidate matching query, or 0 if they don't match. candidate matches if all
+// runes of query appear in it in order, case-insensitively. The score is higher if the matched runes are consecutive
+// or at the start of candidate.
+func FuzzyScore(query, candidate []rune) int {
+	score, prev, j := 1, -2, 0
+	for i := 0; i < len(candidate) && j < len(query); i++ {
+		if !EqualRuneFold(candidate[i], query[j]) {
+			continue
+		}
+		score++
+		if i == 0 || i == prev+1 {
+			score += 2
+		}
+		prev = i
+		j++
+	}
+	if j < len(query) {
+		return 0
+	}
+	return score
+}
+
 func TrimSpaceLeft(s []rune) []rune {
 	firstIndex := len(s)
 	for i, r := range s {
@@ -190,20 +218,87 @@ func WidthAll(s []rune) (length int) {
 	return
 }
 
+// ColorFilter returns s without the ANSI escape sequences, see FilterANSI.
 func ColorFilter(s []rune) []rune {
-	newr := make([]rune, 0, len(s))
-	for pos := 0; pos < len(s); pos++ {
-		if s[pos] == '\033' && s[pos+1] == '[' {
-			idx := Index(s[pos+2:], 'm')
-			if idx == -1 {
-				continue
-			}
-			pos += idx + 2
+	filtered, _ := FilterANSI(s)
+	return filtered
+}
+
+// FilterANSI returns s without the ANSI escape sequences, and the number of the removed runes. The CSI sequences which
+// start with "\033[" or '\x9b', the OSC sequences which start with "\033]" or '\x9d' and end with BEL or ST, and the
+// other escape sequences are removed. A sequence which is truncated at the end of s is removed, and a CSI sequence
+// with a malformed byte is removed up to that byte.
+func FilterANSI(s []rune) (filtered []rune, removed int) {
+	filtered = make([]rune, 0, len(s))
+	for pos := 0; pos < len(s); {
+		n := escapeLen(s[pos:])
+		if n <= 0 {
+			filtered = append(filtered, s[pos])
+			pos++
 			continue
 		}
-		newr = append(newr, s[pos])
+		pos += n
+		removed += n
+	}
+	return filtered, removed
+}
+
+// escapeLen returns the length of the escape sequence at the start of s, or 0 if s doesn't start with one.
+func escapeLen(s []rune) int {
+	switch {
+	case len(s) >= 1 && s[0] == '\x9b':
+		return 1 + csiLen(s[1:])
+	case len(s) >= 1 && s[0] == '\x9d':
+		return 1 + oscLen(s[1:])
+	case len(s) >= 1 && s[0] == '\033':
+	default:
+		return 0
+	}
+	if len(s) < 2 {
+		return 1
+	}
+	switch s[1] {
+	case '[':
+		return 2 + csiLen(s[2:])
+	case ']':
+		return 2 + oscLen(s[2:])
+	}
+	// the other escape sequences are the intermediate bytes followed by a final byte
+	n := 1
+	for n < len(s) && s[n] >= 0x20 && s[n] <= 0x2f {
+		n++
+	}
+	if n < len(s) && s[n] >= 0x30 && s[n] <= 0x7e {
+		n++
+	}
+	return n
+}
+
+// csiLen returns the length of the parameter bytes, the intermediate bytes and the final byte of a CSI sequence at
+// the start of s. The final byte isn't counted if it's malformed.
+func csiLen(s []rune) int {
+	n := 0
+	for n < len(s) && s[n] >= 0x20 && s[n] <= 0x3f {
+		n++
+	}
+	if n < len(s) && s[n] >= 0x40 && s[n] <= 0x7e {
+		n++
+	}
+	return n
+}
+
+// oscLen returns the length of the string and the terminator of an OSC sequence at the start of s. The terminator is
+// BEL, "\033\\" or '\x9c', and the rest of s is the string if there isn't a terminator.
+func oscLen(s []rune) int {
+	for n := 0; n < len(s); n++ {
+		switch {
+		case s[n] == '\a' || s[n] == '\x9c':
+			return n + 1
+		case s[n] == '\033' && n+1 < len(s) && s[n+1] == '\\':
+			return n + 2
+		}
 	}
-	return newr
+	return len(s)
 }
 
 func FillBackspace(s []rune) []byte {
@@ -234,25 +329,55 @@ aggregate:
 	return
 }
 
-// SplitByLine splits s by line.
+// SplitByLine splits s into the lines on the screen with screenWidth columns, where s starts at the column start.
+// A tab is written as the spaces to the next tab stop at every TabWidth columns, so the rest of it continues on the
+// next lines if the screen width isn't a multiple of TabWidth, and it's in the line where it starts.
+// A wide character which doesn't fit in the rest of the line starts the next line, like the terminals do.
 func SplitByLine(start, screenWidth int, s []rune) []string {
+	return splitByLine(start, screenWidth, TabWidth, s)
+}
+
+// splitByLine is SplitByLine with the tab stops at every tabWidth columns.
+func splitByLine(start, screenWidth, tabWidth int, s []rune) []string {
+	if screenWidth <= 0 {
+		return []string{string(s)}
+	}
 	var ret []string
 	buf := bytes.NewBuffer(nil)
-	currentWidth := start
+	col := start
 	for _, r := range s {
-		w := Width(r)
-		currentWidth += w
+		if WrapsBefore(r, col, screenWidth) {
+			ret = append(ret, buf.String())
+			buf.Reset()
+			col = 0
+		}
 		buf.WriteRune(r)
-		if currentWidth >= screenWidth {
+		col += widthAt(r, col, tabWidth)
+		for col >= screenWidth {
 			ret = append(ret, buf.String())
 			buf.Reset()
-			currentWidth = 0
+			col -= screenWidth
 		}
 	}
 	ret = append(ret, buf.String())
 	return ret
 }
 
+// widthAt returns the width of r written at the column col. A tab is written as the spaces to the next tab stop
+// at every tabWidth columns.
+func widthAt(r rune, col, tabWidth int) int {
+	if r == '\t' {
+		return tabWidth - col%tabWidth
+	}
+	return Width(r)
+}
+
+// WrapsBefore returns true if r is written at the start of the next line instead of col, on the screen with
+// screenWidth columns. It's true for a wide character which doesn't fit in the rest of the line.
+func WrapsBefore(r rune, col, screenWidth int) bool {
+	return r != '\t' && col > 0 && col+Width(r) > screenWidth
+}
+
 // LineCount calculates how many lines for given width.
 func LineCount(screenWidth, width int) int {
 	result := width / screenWidth
@@ -0,0 +1,112 @@
+package runeutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		query, candidate string
+		score            int
+	}{
+		{"", "abc", 1},
+		{"abc", "abc", 10},
+		{"ABC", "abc", 10},
+		{"ac", "abc", 5},
+		{"bc", "abc", 5},
+		{"ca", "abc", 0},
+		{"abcd", "abc", 0},
+	}
+	for _, test := range tests {
+		if score := FuzzyScore([]rune(test.query), []rune(test.candidate)); score != test.score {
+			t.Errorf("query %q candidate %q: score %d, expected %d", test.query, test.candidate, score, test.score)
+		}
+	}
+}
+
+func TestFilterANSI(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+		removed  int
+	}{
+		{"plain", "plain", 0},
+		{"\033[1;31mred\033[0m", "red", 11},
+		{"\033[2Kline", "line", 4},
+		{"a\033[", "a", 2},
+		{"a\033[1;3", "a", 5},
+		{"a\033", "a", 1},
+		{"\033[1\x01x", "\x01x", 3},
+		{"\033[1;é", "é", 4},
+		{"\u009b1mbold", "bold", 3},
+		{"\033]0;title\007> ", "> ", 10},
+		{"\033]8;;http://x\033\\link\033]8;;\033\\", "link", 22},
+		{"\u009d0;title\u009c> ", "> ", 9},
+		{"\033]0;title", "", 9},
+		{"\033(Bascii", "ascii", 3},
+		{"\033=keypad", "keypad", 2},
+	}
+	for _, test := range tests {
+		filtered, removed := FilterANSI([]rune(test.s))
+		if string(filtered) != test.expected || removed != test.removed {
+			t.Errorf("%q: filtered %q removed %d, expected %q removed %d",
+				test.s, string(filtered), removed, test.expected, test.removed)
+		}
+		if s := string(ColorFilter([]rune(test.s))); s != test.expected {
+			t.Errorf("%q: color filtered %q, expected %q", test.s, s, test.expected)
+		}
+	}
+}
+
+func TestSplitByLine(t *testing.T) {
+	tests := []struct {
+		start, screenWidth int
+		s                  string
+		expected           []string
+	}{
+		{0, 10, "", []string{""}},
+		{2, 10, "abcdefgh", []string{"abcdefgh", ""}},
+		{2, 10, "abcdefghi", []string{"abcdefgh", "i"}},
+		{2, 10, "abcd\tx", []string{"abcd\tx"}},
+		{2, 10, "abcdef\tx", []string{"abcdef\t", "x"}},
+		{2, 10, "a\t\t\t\tx", []string{"a\t\t\t", "\tx"}},
+		{2, 3, "\t\tx", []string{"\t", "\t", "x"}},
+		{2, 10, "abcdef世x", []string{"abcdef世", "x"}},
+		{2, 10, "abcdefg世x", []string{"abcdefg", "世x"}},
+		{2, 10, "abcdefg\t世", []string{"abcdefg\t", "世"}},
+		{0, 10, "世界世界世", []string{"世界世界世", ""}},
+		{1, 10, "世界世界世", []string{"世界世界", "世"}},
+		{2, 0, "abc", []string{"abc"}},
+	}
+	for _, test := range tests {
+		lines := SplitByLine(test.start, test.screenWidth, []rune(test.s))
+		if !reflect.DeepEqual(lines, test.expected) {
+			t.Errorf("%q from %d in %d columns: lines %q, expected %q", test.s, test.start, test.screenWidth, lines, test.expected)
+		}
+	}
+}
+
+func TestEqualRuneFold(t *testing.T) {
+	tests := []struct {
+		a, b     rune
+		expected bool
+	}{
+		{'a', 'A', true},
+		{'a', 'b', false},
+		{'@', '`', false},
+		{'k', '\u212a', true},
+		{'ß', 'ẞ', true},
+		{'ö', 'Ö', true},
+		{'σ', 'ς', true},
+		{'ı', 'I', false},
+	}
+	for _, test := range tests {
+		if result := EqualRuneFold(test.a, test.b); result != test.expected {
+			t.Errorf("%q %q: %v, expected %v", test.a, test.b, result, test.expected)
+		}
+		if result := EqualRuneFold(test.b, test.a); result != test.expected {
+			t.Errorf("%q %q: %v, expected %v", test.b, test.a, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+//go:build go1.18
+// +build go1.18
+
+package runeutil
+
+import (
+	"testing"
+)
+
+// FuzzRuneBufferAlias calls InsertRunes and WriteRunes with the slices of the buffer itself, and compares the results
+// with the naive implementations.
+func FuzzRuneBufferAlias(f *testing.F) {
+	f.Add("abcdef", 2, 1, 4)
+	f.Add("abcdef", 0, 0, 6)
+	f.Add("abcdef", 6, 3, 6)
+	f.Add("abcdef", 4, 2, 6)
+	f.Add("abcdef", 1, 3, 5)
+	f.Add("héllo wörld", 3, 2, 9)
+	f.Fuzz(func(t *testing.T, s string, idx, start, end int) {
+		buf := []rune(s)
+		idx, start, end = fuzzIndex(idx, len(buf)), fuzzIndex(start, len(buf)), fuzzIndex(end, len(buf))
+		if start > end {
+			start, end = end, start
+		}
+		sub := Copy(buf[start:end])
+
+		// the result of InsertRunes is the buffer overwritten by sub at idx
+		expected := append(Copy(buf[:idx]), sub...)
+		if idx+len(sub) < len(buf) {
+			expected = append(expected, buf[idx+len(sub):]...)
+		}
+		rb := newTestRuneBuffer(t, s, idx)
+		// the buffer shouldn't be reallocated, so the slice of it still shares its memory
+		rb.grow(len(sub))
+		rb.InsertRunes(rb.buf[start:end])
+		assertRuneBuffer(t, rb, string(expected), idx+len(sub))
+
+		// the result of WriteRunes is sub inserted at idx
+		expected = append(append(Copy(buf[:idx]), sub...), buf[idx:]...)
+		rb = newTestRuneBuffer(t, s, idx)
+		rb.grow(len(sub))
+		rb.WriteRunes(rb.buf[start:end])
+		assertRuneBuffer(t, rb, string(expected), idx+len(sub))
+	})
+}
+
+// fuzzIndex maps the fuzzed integer i to an index in [0, n].
+func fuzzIndex(i, n int) int {
+	i %= n + 1
+	if i < 0 {
+		i += n + 1
+	}
+	return i
+}
+
+// FuzzRuneBufferOps calls the methods of RuneBuffer in the order which is encoded in ops, and checks that the cursor
+// stays in the buffer.
+func FuzzRuneBufferOps(f *testing.F) {
+	f.Add([]byte("\x00a\x00b\x01\x02\x03\x04"))
+	f.Add([]byte("\x00x\x0a\x05\x06\x0b\x0c\x0d"))
+	f.Add([]byte("\x00\xc3\x00\xb6\x0e\x01\x0f\x10\x11\x12\x13\x07\x08\x09"))
+	ops := []func(rb *RuneBuffer, arg byte){
+		func(rb *RuneBuffer, arg byte) { rb.WriteRune(rune(arg)) },
+		func(rb *RuneBuffer, arg byte) { rb.MoveBackward() },
+		func(rb *RuneBuffer, arg byte) { rb.MoveForward() },
+		func(rb *RuneBuffer, arg byte) { rb.Backspace() },
+		func(rb *RuneBuffer, arg byte) { rb.Delete() },
+		func(rb *RuneBuffer, arg byte) { rb.Kill() },
+		func(rb *RuneBuffer, arg byte) { rb.KillFront() },
+		func(rb *RuneBuffer, arg byte) { rb.KillWord() },
+		func(rb *RuneBuffer, arg byte) { rb.KillWordFront() },
+		func(rb *RuneBuffer, arg byte) { rb.Yank() },
+		func(rb *RuneBuffer, arg byte) { rb.YankPop() },
+		func(rb *RuneBuffer, arg byte) { rb.Undo() },
+		func(rb *RuneBuffer, arg byte) { rb.Redo() },
+		func(rb *RuneBuffer, arg byte) { rb.MoveToPrevWord() },
+		func(rb *RuneBuffer, arg byte) { rb.MoveToNextWord() },
+		func(rb *RuneBuffer, arg byte) { rb.SetMark() },
+		func(rb *RuneBuffer, arg byte) { rb.KillRegion() },
+		func(rb *RuneBuffer, arg byte) { rb.ExchangeMark() },
+		func(rb *RuneBuffer, arg byte) { rb.Transpose() },
+		func(rb *RuneBuffer, arg byte) { rb.InsertRunes([]rune{rune(arg)}) },
+		func(rb *RuneBuffer, arg byte) { rb.AppendString(string(rune(arg))) },
+		func(rb *RuneBuffer, arg byte) { rb.MoveToLineStart() },
+		func(rb *RuneBuffer, arg byte) { rb.MoveToLineEnd() },
+		func(rb *RuneBuffer, arg byte) { rb.ApplyCase(0, int(arg), CaseToggle) },
+		func(rb *RuneBuffer, arg byte) { rb.MoveToNthChar(rune(arg), 1, arg%2 == 0, false) },
+	}
+	f.Fuzz(func(t *testing.T, p []byte) {
+		rb := newTestRuneBuffer(t, "", 0)
+		for i := 0; i+1 < len(p); i += 2 {
+			ops[int(p[i])%len(ops)](rb, p[i+1])
+			if idx := rb.Index(); idx < 0 || idx > len(rb.buf) {
+				t.Fatalf("index %d after op %d, expected in [0, %d]", idx, p[i], len(rb.buf))
+			}
+			if n := rb.Len(); n != len(rb.buf) {
+				t.Fatalf("length %d after op %d, expected %d", n, p[i], len(rb.buf))
+			}
+		}
+	})
+}
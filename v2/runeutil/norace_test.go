@@ -0,0 +1,5 @@
+// +build !race
+
+package runeutil
+
+const raceEnabled = false
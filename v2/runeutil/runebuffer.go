@@ -1,37 +1,105 @@
 package runeutil
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
 	"strconv"
-	"strings"
 	"sync"
+	"unicode"
 )
 
 type RuneBuffer struct {
-	w           io.Writer
+	// w buffers the output to out, and it's flushed before mu is unlocked
+	w           *bufio.Writer
+	out         io.Writer
 	prompt      []rune
 	promptWidth int
+	// promptLines is the number of the newlines in the prompt, promptWidth is the width of its last line
+	promptLines int
 	mask        rune
+	noEcho      bool
+	dumb        bool
+	noColor     bool
 	interactive bool
 	screenWidth int
+	// screenHeight is the height of the screen, it's zero if it's unknown
+	screenHeight int
+	// tabWidth is the distance of the tab stops, a tab is written as the spaces to the next tab stop
+	tabWidth int
+
+	// multiLine is the multi-line mode, contPrompt is the prompt of the lines after the first one in it.
+	multiLine       bool
+	contPrompt      []rune
+	contPromptWidth int
+	// accepted is true after the buffer is accepted by Finish until it's reset.
+	accepted bool
+	// readOnly rejects the editing methods, but the buffer can still be set like by Set
+	readOnly bool
 
 	mu  sync.Mutex
 	idx int
 	buf []rune
 
 	backup *runeBufferBackup
+	// backupStack is the stack of PushBackup, it's independent of backup
+	backupStack []*runeBufferBackup
 
 	hadClean bool
+	// lastOutput is the output of the last print if nothing else is written after it, so it's on the screen
+	lastOutput []byte
+	// writeErr is the first write error since it's reset
+	writeErr error
 
-	lastKill []rune
+	killRing     [][]rune
+	killRingSize int
+	yankDepth    int
+
+	wordBreakChars []rune
+
+	menu []string
+
+	// hintProvider returns the hint which is displayed after the buffer in hintStyle, and lastHint is the last
+	// displayed one.
+	hintProvider func(line []rune, pos int) []rune
+	hintStyle    string
+	lastHint     []rune
+
+	// rightPrompt returns the prompt which is displayed at the right edge of the row of the cursor.
+	rightPrompt func() string
+
+	// highlighter returns the style ranges of the buffer, styleRanges is its last result for highlighted.
+	highlighter func(buf []rune) []StyleRange
+	highlighted []rune
+	styleRanges []StyleRange
+	// highlighting is true while the highlighter is running, highlightGen is incremented when the highlighter is
+	// replaced, so the result of the previous one is discarded.
+	highlighting bool
+	highlightGen int
+
+	// mark is the other end of the region, it's valid if markSet is true.
+	mark    int
+	markSet bool
+
+	undoStack []runeBufferBackup
+	redoStack []runeBufferBackup
+	undoDepth int
+
+	// op is the kind of the current operation, lastOp is the kind of the previous one.
+	op     editOp
+	lastOp editOp
 }
 
 func NewRuneBuffer(w io.Writer, prompt string, mask rune, interactive bool, screenWidth int) (*RuneBuffer, error) {
 	var err error
 	rb := &RuneBuffer{
-		w: w,
+		w:            bufio.NewWriterSize(w, writeBufferSize),
+		out:          w,
+		killRingSize: DefaultKillRingSize,
+		undoDepth:    DefaultUndoDepth,
+		tabWidth:     TabWidth,
+		hintStyle:    HintStyle,
 	}
 
 	rb.setPrompt(prompt)
@@ -51,9 +119,38 @@ func (rb *RuneBuffer) SetPrompt(prompt string) {
 	})
 }
 
+// SetPromptBuf sets the prompt without refreshing the screen, so it's displayed by the next refresh.
+func (rb *RuneBuffer) SetPromptBuf(prompt string) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.setPrompt(prompt)
+}
+
+func (rb *RuneBuffer) Prompt() string {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return string(rb.prompt)
+}
+
+// PromptWidth returns the visual width of the last line of the prompt without the ANSI escape sequences.
+func (rb *RuneBuffer) PromptWidth() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.promptWidth
+}
+
 func (rb *RuneBuffer) setPrompt(prompt string) {
 	rb.prompt = []rune(prompt)
-	rb.promptWidth = WidthAll(ColorFilter(rb.prompt))
+	// the prompt lines before the last one don't affect the layout of the buffer
+	last := rb.prompt
+	rb.promptLines = 0
+	for i, r := range rb.prompt {
+		if r == '\n' {
+			last = rb.prompt[i+1:]
+			rb.promptLines++
+		}
+	}
+	rb.promptWidth = WidthAll(ColorFilter(last))
 }
 
 func (rb *RuneBuffer) SetMask(mask rune) {
@@ -62,10 +159,90 @@ func (rb *RuneBuffer) SetMask(mask rune) {
 	})
 }
 
+// Mask returns the rune which is displayed instead of the buffer runes, or 0 if the buffer is displayed.
+func (rb *RuneBuffer) Mask() rune {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.mask
+}
+
 func (rb *RuneBuffer) setMask(mask rune) {
 	rb.mask = mask
 }
 
+// SetNoEcho sets the no-echo mode. In the no-echo mode, only the prompt is printed and the buffer isn't displayed.
+func (rb *RuneBuffer) SetNoEcho(on bool) {
+	rb.Refresh(func() {
+		rb.noEcho = on
+	})
+}
+
+func (rb *RuneBuffer) NoEcho() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.noEcho
+}
+
+// SetDumb sets the dumb terminal mode. In the dumb terminal mode, no escape sequence is written.
+func (rb *RuneBuffer) SetDumb(on bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.dumb = on
+}
+
+// SetNoColor sets the no-color mode. In the no-color mode, the SGR sequences in the prompt and the menu are
+// removed, and no style is written.
+func (rb *RuneBuffer) SetNoColor(on bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.noColor = on
+}
+
+// SetHintProvider sets the function which returns the hint displayed after the buffer, like an auto-suggestion.
+// The hint isn't a part of the buffer until AcceptHint is called.
+func (rb *RuneBuffer) SetHintProvider(f func(line []rune, pos int) []rune) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.hintProvider = f
+}
+
+// SetHintStyle sets the SGR parameter of the hint, it's HintStyle by default.
+func (rb *RuneBuffer) SetHintStyle(style string) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.hintStyle = style
+}
+
+// SetHighlighter sets the function which returns the style ranges of the buffer. It's called when the buffer
+// changes, and the returned ranges are displayed by their styles.
+func (rb *RuneBuffer) SetHighlighter(f func(buf []rune) []StyleRange) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.highlighter = f
+	rb.highlighted = nil
+	rb.styleRanges = nil
+	rb.highlighting = false
+	rb.highlightGen++
+}
+
+// SetRightPrompt sets the function which returns the right prompt. The right prompt is displayed at the right edge
+// of the row of the cursor if it doesn't collide with the buffer.
+func (rb *RuneBuffer) SetRightPrompt(f func() string) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.rightPrompt = f
+}
+
+// SetMultiLine sets the multi-line mode. In the multi-line mode, the newlines in the buffer start new lines, and
+// these lines are prefixed with continuationPrompt.
+func (rb *RuneBuffer) SetMultiLine(on bool, continuationPrompt string) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.multiLine = on
+	rb.contPrompt = []rune(continuationPrompt)
+	rb.contPromptWidth = WidthAll(ColorFilter(rb.contPrompt))
+}
+
 func (rb *RuneBuffer) SetInteractive(on bool) {
 	rb.mu.Lock()
 	rb.setInteractive(on)
@@ -73,6 +250,13 @@ func (rb *RuneBuffer) SetInteractive(on bool) {
 	rb.Refresh(nil)
 }
 
+// Interactive returns true if the buffer is displayed.
+func (rb *RuneBuffer) Interactive() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.interactive
+}
+
 func (rb *RuneBuffer) setInteractive(on bool) {
 	rb.interactive = on
 }
@@ -93,6 +277,124 @@ func (rb *RuneBuffer) setScreenWidth(screenWidth int) error {
 	return nil
 }
 
+// SetScreenHeight sets the height of the screen, and refreshes the screen. The clean-up doesn't move the cursor
+// above the screen, and the menu is cut to fit in the screen with the buffer.
+func (rb *RuneBuffer) SetScreenHeight(screenHeight int) error {
+	var err error
+	rb.Refresh(func() {
+		err = rb.setScreenHeight(screenHeight)
+	})
+	return err
+}
+
+func (rb *RuneBuffer) setScreenHeight(screenHeight int) error {
+	if screenHeight <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidScreenHeight, screenHeight)
+	}
+	rb.screenHeight = screenHeight
+	return nil
+}
+
+// ScreenHeight returns the height of the screen, it returns zero if it isn't set.
+func (rb *RuneBuffer) ScreenHeight() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.screenHeight
+}
+
+// SetTabWidth sets the distance of the tab stops, and refreshes the screen.
+func (rb *RuneBuffer) SetTabWidth(tabWidth int) error {
+	var err error
+	rb.Refresh(func() {
+		err = rb.setTabWidth(tabWidth)
+	})
+	return err
+}
+
+func (rb *RuneBuffer) setTabWidth(tabWidth int) error {
+	if tabWidth <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidTabWidth, tabWidth)
+	}
+	rb.tabWidth = tabWidth
+	return nil
+}
+
+// TabWidth returns the distance of the tab stops.
+func (rb *RuneBuffer) TabWidth() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.tabWidth
+}
+
+// runeWidth returns the width of r written at the column col.
+func (rb *RuneBuffer) runeWidth(r rune, col int) int {
+	return widthAt(r, col, rb.tabWidth)
+}
+
+// widthFrom returns the width of s written from the column col without wrapping the lines.
+func (rb *RuneBuffer) widthFrom(col int, s []rune) int {
+	width := 0
+	for _, r := range s {
+		width += rb.runeWidth(r, col+width)
+	}
+	return width
+}
+
+// SetKillRingSize sets the number of entries kept in the kill ring. If size is zero, DefaultKillRingSize is used.
+func (rb *RuneBuffer) SetKillRingSize(size int) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if size <= 0 {
+		size = DefaultKillRingSize
+	}
+	rb.killRingSize = size
+	if len(rb.killRing) > size {
+		rb.killRing = append(rb.killRing[:0], rb.killRing[len(rb.killRing)-size:]...)
+	}
+}
+
+// SetWordBreakChars sets the runes which separate words. If s is empty, IsWordBreak is used to separate words.
+func (rb *RuneBuffer) SetWordBreakChars(s string) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.wordBreakChars = []rune(s)
+}
+
+// isWordBreak reports whether r separates words.
+func (rb *RuneBuffer) isWordBreak(r rune) bool {
+	if len(rb.wordBreakChars) == 0 {
+		return IsWordBreak(r)
+	}
+	return Index(rb.wordBreakChars, r) >= 0
+}
+
+// SetUndoDepth sets the maximum number of undo steps. If depth is zero, DefaultUndoDepth is used.
+func (rb *RuneBuffer) SetUndoDepth(depth int) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if depth <= 0 {
+		depth = DefaultUndoDepth
+	}
+	rb.undoDepth = depth
+	if len(rb.undoStack) > depth {
+		rb.undoStack = append(rb.undoStack[:0], rb.undoStack[len(rb.undoStack)-depth:]...)
+	}
+}
+
+// SetMenu sets the lines which are displayed below the buffer, like completion candidates.
+// Each line should fit in the screen width.
+func (rb *RuneBuffer) SetMenu(lines []string) {
+	rb.Refresh(func() {
+		rb.menu = lines
+	})
+}
+
+func (rb *RuneBuffer) ScreenWidth() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.screenWidth
+}
+
 func (rb *RuneBuffer) Index() int {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
@@ -191,19 +493,115 @@ func (rb *RuneBuffer) widthAt(idx int, count int) int {
 func (rb *RuneBuffer) Refresh(f func()) {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
+	rb.refresh(f)
+}
+
+// Redraw is Refresh(nil), and it returns the first error which occurs while writing.
+func (rb *RuneBuffer) Redraw() error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.writeErr = nil
+	rb.refresh(nil)
+	return rb.writeErr
+}
 
+// Recover prints the prompt and the buffer again without erasing the last output, like after the screen is cleared
+// by another program, so the screen is in an unknown state. It returns the first error which occurs while writing.
+func (rb *RuneBuffer) Recover() error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.writeErr = nil
 	if !rb.interactive {
-		if f != nil {
-			f()
-		}
+		return nil
+	}
+	rb.hadClean = true
+	rb.print()
+	rb.flush()
+	return rb.writeErr
+}
+
+func (rb *RuneBuffer) refresh(f func()) {
+	defer rb.flush()
+	if !rb.interactive {
+		rb.apply(f)
+		return
+	}
+	if rb.canAppend() {
+		rb.refreshAppend(f)
 		return
 	}
 
 	rb.clean()
 	defer rb.print()
-	if f != nil {
-		f()
+	rb.apply(f)
+	rb.highlight()
+}
+
+// canAppend returns true if the screen can be updated by writing only the changed suffix of the output after
+// an insertion at the end of the buffer. The callbacks may change the output without changing the buffer, and
+// the style ranges of the highlighter are written over the buffer, so they need the full redraw.
+func (rb *RuneBuffer) canAppend() bool {
+	return len(rb.lastOutput) > 0 && !rb.hadClean && rb.isCursorInEnd() && len(rb.menu) == 0 &&
+		rb.highlighter == nil && rb.hintProvider == nil && rb.rightPrompt == nil
+}
+
+// refreshAppend is refresh when canAppend is true. If f inserts a rune at the end of the buffer, and the output
+// starts with the last output, only the rest of it is written. Otherwise, the screen is cleaned and printed again.
+func (rb *RuneBuffer) refreshAppend(f func()) {
+	n, idxLine, screenWidth := len(rb.buf), rb.idxLine(), rb.screenWidth
+	rb.apply(f)
+
+	buf := getOutputBuffer()
+	defer putOutputBuffer(buf)
+	rb.outputPrint(buf)
+	p := buf.Bytes()
+	if len(rb.buf) == n+1 && rb.isCursorInEnd() && rb.screenWidth == screenWidth && bytes.HasPrefix(p, rb.lastOutput) {
+		rb.write(p[len(rb.lastOutput):])
+	} else {
+		rb.cleanWithIdxLine(idxLine)
+		rb.hadClean = false
+		rb.write(p)
+	}
+	rb.lastOutput = append(rb.lastOutput, p...)
+}
+
+// highlight starts the highlighter in a goroutine if the buffer has changed since it was highlighted and
+// the highlighter isn't running. The highlighter is called with a copy of the buffer without holding the lock, and
+// the screen is refreshed with its result if the buffer is still the same. Otherwise, it's started again for
+// the current buffer. The style ranges are displayed only while the buffer is same as the highlighted one.
+func (rb *RuneBuffer) highlight() {
+	if rb.highlighter == nil || rb.highlighting || (rb.highlighted != nil && Equal(rb.highlighted, rb.buf)) {
+		return
+	}
+	rb.highlighting = true
+	buf, highlighter, gen := Copy(rb.buf), rb.highlighter, rb.highlightGen
+	go func() {
+		styleRanges := highlighter(Copy(buf))
+		rb.mu.Lock()
+		defer rb.mu.Unlock()
+		if gen != rb.highlightGen {
+			return
+		}
+		rb.highlighting = false
+		rb.highlighted, rb.styleRanges = buf, styleRanges
+		if !Equal(buf, rb.buf) {
+			rb.highlight()
+			return
+		}
+		if rb.interactive && !rb.hadClean && !rb.accepted && len(styleRanges) > 0 {
+			rb.refresh(nil)
+		}
+	}()
+}
+
+// apply calls f if it isn't nil. f may set rb.op to the kind of its operation, and rb.lastOp holds
+// the kind of the previous operation while f is called.
+func (rb *RuneBuffer) apply(f func()) {
+	if f == nil {
+		return
 	}
+	rb.lastOp, rb.op = rb.op, editOther
+	f()
 }
 
 func (rb *RuneBuffer) Set(idx int, buf []rune) {
@@ -220,7 +618,8 @@ func (rb *RuneBuffer) SetBuf(idx int, buf []rune) {
 
 func (rb *RuneBuffer) setBuf(idx int, buf []rune) {
 	rb.idx = idx
-	rb.buf = CopyAndGrow(buf, cap(buf)-len(buf))
+	rb.buf = CopyAndGrow(buf, 0)
+	rb.op = editOther
 }
 
 func (rb *RuneBuffer) Reset() {
@@ -238,6 +637,23 @@ func (rb *RuneBuffer) ResetBuf() {
 func (rb *RuneBuffer) resetBuf() {
 	rb.idx = 0
 	rb.buf = rb.buf[:0]
+	rb.undoStack = rb.undoStack[:0]
+	rb.redoStack = rb.redoStack[:0]
+	rb.op = editOther
+	rb.markSet = false
+	rb.lastHint = nil
+	rb.accepted = false
+}
+
+// Discard cleans the buffer from the screen and resets it. It returns the discarded buffer.
+func (rb *RuneBuffer) Discard() []rune {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.clean()
+	rb.flush()
+	buf := Copy(rb.buf)
+	rb.resetBuf()
+	return buf
 }
 
 func (rb *RuneBuffer) SetRunes(s []rune) {
@@ -247,7 +663,7 @@ func (rb *RuneBuffer) SetRunes(s []rune) {
 func (rb *RuneBuffer) Backup() {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
-	rb.backup = &runeBufferBackup{rb.buf, rb.idx}
+	rb.backup = &runeBufferBackup{Copy(rb.buf), rb.idx}
 }
 
 func (rb *RuneBuffer) Restore() {
@@ -255,104 +671,546 @@ func (rb *RuneBuffer) Restore() {
 		if rb.backup == nil {
 			return
 		}
-		rb.buf = rb.backup.buf
+		rb.buf = Copy(rb.backup.buf)
 		rb.idx = rb.backup.idx
 	})
 }
 
+// PushBackup pushes a copy of the buffer and the cursor position onto the backup stack. It doesn't affect Backup.
+func (rb *RuneBuffer) PushBackup() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.backupStack = append(rb.backupStack, &runeBufferBackup{Copy(rb.buf), rb.idx})
+}
+
+// PopRestore pops the last backup from the backup stack, and restores the buffer and the cursor position from it.
+// It returns false if the backup stack is empty.
+func (rb *RuneBuffer) PopRestore() (success bool) {
+	rb.Refresh(func() {
+		n := len(rb.backupStack)
+		if n <= 0 {
+			return
+		}
+		backup := rb.backupStack[n-1]
+		rb.backupStack[n-1] = nil
+		rb.backupStack = rb.backupStack[:n-1]
+		rb.buf = backup.buf
+		rb.idx = backup.idx
+		success = true
+	})
+	return
+}
+
+// BackupDepth returns the number of the backups in the backup stack.
+func (rb *RuneBuffer) BackupDepth() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return len(rb.backupStack)
+}
+
+// write writes p to the screen, so the last output isn't there anymore. p is buffered until flush.
 func (rb *RuneBuffer) write(p []byte) {
+	rb.lastOutput = rb.lastOutput[:0]
+	if _, err := rb.w.Write(p); err != nil && rb.writeErr == nil {
+		rb.writeErr = err
+	}
+}
+
+// Flush writes the buffered output. The methods which write flush it before returning, so there is nothing to flush
+// between them.
+func (rb *RuneBuffer) Flush() error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.flushErr()
+}
+
+func (rb *RuneBuffer) flush() {
+	if err := rb.flushErr(); err != nil && rb.writeErr == nil {
+		rb.writeErr = err
+	}
+}
+
+// flushErr flushes the buffered output. The output which couldn't be written is discarded, so the next writes don't
+// fail with the same error.
+func (rb *RuneBuffer) flushErr() error {
+	err := rb.w.Flush()
+	if err != nil {
+		rb.w.Reset(rb.out)
+	}
+	return err
+}
+
+// Bell writes the bell character.
+func (rb *RuneBuffer) Bell() error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	_, _ = rb.w.Write([]byte{'\a'})
+	return rb.flushErr()
+}
+
+// WriteSequence writes the escape sequence p which doesn't change the screen content, like a cursor shape, between
+// the redraws. Nothing is written if the buffer isn't interactive or it's dumb.
+func (rb *RuneBuffer) WriteSequence(p []byte) error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if !rb.interactive || rb.dumb {
+		return nil
+	}
 	_, _ = rb.w.Write(p)
+	return rb.flushErr()
 }
 
 func (rb *RuneBuffer) print() {
 	rb.hadClean = false
-	rb.write(rb.outputPrint())
+	buf := getOutputBuffer()
+	rb.outputPrint(buf)
+	rb.write(buf.Bytes())
+	rb.lastOutput = append(rb.lastOutput, buf.Bytes()...)
+	putOutputBuffer(buf)
 }
 
-func (rb *RuneBuffer) outputPrint() []byte {
-	buf := bytes.NewBuffer(nil)
-	buf.WriteString(string(rb.prompt))
+// outputPrint writes the prompt and the buffer to buf, and moves the cursor to idx.
+func (rb *RuneBuffer) outputPrint(buf *bytes.Buffer) {
+	if rb.dumb {
+		rb.outputPrintDumb(buf)
+		return
+	}
+	if rb.noColor {
+		writeRunes(buf, ColorFilter(rb.prompt))
+	} else {
+		writeRunes(buf, rb.prompt)
+	}
+	if rb.noEcho {
+		if len(rb.buf) > 0 && rb.buf[len(rb.buf)-1] == '\n' {
+			buf.WriteByte('\n')
+		}
+		return
+	}
 	if rb.mask != 0 && len(rb.buf) > 0 {
-		buf.Write([]byte(strings.Repeat(string(rb.mask), len(rb.buf)-1)))
+		for i := 0; i < len(rb.buf)-1; i++ {
+			buf.WriteRune(rb.mask)
+		}
 		if rb.buf[len(rb.buf)-1] == '\n' {
-			buf.Write([]byte{'\n'})
+			buf.WriteByte('\n')
 		} else {
-			buf.Write([]byte(string(rb.mask)))
+			buf.WriteRune(rb.mask)
 		}
 		if len(rb.buf) > rb.idx {
-			buf.Write(rb.getBackspaceSequence())
+			rb.writeBackspaceSequence(buf)
 		}
 	} else {
-		for _, c := range rb.buf {
+		start, end, region := rb.region()
+		region = region && !rb.noColor
+		row, col := 0, rb.promptWidth
+		for i, c := range rb.buf {
+			var w int
+			row, col, w = rb.advance(row, col, c)
+			if c == '\n' && rb.multiLine {
+				rb.writeNewline(buf, i)
+				continue
+			}
+			if region && i == start {
+				buf.WriteString("\033[" + RegionStyle + "m")
+			}
+			if region && i == end {
+				buf.WriteString("\033[0m")
+			}
 			if c == '\t' {
-				buf.WriteString(strings.Repeat(" ", TabWidth))
+				writeRepeat(buf, " ", w)
 			} else {
 				buf.WriteRune(c)
 			}
 		}
+		if region && end == len(rb.buf) {
+			buf.WriteString("\033[0m")
+		}
 		if rb.isInLineEdge() {
 			buf.Write([]byte(" \b"))
 		}
+		rb.writeHint(buf)
+		rb.writeStyleRanges(buf)
+		rb.writeRightPrompt(buf)
+	}
+	if len(rb.menuLines()) > 0 {
+		rb.writeMenu(buf)
 	}
 	// cursor position
 	if len(rb.buf) > rb.idx {
-		buf.Write(rb.getBackspaceSequence())
+		rb.writeBackspaceSequence(buf)
 	}
-	return buf.Bytes()
 }
 
-func (rb *RuneBuffer) getBackspaceSequence() []byte {
-	var sep = map[int]bool{}
-
-	for idx, size := 0, WidthAll(rb.buf); idx < size; idx++ {
-		if idx == 0 {
-			idx -= rb.promptWidth
-		}
-		idx += rb.screenWidth
-
-		sep[idx] = true
+// writeNewline writes the newline at idx and the continuation prompt in the multi-line mode.
+func (rb *RuneBuffer) writeNewline(buf *bytes.Buffer, idx int) {
+	if rb.isEdgeAt(idx) {
+		buf.WriteString(" \b")
+	}
+	buf.WriteString("\n")
+	if rb.noColor {
+		writeRunes(buf, ColorFilter(rb.contPrompt))
+	} else {
+		writeRunes(buf, rb.contPrompt)
 	}
+}
 
-	var buf []byte
-	for idx := len(rb.buf); idx > rb.idx; idx-- {
-		if sep[idx] {
-			// up one line, go to the start of the line and move cursor right to the end (rb.screenWidth)
-			buf = append(buf, "\033[A"+"\r"+"\033["+strconv.Itoa(rb.screenWidth)+"C"...)
+// writeStyleRanges writes the style ranges of the highlighter over the buffer if they are for the current buffer.
+func (rb *RuneBuffer) writeStyleRanges(buf *bytes.Buffer) {
+	if rb.noColor || len(rb.styleRanges) == 0 || !Equal(rb.highlighted, rb.buf) {
+		return
+	}
+	row, _ := rb.position(len(rb.buf))
+	for _, r := range rb.styleRanges {
+		if r.Start < 0 || r.End > len(rb.buf) || r.Start >= r.End {
 			continue
 		}
-		// move input to the left of one
-		buf = append(buf, '\b')
+		rb.writeStyle(buf, row, r.Start, r.End, r.Style, len(rb.buf))
 	}
-
-	return buf
 }
 
-func (rb *RuneBuffer) Clean() {
-	rb.mu.Lock()
-	defer rb.mu.Unlock()
-	rb.clean()
-}
-
-func (rb *RuneBuffer) clean() {
-	rb.cleanWithIdxLine(rb.idxLine())
+// writeRightPrompt writes the right prompt at the right edge of the row of the cursor, and moves the cursor back to
+// the end of the buffer. It isn't written if it collides with the buffer or the hint.
+func (rb *RuneBuffer) writeRightPrompt(buf *bytes.Buffer) {
+	if rb.rightPrompt == nil {
+		return
+	}
+	prompt := []rune(rb.rightPrompt())
+	if rb.noColor {
+		prompt = ColorFilter(prompt)
+	}
+	width := WidthAll(ColorFilter(prompt))
+	row, _ := rb.position(rb.idx)
+	endRow, _ := rb.position(len(rb.buf))
+	used := rb.rowWidth(row)
+	if row == endRow {
+		used += WidthAll(rb.lastHint)
+	}
+	if width <= 0 || used >= rb.screenWidth-width {
+		return
+	}
+	if endRow > row {
+		writeCSI(buf, endRow-row, 'A')
+	}
+	writeCSI(buf, rb.screenWidth-width+1, 'G')
+	writeRunes(buf, prompt)
+	rb.writeMoveSequence(buf, row, len(rb.buf))
+}
+
+// rowWidth returns the width of the row on the screen, including the prompt.
+func (rb *RuneBuffer) rowWidth(row int) int {
+	r, col := 0, rb.promptWidth
+	for _, c := range rb.buf {
+		if c == '\n' && rb.multiLine {
+			if r == row {
+				return col
+			}
+			r++
+			col = rb.contPromptWidth
+			continue
+		}
+		if WrapsBefore(c, col, rb.screenWidth) {
+			if r == row {
+				return col
+			}
+			r++
+			col = 0
+		}
+		col += rb.runeWidth(c, col)
+		for col >= rb.screenWidth {
+			if r == row {
+				return rb.screenWidth
+			}
+			r++
+			col -= rb.screenWidth
+		}
+	}
+	if r == row {
+		return col
+	}
+	return 0
 }
 
-func (rb *RuneBuffer) cleanWithIdxLine(idxLine int) {
-	if rb.hadClean || !rb.interactive {
+// writeHint writes the hint after the buffer in the hint style, and moves the cursor back to the end of the buffer.
+// The hint is truncated to fit in the current line.
+func (rb *RuneBuffer) writeHint(buf *bytes.Buffer) {
+	rb.lastHint = nil
+	if rb.hintProvider == nil || rb.accepted || (!rb.multiLine && len(rb.buf) > 0 && rb.buf[len(rb.buf)-1] == '\n') {
 		return
 	}
-	rb.hadClean = true
-	rb.write(rb.outputCleanWithIdxLine(idxLine))
+	rb.lastHint = Copy(rb.hintProvider(Copy(rb.buf), rb.idx))
+	_, col := rb.position(len(rb.buf))
+	hint := rb.lastHint
+	for len(hint) > 0 && col+WidthAll(hint) >= rb.screenWidth {
+		hint = hint[:len(hint)-1]
+	}
+	if len(hint) == 0 {
+		return
+	}
+	if !rb.noColor {
+		buf.WriteString("\033[" + rb.hintStyle + "m")
+	}
+	writeRunes(buf, hint)
+	if !rb.noColor {
+		buf.WriteString("\033[0m")
+	}
+	writeRepeat(buf, "\b", WidthAll(hint))
 }
 
-func (rb *RuneBuffer) outputCleanWithIdxLine(idxLine int) []byte {
-	buf := bytes.NewBuffer(nil)
+// outputPrintDumb is outputPrint for the dumb terminals. It writes the prompt without the escape sequences and
+// the buffer, and moves the cursor by backspaces.
+func (rb *RuneBuffer) outputPrintDumb(buf *bytes.Buffer) {
+	writeRunes(buf, ColorFilter(rb.prompt))
+	if rb.noEcho {
+		if len(rb.buf) > 0 && rb.buf[len(rb.buf)-1] == '\n' {
+			buf.WriteByte('\n')
+		}
+		return
+	}
+	col := rb.promptWidth
+	for _, c := range rb.buf {
+		switch {
+		case rb.mask != 0 && c != '\n':
+			buf.WriteRune(rb.mask)
+		case c == '\t':
+			writeRepeat(buf, " ", rb.runeWidth(c, col))
+		default:
+			buf.WriteRune(c)
+		}
+		col += rb.runeWidth(c, col)
+	}
+	rb.writeBackspaceSequence(buf)
+}
+
+// writeMenu writes the menu lines below the buffer, and moves the cursor back to the end of the buffer.
+func (rb *RuneBuffer) writeMenu(buf *bytes.Buffer) {
+	menu := rb.menuLines()
+	for _, line := range menu {
+		buf.WriteString("\n")
+		if rb.noColor {
+			line = string(ColorFilter([]rune(line)))
+		}
+		buf.WriteString(line)
+	}
+	writeCSI(buf, len(menu), 'A')
+	buf.WriteByte('\r')
+	if _, col := rb.position(len(rb.buf)); col > 0 {
+		writeCSI(buf, col, 'C')
+	}
+}
+
+// menuLines returns the menu lines which fit in the screen below the buffer if the screen height is known.
+func (rb *RuneBuffer) menuLines() []string {
+	if rb.screenHeight <= 0 {
+		return rb.menu
+	}
+	row, _ := rb.position(len(rb.buf))
+	n := rb.screenHeight - row - 1
+	if n < 0 {
+		n = 0
+	}
+	if len(rb.menu) > n {
+		return rb.menu[:n]
+	}
+	return rb.menu
+}
+
+// writeBackspaceSequence writes the sequence which moves the cursor from the end of the buffer to idx.
+func (rb *RuneBuffer) writeBackspaceSequence(buf *bytes.Buffer) {
+	if rb.dumb {
+		col := rb.promptWidth + rb.widthFrom(rb.promptWidth, rb.buf[:rb.idx])
+		writeRepeat(buf, "\b", rb.widthFrom(col, rb.buf[rb.idx:]))
+		return
+	}
+	// the widths of the tabs and the wrapped wide characters depend on their columns
+	if rb.multiLine || rb.hasWrapBefore() || Index(rb.buf, '\t') >= 0 {
+		rb.writeCursorSequence(buf)
+		return
+	}
+	// the lines are wrapped at the screen edge after the display widths first, first+screenWidth, ... of the buffer
+	first := rb.screenWidth - rb.promptWidth
+	width := WidthAll(rb.buf)
+	for idx := len(rb.buf) - 1; idx >= rb.idx; idx-- {
+		for n := Width(rb.buf[idx]); n > 0; n-- {
+			if width >= first && (width-first)%rb.screenWidth == 0 {
+				// up one line, go to the start of the line and move cursor right to the end (rb.screenWidth)
+				buf.WriteString("\033[A\r")
+				writeCSI(buf, rb.screenWidth, 'C')
+			} else {
+				// move input to the left of one
+				buf.WriteByte('\b')
+			}
+			width--
+		}
+	}
+}
+
+// hasWrapBefore returns true if a rune of the buffer is wrapped before it by WrapsBefore, so the display widths of
+// the runes don't match their positions on the screen.
+func (rb *RuneBuffer) hasWrapBefore() bool {
+	col := rb.promptWidth
+	for _, r := range rb.buf {
+		if WrapsBefore(r, col, rb.screenWidth) {
+			return true
+		}
+		col = (col + rb.runeWidth(r, col)) % rb.screenWidth
+	}
+	return false
+}
+
+// writeCursorSequence writes the sequence which moves the cursor from the end of the buffer to idx by the rows and
+// the columns.
+func (rb *RuneBuffer) writeCursorSequence(buf *bytes.Buffer) {
+	endRow, _ := rb.position(len(rb.buf))
+	rb.writeMoveSequence(buf, endRow, rb.idx)
+}
+
+// writeMoveSequence writes the sequence which moves the cursor from the row fromRow to the position of idx.
+func (rb *RuneBuffer) writeMoveSequence(buf *bytes.Buffer, fromRow, idx int) {
+	row, col := rb.position(idx)
+	switch {
+	case fromRow > row:
+		writeCSI(buf, fromRow-row, 'A')
+	case fromRow < row:
+		writeCSI(buf, row-fromRow, 'B')
+	}
+	buf.WriteByte('\r')
+	if col > 0 {
+		writeCSI(buf, col, 'C')
+	}
+}
+
+// writeCSI writes the control sequence with the parameter n and the final byte.
+func writeCSI(buf *bytes.Buffer, n int, final byte) {
+	var b [24]byte
+	p := append(b[:0], "\033["...)
+	p = strconv.AppendInt(p, int64(n), 10)
+	buf.Write(append(p, final))
+}
+
+// writeRepeat writes n copies of s.
+func writeRepeat(buf *bytes.Buffer, s string, n int) {
+	for i := 0; i < n; i++ {
+		buf.WriteString(s)
+	}
+}
+
+// writeRunes writes s in UTF-8.
+func writeRunes(buf *bytes.Buffer, s []rune) {
+	for _, r := range s {
+		buf.WriteRune(r)
+	}
+}
+
+// position returns the row and the column of idx on the screen, relative to the start of the prompt.
+// The lines are wrapped at the display width of the screen, and the newlines start new lines in the multi-line mode.
+func (rb *RuneBuffer) position(idx int) (row, col int) {
+	col = rb.promptWidth
+	for _, r := range rb.buf[:idx] {
+		row, col, _ = rb.advance(row, col, r)
+	}
+	return
+}
+
+// advance returns the row and the column after r is written at row and col, and the width of r.
+func (rb *RuneBuffer) advance(row, col int, r rune) (int, int, int) {
+	if r == '\n' && rb.multiLine {
+		return row + 1, rb.contPromptWidth, 0
+	}
+	if WrapsBefore(r, col, rb.screenWidth) {
+		row++
+		col = 0
+	}
+	// a tab is written as spaces, so the rest of it continues on the next line
+	w := rb.runeWidth(r, col)
+	col += w
+	for col >= rb.screenWidth {
+		row++
+		col -= rb.screenWidth
+	}
+	return row, col, w
+}
+
+// isEdgeAt returns true if idx is at the start of a line which is wrapped at the screen edge.
+func (rb *RuneBuffer) isEdgeAt(idx int) bool {
+	if idx <= 0 || rb.multiLine && rb.buf[idx-1] == '\n' {
+		return false
+	}
+	_, col := rb.position(idx)
+	return col == 0
+}
+
+// Finish moves the cursor to the end of the buffer, and writes a newline without the continuation prompt.
+// It accepts the buffer in the multi-line mode, and the buffer should be reset after it.
+func (rb *RuneBuffer) Finish() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.idx = len(rb.buf)
+	rb.accepted = true
+	if !rb.interactive {
+		return
+	}
+	rb.clean()
+	rb.print()
+	rb.write([]byte("\n"))
+	rb.flush()
+}
+
+// WriteAbove cleans the buffer, writes p, and prints the buffer again. So p is displayed above the buffer
+// if it ends with a newline.
+func (rb *RuneBuffer) WriteAbove(p []byte) (int, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.clean()
+	n, _ := rb.w.Write(p)
+	if rb.interactive {
+		rb.print()
+	}
+	if err := rb.flushErr(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (rb *RuneBuffer) Clean() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.clean()
+	rb.flush()
+}
+
+func (rb *RuneBuffer) clean() {
+	rb.cleanWithIdxLine(rb.idxLine())
+}
+
+func (rb *RuneBuffer) cleanWithIdxLine(idxLine int) {
+	if rb.hadClean || !rb.interactive {
+		return
+	}
+	rb.hadClean = true
+	buf := getOutputBuffer()
+	rb.outputCleanWithIdxLine(buf, idxLine)
+	rb.write(buf.Bytes())
+	putOutputBuffer(buf)
+}
+
+// outputCleanWithIdxLine writes the sequence which erases the prompt and the buffer to buf, where the cursor is on
+// the row idxLine from the first line of the prompt.
+func (rb *RuneBuffer) outputCleanWithIdxLine(buf *bytes.Buffer, idxLine int) {
+	if rb.dumb {
+		buf.WriteString("\r")
+		writeRepeat(buf, " ", rb.promptWidth+rb.widthFrom(rb.promptWidth, rb.buf))
+		buf.WriteString("\r")
+		return
+	}
 	if rb.screenWidth <= 0 {
-		buf.WriteString(strings.Repeat("\r\b", rb.promptWidth+len(rb.buf)))
-		buf.Write([]byte("\033[J"))
-		return buf.Bytes()
+		writeRepeat(buf, "\r\b", rb.promptWidth+len(rb.buf))
+		buf.WriteString("\033[J")
+		return
 	}
 	buf.Write([]byte("\033[J")) // just like ^k :)
+	if rb.screenHeight > 0 && idxLine > rb.screenHeight-1 {
+		// the rows above the screen can't be reached
+		idxLine = rb.screenHeight - 1
+	}
 	if idxLine == 0 {
 		buf.WriteString("\033[2K")
 		buf.WriteString("\r")
@@ -362,7 +1220,6 @@ func (rb *RuneBuffer) outputCleanWithIdxLine(idxLine int) []byte {
 		}
 		buf.WriteString("\033[2K\r")
 	}
-	return buf.Bytes()
 }
 
 func (rb *RuneBuffer) IdxLine() int {
@@ -371,18 +1228,24 @@ func (rb *RuneBuffer) IdxLine() int {
 	return rb.idxLine()
 }
 
+// idxLine returns the row of the cursor from the first line of the prompt.
 func (rb *RuneBuffer) idxLine() int {
-	sp := rb.getSplitByLine(rb.buf[:rb.idx])
-	return len(sp) - 1
+	if rb.noEcho {
+		return rb.promptLines
+	}
+	row, _ := rb.position(rb.idx)
+	return rb.promptLines + row
 }
 
 func (rb *RuneBuffer) isInLineEdge() bool {
-	sp := rb.getSplitByLine(rb.buf)
-	return len(sp[len(sp)-1]) == 0
-}
-
-func (rb *RuneBuffer) getSplitByLine(s []rune) []string {
-	return SplitByLine(rb.promptWidth, rb.screenWidth, s)
+	if rb.multiLine {
+		return rb.isEdgeAt(len(rb.buf))
+	}
+	if len(rb.buf) == 0 {
+		return true
+	}
+	_, col := rb.position(len(rb.buf))
+	return col == 0
 }
 
 func (rb *RuneBuffer) IsCursorInEnd() bool {
@@ -401,8 +1264,19 @@ func (rb *RuneBuffer) LineCount() int {
 	return rb.lineCount()
 }
 
+// lineCount returns the number of the rows of the prompt and the buffer.
 func (rb *RuneBuffer) lineCount() int {
-	return LineCount(rb.screenWidth, rb.promptWidth+WidthAll(rb.buf))
+	if rb.noEcho {
+		return rb.promptLines + LineCount(rb.screenWidth, rb.promptWidth)
+	}
+	if !rb.multiLine && len(rb.buf) == 0 {
+		return rb.promptLines + LineCount(rb.screenWidth, rb.promptWidth)
+	}
+	row, _ := rb.position(len(rb.buf))
+	if rb.isInLineEdge() {
+		return rb.promptLines + row
+	}
+	return rb.promptLines + row + 1
 }
 
 func (rb *RuneBuffer) CursorLineCount() int {
@@ -420,20 +1294,99 @@ func (rb *RuneBuffer) WriteBytes(p []byte) {
 }
 
 func (rb *RuneBuffer) WriteString(s string) {
-	rb.WriteRunes([]rune(s))
+	rb.Refresh(func() {
+		rb.writeRunes([]rune(s))
+	})
 }
 
+// WriteRunes inserts s at the cursor. s may share its memory with the buffer, it's copied before the buffer is
+// modified.
 func (rb *RuneBuffer) WriteRunes(s []rune) {
 	rb.Refresh(func() {
-		rem := rb.buf[rb.idx:]
-		tail := append(CopyAndGrow(s, len(rem)), rem...)
-		rb.buf = append(rb.buf[:rb.idx], tail...)
-		rb.idx += len(s)
+		rb.writeRunes(Copy(s))
 	})
 }
 
+// writeRunes inserts s at the cursor. s must not share its memory with the buffer, because the runes after the cursor
+// are moved before s is copied.
+func (rb *RuneBuffer) writeRunes(s []rune) {
+	if rb.readOnly {
+		return
+	}
+	rb.pushUndoInsert(len(s))
+	rb.grow(len(s))
+	n := len(rb.buf)
+	rb.buf = rb.buf[:n+len(s)]
+	copy(rb.buf[rb.idx+len(s):], rb.buf[rb.idx:n])
+	copy(rb.buf[rb.idx:], s)
+	rb.idx += len(s)
+}
+
+// SetReadOnly sets the read-only mode. The editing methods, like WriteRunes, Backspace, Kill or Undo, don't modify
+// the buffer in it, and the ones returning a bool return false. The cursor movements and the methods which set the
+// buffer, like Set and Reset, still work.
+func (rb *RuneBuffer) SetReadOnly(readOnly bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.readOnly = readOnly
+}
+
+// IsReadOnly returns true in the read-only mode.
+func (rb *RuneBuffer) IsReadOnly() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.readOnly
+}
+
+// SetContents replaces the buffer with s, and moves the cursor to the end. Unlike Set, the replacement can be undone,
+// and it isn't done if s is same as the buffer.
+func (rb *RuneBuffer) SetContents(s string) {
+	rb.Refresh(func() {
+		if rb.readOnly {
+			return
+		}
+		buf := []rune(s)
+		if Equal(buf, rb.buf) {
+			rb.idx = len(rb.buf)
+			return
+		}
+		rb.pushUndo()
+		rb.setBuf(len(buf), buf)
+	})
+}
+
+// AppendString appends s to the end of the buffer without moving the cursor, unlike WriteString which inserts s at
+// the cursor.
+func (rb *RuneBuffer) AppendString(s string) {
+	rb.Refresh(func() {
+		if rb.readOnly {
+			return
+		}
+		if s == "" {
+			return
+		}
+		rs := []rune(s)
+		rb.pushUndo()
+		rb.grow(len(rs))
+		rb.buf = append(rb.buf, rs...)
+	})
+}
+
+// grow makes sure that n runes can be appended to the buffer without allocating. The buffer is replaced with a larger
+// copy by CopyAndGrow if it's needed, and the old one is freed.
+func (rb *RuneBuffer) grow(n int) {
+	if len(rb.buf)+n <= cap(rb.buf) {
+		return
+	}
+	buf := CopyAndGrow(rb.buf, n)
+	freeRunes(rb.buf)
+	rb.buf = buf
+}
+
 func (rb *RuneBuffer) WriteRune(r rune) {
-	rb.WriteRunes([]rune{r})
+	rb.Refresh(func() {
+		rb.writeRunes([]rune{r})
+	})
 }
 
 func (rb *RuneBuffer) InsertBytes(p []byte) {
@@ -441,309 +1394,1135 @@ func (rb *RuneBuffer) InsertBytes(p []byte) {
 }
 
 func (rb *RuneBuffer) InsertString(s string) {
-	rb.InsertRunes([]rune(s))
+	rb.Refresh(func() {
+		rb.insertRunes([]rune(s))
+	})
+}
+
+// InsertRunes writes s over the runes at the cursor, and appends the rest of s to the buffer. s may share its memory
+// with the buffer, it's copied before the buffer is modified.
+func (rb *RuneBuffer) InsertRunes(s []rune) {
+	rb.Refresh(func() {
+		rb.insertRunes(Copy(s))
+	})
+}
+
+// insertRunes is InsertRunes, but s must not share its memory with the buffer, because the rest of s is read after
+// the runes at the cursor are overwritten.
+func (rb *RuneBuffer) insertRunes(s []rune) {
+	if rb.readOnly {
+		return
+	}
+	rb.pushUndoInsert(len(s))
+	rb.grow(len(s))
+	rb.buf = append(rb.buf, s[copy(rb.buf[rb.idx:], s):]...)
+	rb.idx += len(s)
+}
+
+func (rb *RuneBuffer) InsertRune(r rune) {
+	rb.Refresh(func() {
+		rb.insertRunes([]rune{r})
+	})
+}
+
+func (rb *RuneBuffer) MoveToLineStart() (success bool) {
+	rb.Refresh(func() {
+		if rb.idx == 0 {
+			return
+		}
+		rb.idx = 0
+		success = true
+	})
+	return
+}
+
+func (rb *RuneBuffer) MoveToLineEnd() (success bool) {
+	rb.Refresh(func() {
+		if rb.idx == len(rb.buf) {
+			return
+		}
+		rb.idx = len(rb.buf)
+		success = true
+	})
+	return
+}
+
+// MoveUp moves the cursor to the previous line of the buffer which is separated by newlines, and keeps the column
+// of the cursor if possible.
+func (rb *RuneBuffer) MoveUp() (success bool) {
+	rb.Refresh(func() {
+		start := rb.lineStart(rb.idx)
+		if start <= 0 {
+			return
+		}
+		rb.idx = rb.columnIdx(rb.lineStart(start-1), rb.widthFrom(0, rb.buf[start:rb.idx]))
+		success = true
+	})
+	return
+}
+
+// MoveDown moves the cursor to the next line of the buffer which is separated by newlines, and keeps the column
+// of the cursor if possible.
+func (rb *RuneBuffer) MoveDown() (success bool) {
+	rb.Refresh(func() {
+		end := rb.idx
+		for end < len(rb.buf) && rb.buf[end] != '\n' {
+			end++
+		}
+		if end >= len(rb.buf) {
+			return
+		}
+		rb.idx = rb.columnIdx(end+1, rb.widthFrom(0, rb.buf[rb.lineStart(rb.idx):rb.idx]))
+		success = true
+	})
+	return
+}
+
+// lineStart returns the start index of the line which contains idx.
+func (rb *RuneBuffer) lineStart(idx int) int {
+	for idx > 0 && rb.buf[idx-1] != '\n' {
+		idx--
+	}
+	return idx
+}
+
+// columnIdx returns the index in the line which starts at start, where the width from start reaches width.
+func (rb *RuneBuffer) columnIdx(start, width int) int {
+	idx, col := start, 0
+	for idx < len(rb.buf) && rb.buf[idx] != '\n' {
+		w := rb.runeWidth(rb.buf[idx], col)
+		if col+w > width {
+			break
+		}
+		col += w
+		idx++
+	}
+	return idx
+}
+
+func (rb *RuneBuffer) MoveBackward() (success bool) {
+	rb.Refresh(func() {
+		if rb.idx == 0 {
+			return
+		}
+		rb.idx--
+		success = true
+	})
+	return
+}
+
+func (rb *RuneBuffer) MoveForward() (success bool) {
+	rb.Refresh(func() {
+		if rb.idx == len(rb.buf) {
+			return
+		}
+		rb.idx++
+		success = true
+	})
+	return
+}
+
+func (rb *RuneBuffer) MoveToPrevWord() (success bool) {
+	rb.Refresh(func() {
+		success = rb.moveToPrevWord(rb.isWordBreak)
+	})
+	return
+}
+
+func (rb *RuneBuffer) MoveToNextWord() (success bool) {
+	rb.Refresh(func() {
+		success = rb.moveToNextWord(rb.isWordBreak)
+	})
+	return
+}
+
+func (rb *RuneBuffer) MoveToEndWord() (success bool) {
+	rb.Refresh(func() {
+		success = rb.moveToEndWord(rb.isWordBreak)
+	})
+	return
+}
+
+// MoveToPrevBigWord is MoveToPrevWord with the words which are separated only by the white spaces, like the WORDs
+// of vi.
+func (rb *RuneBuffer) MoveToPrevBigWord() (success bool) {
+	rb.Refresh(func() {
+		success = rb.moveToPrevWord(unicode.IsSpace)
+	})
+	return
+}
+
+// MoveToNextBigWord is MoveToNextWord with the words which are separated only by the white spaces, like the WORDs
+// of vi.
+func (rb *RuneBuffer) MoveToNextBigWord() (success bool) {
+	rb.Refresh(func() {
+		success = rb.moveToNextWord(unicode.IsSpace)
+	})
+	return
+}
+
+// MoveToEndBigWord is MoveToEndWord with the words which are separated only by the white spaces, like the WORDs
+// of vi.
+func (rb *RuneBuffer) MoveToEndBigWord() (success bool) {
+	rb.Refresh(func() {
+		success = rb.moveToEndWord(unicode.IsSpace)
+	})
+	return
+}
+
+// moveToPrevWord moves the cursor to the start of the word before it, where the words are separated by the runes
+// which isBreak reports.
+func (rb *RuneBuffer) moveToPrevWord(isBreak func(rune) bool) bool {
+	if rb.idx == 0 {
+		return false
+	}
+
+	for i := rb.idx - 1; i > 0; i-- {
+		if !isBreak(rb.buf[i]) && isBreak(rb.buf[i-1]) {
+			rb.idx = i
+			return true
+		}
+	}
+
+	rb.idx = 0
+	return true
+}
+
+// moveToNextWord moves the cursor to the start of the word after it, where the words are separated by the runes
+// which isBreak reports.
+func (rb *RuneBuffer) moveToNextWord(isBreak func(rune) bool) bool {
+	for i := rb.idx + 1; i < len(rb.buf); i++ {
+		if !isBreak(rb.buf[i]) && isBreak(rb.buf[i-1]) {
+			rb.idx = i
+			return true
+		}
+	}
+
+	rb.idx = len(rb.buf)
+	return true
+}
+
+// moveToEndWord moves the cursor to the end of the word, where the words are separated by the runes which isBreak
+// reports.
+func (rb *RuneBuffer) moveToEndWord(isBreak func(rune) bool) bool {
+	// already at the end, so do nothing
+	if rb.idx == len(rb.buf) {
+		return false
+	}
+	// if we are at the end of a word already, go to next
+	if rb.idx+1 < len(rb.buf) && !isBreak(rb.buf[rb.idx]) && isBreak(rb.buf[rb.idx+1]) {
+		rb.idx++
+	}
+
+	// keep going until at the end of a word
+	for i := rb.idx + 1; i < len(rb.buf); i++ {
+		if isBreak(rb.buf[i]) && !isBreak(rb.buf[i-1]) {
+			rb.idx = i - 1
+			return true
+		}
+	}
+
+	rb.idx = len(rb.buf)
+	return true
+}
+
+// MoveTo moves the cursor to the next ch after the cursor, or the previous one before the cursor if reverse is true.
+// If prevChar is true, the cursor is moved to the rune before ch, or after it in reverse.
+func (rb *RuneBuffer) MoveTo(ch rune, prevChar, reverse bool) (success bool) {
+	return rb.MoveToNthChar(ch, 1, reverse, prevChar)
+}
+
+// MoveToNthChar is MoveTo for the nth ch from the cursor, like "2fx" in vi. It fails without moving if there are
+// fewer than nth ch.
+func (rb *RuneBuffer) MoveToNthChar(ch rune, nth int, reverse, prevChar bool) (success bool) {
+	rb.Refresh(func() {
+		var idx int
+		var found bool
+		if reverse {
+			idx, found = rb.findBackward(ch, nth)
+		} else {
+			idx, found = rb.findForward(ch, nth)
+		}
+		if !found {
+			return
+		}
+		rb.idx = idx
+		if prevChar {
+			if reverse {
+				rb.idx++
+			} else {
+				rb.idx--
+			}
+		}
+		success = true
+	})
+	return
+}
+
+// FindForward returns the index of the nth ch after the cursor. nth is 1 for the first one.
+func (rb *RuneBuffer) FindForward(ch rune, nth int) (idx int, found bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.findForward(ch, nth)
+}
+
+func (rb *RuneBuffer) findForward(ch rune, nth int) (idx int, found bool) {
+	if nth < 1 {
+		return -1, false
+	}
+	for i := rb.idx + 1; i < len(rb.buf); i++ {
+		if rb.buf[i] == ch {
+			nth--
+			if nth == 0 {
+				return i, true
+			}
+		}
+	}
+	return -1, false
+}
+
+// FindBackward returns the index of the nth ch before the cursor. nth is 1 for the first one.
+func (rb *RuneBuffer) FindBackward(ch rune, nth int) (idx int, found bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.findBackward(ch, nth)
+}
+
+func (rb *RuneBuffer) findBackward(ch rune, nth int) (idx int, found bool) {
+	if nth < 1 {
+		return -1, false
+	}
+	for i := rb.idx - 1; i >= 0; i-- {
+		if rb.buf[i] == ch {
+			nth--
+			if nth == 0 {
+				return i, true
+			}
+		}
+	}
+	return -1, false
+}
+
+// MoveToString moves the cursor to the next occurrence of s after the cursor, or the previous one before the cursor if
+// reverse is true. Like MoveTo, the cursor is moved to the rune before the occurrence, or after the start of it in
+// reverse, if prevChar is true. It fails without moving if s isn't found.
+func (rb *RuneBuffer) MoveToString(s string, prevChar, reverse bool) (success bool) {
+	rb.Refresh(func() {
+		r := []rune(s)
+		if len(r) == 0 {
+			return
+		}
+		if reverse {
+			for i := rb.idx - 1; i >= 0; i-- {
+				if hasRunePrefix(rb.buf[i:], r) {
+					rb.idx = i
+					if prevChar {
+						rb.idx++
+					}
+					success = true
+					return
+				}
+			}
+			return
+		}
+		for i := rb.idx + 1; i+len(r) <= len(rb.buf); i++ {
+			if hasRunePrefix(rb.buf[i:], r) {
+				rb.idx = i
+				if prevChar {
+					rb.idx--
+				}
+				success = true
+				return
+			}
+		}
+	})
+	return
+}
+
+// hasRunePrefix returns true if buf begins with prefix.
+func hasRunePrefix(buf, prefix []rune) bool {
+	if len(buf) < len(prefix) {
+		return false
+	}
+	for i, r := range prefix {
+		if buf[i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// MoveToWordBoundary moves the cursor to the start of the next word if forward is true, and to the end of the
+// previous word otherwise. It fails without moving if there is no such word.
+func (rb *RuneBuffer) MoveToWordBoundary(forward bool) (success bool) {
+	rb.Refresh(func() {
+		i := rb.idx
+		if forward {
+			for i < len(rb.buf) && !rb.isWordBreak(rb.buf[i]) {
+				i++
+			}
+			for i < len(rb.buf) && rb.isWordBreak(rb.buf[i]) {
+				i++
+			}
+			if i >= len(rb.buf) {
+				return
+			}
+		} else {
+			for i > 0 && !rb.isWordBreak(rb.buf[i-1]) {
+				i--
+			}
+			for i > 0 && rb.isWordBreak(rb.buf[i-1]) {
+				i--
+			}
+			if i <= 0 {
+				return
+			}
+		}
+		rb.idx = i
+		success = true
+	})
+	return
+}
+
+func (rb *RuneBuffer) Backspace() (success bool) {
+	rb.Refresh(func() {
+		if rb.readOnly {
+			return
+		}
+		if rb.idx == 0 {
+			return
+		}
+		// consecutive backspaces are coalesced into one undo step
+		if rb.lastOp != editBackspace {
+			rb.pushUndo()
+		}
+		rb.op = editBackspace
+		rb.idx--
+		rb.buf = append(rb.buf[:rb.idx], rb.buf[rb.idx+1:]...)
+		success = true
+	})
+	return
+}
+
+func (rb *RuneBuffer) Transpose() (success bool) {
+	rb.Refresh(func() {
+		if rb.readOnly {
+			return
+		}
+		if len(rb.buf) <= 1 {
+			return
+		}
+
+		if rb.idx == 0 {
+			//rb.idx = 1
+			return
+		}
+		rb.pushUndo()
+		if rb.idx >= len(rb.buf) {
+			rb.idx = len(rb.buf) - 1
+		}
+		rb.buf[rb.idx], rb.buf[rb.idx-1] = rb.buf[rb.idx-1], rb.buf[rb.idx]
+		rb.idx++
+		success = true
+	})
+	return
+}
+
+// TransposeWords drags the word before the cursor past the word at or after the cursor, and moves the cursor
+// to the end of that word. If the cursor is at the end of the line, it transposes the last two words.
+func (rb *RuneBuffer) TransposeWords() (success bool) {
+	rb.Refresh(func() {
+		if rb.readOnly {
+			return
+		}
+		w2Start := rb.wordStart(rb.wordEnd(rb.idx))
+		w2End := rb.wordEnd(w2Start)
+		w1Start := rb.wordStart(w2Start)
+		w1End := rb.wordEnd(w1Start)
+		if w1Start == w2Start || w2Start < w1End {
+			return
+		}
+		rb.pushUndo()
+		buf := make([]rune, 0, len(rb.buf))
+		buf = append(buf, rb.buf[:w1Start]...)
+		buf = append(buf, rb.buf[w2Start:w2End]...)
+		buf = append(buf, rb.buf[w1End:w2Start]...)
+		buf = append(buf, rb.buf[w1Start:w1End]...)
+		buf = append(buf, rb.buf[w2End:]...)
+		rb.buf = buf
+		rb.idx = w2End
+		success = true
+	})
+	return
+}
+
+// wordEnd returns the end of the word at or after idx.
+func (rb *RuneBuffer) wordEnd(idx int) int {
+	for idx < len(rb.buf) && rb.isWordBreak(rb.buf[idx]) {
+		idx++
+	}
+	for idx < len(rb.buf) && !rb.isWordBreak(rb.buf[idx]) {
+		idx++
+	}
+	return idx
+}
+
+// wordStart returns the start of the word before idx.
+func (rb *RuneBuffer) wordStart(idx int) int {
+	for idx > 0 && rb.isWordBreak(rb.buf[idx-1]) {
+		idx--
+	}
+	for idx > 0 && !rb.isWordBreak(rb.buf[idx-1]) {
+		idx--
+	}
+	return idx
+}
+
+// CurrentWord returns the word at the cursor like WordAt.
+func (rb *RuneBuffer) CurrentWord() (word []rune, start, end int) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	start, end = rb.wordAt(rb.idx)
+	return Copy(rb.buf[start:end]), start, end
+}
+
+// WordAt returns the word which contains or touches idx, and its start and end in the buffer. If idx is between
+// the word breaks, it returns the word before them like Emacs, or an empty word at idx if there is none.
+func (rb *RuneBuffer) WordAt(idx int) (word []rune, start, end int) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	start, end = rb.wordAt(idx)
+	return Copy(rb.buf[start:end]), start, end
+}
+
+func (rb *RuneBuffer) wordAt(idx int) (start, end int) {
+	if idx < 0 {
+		idx = 0
+	}
+	if idx > len(rb.buf) {
+		idx = len(rb.buf)
+	}
+	start, end = idx, idx
+	if (idx >= len(rb.buf) || rb.isWordBreak(rb.buf[idx])) && (idx <= 0 || rb.isWordBreak(rb.buf[idx-1])) {
+		for start > 0 && rb.isWordBreak(rb.buf[start-1]) {
+			start--
+		}
+		if start == 0 {
+			return idx, idx
+		}
+		end = start
+	}
+	for start > 0 && !rb.isWordBreak(rb.buf[start-1]) {
+		start--
+	}
+	for end < len(rb.buf) && !rb.isWordBreak(rb.buf[end]) {
+		end++
+	}
+	return start, end
+}
+
+// tokenSpan is the start and the end of a token in a buffer.
+type tokenSpan struct {
+	Start, End int
+}
+
+// tokenize returns the spans of the tokens in buf which are separated by the runes that isBreak returns true for.
+func tokenize(buf []rune, isBreak func(rune) bool) []tokenSpan {
+	var spans []tokenSpan
+	for i := 0; i < len(buf); {
+		for i < len(buf) && isBreak(buf[i]) {
+			i++
+		}
+		if i >= len(buf) {
+			break
+		}
+		start := i
+		for i < len(buf) && !isBreak(buf[i]) {
+			i++
+		}
+		spans = append(spans, tokenSpan{start, i})
+	}
+	return spans
+}
+
+// Words returns the tokens of the buffer which are separated by the word breaks.
+func (rb *RuneBuffer) Words() []string {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	spans := tokenize(rb.buf, rb.isWordBreak)
+	words := make([]string, len(spans))
+	for i, span := range spans {
+		words[i] = string(rb.buf[span.Start:span.End])
+	}
+	return words
+}
+
+// WordCount returns the number of the tokens which Words returns.
+func (rb *RuneBuffer) WordCount() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	n := 0
+	for i, r := range rb.buf {
+		if !rb.isWordBreak(r) && (i == 0 || rb.isWordBreak(rb.buf[i-1])) {
+			n++
+		}
+	}
+	return n
+}
+
+// TokenAt returns the token of Words which contains or touches idx, and its index in Words. If idx is between
+// the word breaks, it returns an empty token and the index which a token typed at idx would have, like the index of
+// the next argument for a completer.
+func (rb *RuneBuffer) TokenAt(idx int) (token string, tokenIdx int) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	for i, span := range tokenize(rb.buf, rb.isWordBreak) {
+		if idx < span.Start {
+			return "", i
+		}
+		if idx <= span.End {
+			return string(rb.buf[span.Start:span.End]), i
+		}
+		tokenIdx = i + 1
+	}
+	return "", tokenIdx
 }
 
-func (rb *RuneBuffer) InsertRunes(s []rune) {
+// DeleteCurrentWord kills the word at the cursor which CurrentWord returns, and moves the cursor to its start.
+// It fails if there is no word.
+func (rb *RuneBuffer) DeleteCurrentWord() (success bool) {
 	rb.Refresh(func() {
-		rb.buf = append(rb.buf, s[copy(rb.buf[rb.idx:], s):]...)
-		rb.idx += len(s)
+		if rb.readOnly {
+			return
+		}
+		start, end := rb.wordAt(rb.idx)
+		if start == end {
+			return
+		}
+		rb.pushUndo()
+		rb.pushKill(rb.buf[start:end])
+		rb.buf = append(rb.buf[:start], rb.buf[end:]...)
+		rb.idx = start
+		success = true
 	})
+	return
 }
 
-func (rb *RuneBuffer) InsertRune(r rune) {
-	rb.InsertRunes([]rune{r})
+func (rb *RuneBuffer) Erase() (success bool) {
+	rb.Refresh(func() {
+		if rb.readOnly {
+			return
+		}
+		if len(rb.buf) == 0 {
+			return
+		}
+		rb.pushUndo()
+		rb.idx = 0
+		rb.pushKill(rb.buf[:])
+		rb.buf = rb.buf[:0]
+		success = true
+	})
+	return
 }
 
-func (rb *RuneBuffer) MoveToLineStart() (success bool) {
+func (rb *RuneBuffer) Delete() (success bool) {
 	rb.Refresh(func() {
-		if rb.idx == 0 {
+		if rb.readOnly {
 			return
 		}
-		rb.idx = 0
+		if rb.idx == len(rb.buf) {
+			return
+		}
+		rb.pushUndo()
+		rb.pushKill(rb.buf[rb.idx : rb.idx+1])
+		rb.buf = append(rb.buf[:rb.idx], rb.buf[rb.idx+1:]...)
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) MoveToLineEnd() (success bool) {
+func (rb *RuneBuffer) KillWord() (success bool) {
 	rb.Refresh(func() {
+		if rb.readOnly {
+			return
+		}
 		if rb.idx == len(rb.buf) {
+			rb.keepKill()
 			return
 		}
-		rb.idx = len(rb.buf)
+		rb.pushUndo()
+		init := rb.idx
+		for init < len(rb.buf) && rb.isWordBreak(rb.buf[init]) {
+			init++
+		}
+		for i := init + 1; i < len(rb.buf); i++ {
+			if !rb.isWordBreak(rb.buf[i]) && rb.isWordBreak(rb.buf[i-1]) {
+				rb.appendKill(rb.buf[rb.idx:i-1], false)
+				rb.buf = append(rb.buf[:rb.idx], rb.buf[i-1:]...)
+				success = true
+				return
+			}
+		}
+		rb.appendKill(rb.buf[rb.idx:], false)
+		rb.buf = rb.buf[:rb.idx]
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) MoveBackward() (success bool) {
+func (rb *RuneBuffer) KillWordFront() (success bool) {
 	rb.Refresh(func() {
+		if rb.readOnly {
+			return
+		}
 		if rb.idx == 0 {
+			rb.keepKill()
 			return
 		}
-		rb.idx--
+		rb.pushUndo()
+		// like GNU readline, the word breaks before the cursor are killed with the word before them
+		i := rb.idx
+		for i > 0 && rb.isWordBreak(rb.buf[i-1]) {
+			i--
+		}
+		for i > 0 && !rb.isWordBreak(rb.buf[i-1]) {
+			i--
+		}
+		rb.appendKill(rb.buf[i:rb.idx], true)
+		rb.buf = append(rb.buf[:i], rb.buf[rb.idx:]...)
+		rb.idx = i
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) MoveForward() (success bool) {
+func (rb *RuneBuffer) Kill() (success bool) {
 	rb.Refresh(func() {
+		if rb.readOnly {
+			return
+		}
 		if rb.idx == len(rb.buf) {
+			rb.keepKill()
 			return
 		}
-		rb.idx++
+		rb.pushUndo()
+		rb.appendKill(rb.buf[rb.idx:], false)
+		rb.buf = rb.buf[:rb.idx]
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) MoveToPrevWord() (success bool) {
+func (rb *RuneBuffer) KillFront() (success bool) {
 	rb.Refresh(func() {
-		if rb.idx == 0 {
+		if rb.readOnly {
 			return
 		}
-
-		for i := rb.idx - 1; i > 0; i-- {
-			if !IsWordBreak(rb.buf[i]) && IsWordBreak(rb.buf[i-1]) {
-				rb.idx = i
-				success = true
-				return
-			}
+		if rb.idx == 0 {
+			rb.keepKill()
+			return
 		}
-
+		rb.pushUndo()
+		length := len(rb.buf) - rb.idx
+		rb.appendKill(rb.buf[:rb.idx], true)
+		copy(rb.buf[:length], rb.buf[rb.idx:])
 		rb.idx = 0
+		rb.buf = rb.buf[:length]
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) MoveToNextWord() (success bool) {
+func (rb *RuneBuffer) Yank() (success bool) {
 	rb.Refresh(func() {
-		for i := rb.idx + 1; i < len(rb.buf); i++ {
-			if !IsWordBreak(rb.buf[i]) && IsWordBreak(rb.buf[i-1]) {
-				rb.idx = i
-				success = true
-				return
-			}
+		if rb.readOnly {
+			return
 		}
-
-		rb.idx = len(rb.buf)
+		if len(rb.killRing) == 0 {
+			return
+		}
+		rb.pushUndo()
+		rb.insertYank(rb.idx, rb.idx, rb.killRingEntry(0))
+		rb.yankDepth = 0
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) MoveToEndWord() (success bool) {
+// YankPop replaces the text inserted by the previous Yank or YankPop with the next older entry of the kill ring.
+// It fails unless the previous operation is Yank or YankPop.
+func (rb *RuneBuffer) YankPop() (success bool) {
 	rb.Refresh(func() {
-		// already at the end, so do nothing
-		if rb.idx == len(rb.buf) {
+		if rb.readOnly {
 			return
 		}
-		// if we are at the end of a word already, go to next
-		if !IsWordBreak(rb.buf[rb.idx]) && IsWordBreak(rb.buf[rb.idx+1]) {
-			rb.idx++
-		}
-
-		// keep going until at the end of a word
-		for i := rb.idx + 1; i < len(rb.buf); i++ {
-			if IsWordBreak(rb.buf[i]) && !IsWordBreak(rb.buf[i-1]) {
-				rb.idx = i - 1
-				success = true
-				return
-			}
+		if rb.lastOp != editYank || len(rb.killRing) == 0 {
+			return
 		}
-
-		rb.idx = len(rb.buf)
+		rb.pushUndo()
+		start := rb.idx - len(rb.killRingEntry(rb.yankDepth))
+		rb.yankDepth++
+		rb.insertYank(start, rb.idx, rb.killRingEntry(rb.yankDepth))
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) MoveTo(ch rune, prevChar, reverse bool) (success bool) {
+// insertYank replaces the runes between start and end with s, and moves the cursor to the end of s.
+func (rb *RuneBuffer) insertYank(start, end int, s []rune) {
+	buf := make([]rune, 0, len(rb.buf)-(end-start)+len(s))
+	buf = append(buf, rb.buf[:start]...)
+	buf = append(buf, s...)
+	buf = append(buf, rb.buf[end:]...)
+	rb.buf = buf
+	rb.idx = start + len(s)
+	rb.op = editYank
+}
+
+// killRingEntry returns the kill ring entry which is depth entries older than the most recent one.
+// depth wraps around at the ring boundary.
+func (rb *RuneBuffer) killRingEntry(depth int) []rune {
+	return rb.killRing[len(rb.killRing)-1-depth%len(rb.killRing)]
+}
+
+// appendKill pushes s to the kill ring like pushKill. If the previous operation is a kill too, s is joined to the most
+// recent entry instead, before it if front is true and after it otherwise, so consecutive kills are yanked at once.
+func (rb *RuneBuffer) appendKill(s []rune, front bool) {
+	rb.op = editKill
+	if rb.lastOp != editKill || len(rb.killRing) == 0 {
+		rb.pushKill(s)
+		return
+	}
+	last := &rb.killRing[len(rb.killRing)-1]
+	if front {
+		*last = append(Copy(s), *last...)
+	} else {
+		*last = append(*last, s...)
+	}
+}
+
+// keepKill keeps the previous kill as the last operation for the next kill, so a failed kill doesn't break the
+// consecutive kills.
+func (rb *RuneBuffer) keepKill() {
+	if rb.lastOp == editKill {
+		rb.op = editKill
+	}
+}
+
+func (rb *RuneBuffer) pushKill(s []rune) {
+	rb.killRing = append(rb.killRing, Copy(s))
+	if len(rb.killRing) > rb.killRingSize {
+		rb.killRing = append(rb.killRing[:0], rb.killRing[len(rb.killRing)-rb.killRingSize:]...)
+	}
+}
+
+// AcceptHint appends the last displayed hint to the buffer. It fails unless the cursor is at the end of the buffer.
+func (rb *RuneBuffer) AcceptHint() (success bool) {
 	rb.Refresh(func() {
-		if reverse {
-			for i := rb.idx - 1; i >= 0; i-- {
-				if rb.buf[i] == ch {
-					rb.idx = i
-					if prevChar {
-						rb.idx++
-					}
-					success = true
-					return
-				}
-			}
+		if rb.readOnly {
 			return
 		}
-		for i := rb.idx + 1; i < len(rb.buf); i++ {
-			if rb.buf[i] == ch {
-				rb.idx = i
-				if prevChar {
-					rb.idx--
-				}
-				success = true
-				return
-			}
+		if rb.idx != len(rb.buf) || len(rb.lastHint) == 0 {
+			return
 		}
+		rb.pushUndo()
+		rb.buf = append(rb.buf, rb.lastHint...)
+		rb.idx = len(rb.buf)
+		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) Backspace() (success bool) {
+// SetMark sets the mark at the cursor. The region is between the mark and the cursor.
+func (rb *RuneBuffer) SetMark() (success bool) {
 	rb.Refresh(func() {
-		if rb.idx == 0 {
-			return
-		}
-		rb.idx--
-		rb.buf = append(rb.buf[:rb.idx], rb.buf[rb.idx+1:]...)
+		rb.mark = rb.idx
+		rb.markSet = true
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) Transpose() (success bool) {
+// ExchangeMark moves the cursor to the mark, and sets the mark at the cursor before it. It fails if the mark isn't set.
+func (rb *RuneBuffer) ExchangeMark() (success bool) {
 	rb.Refresh(func() {
-		if len(rb.buf) <= 1 {
+		if !rb.markSet {
 			return
 		}
-
-		if rb.idx == 0 {
-			//rb.idx = 1
-			return
-		} else if rb.idx >= len(rb.buf) {
-			rb.idx = len(rb.buf) - 1
+		mark := rb.mark
+		if mark > len(rb.buf) {
+			mark = len(rb.buf)
 		}
-		rb.buf[rb.idx], rb.buf[rb.idx-1] = rb.buf[rb.idx-1], rb.buf[rb.idx]
-		rb.idx++
+		rb.mark, rb.idx = rb.idx, mark
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) Erase() (success bool) {
+// Mark returns the mark. ok is false if it isn't set.
+func (rb *RuneBuffer) Mark() (mark int, ok bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.mark, rb.markSet
+}
+
+// KillRegion kills the runes in the region, and clears the mark. It fails if the mark isn't set.
+func (rb *RuneBuffer) KillRegion() (success bool) {
 	rb.Refresh(func() {
-		if len(rb.buf) == 0 {
+		if rb.readOnly {
 			return
 		}
-		rb.idx = 0
-		rb.pushKill(rb.buf[:])
-		rb.buf = rb.buf[:0]
+		start, end, ok := rb.region()
+		if !ok {
+			rb.keepKill()
+			return
+		}
+		rb.pushUndo()
+		rb.appendKill(rb.buf[start:end], rb.idx == start)
+		rb.buf = append(rb.buf[:start], rb.buf[end:]...)
+		rb.idx = start
+		rb.markSet = false
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) Delete() (success bool) {
+// CopyRegion copies the runes in the region to the kill ring, and clears the mark. It fails if the mark isn't set.
+func (rb *RuneBuffer) CopyRegion() (success bool) {
 	rb.Refresh(func() {
-		if rb.idx == len(rb.buf) {
+		start, end, ok := rb.region()
+		if !ok {
 			return
 		}
-		rb.pushKill(rb.buf[rb.idx : rb.idx+1])
-		rb.buf = append(rb.buf[:rb.idx], rb.buf[rb.idx+1:]...)
+		rb.pushKill(rb.buf[start:end])
+		rb.markSet = false
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) KillWord() (success bool) {
+// region returns the bounds of the region. ok is false if the mark isn't set.
+func (rb *RuneBuffer) region() (start, end int, ok bool) {
+	if !rb.markSet {
+		return 0, 0, false
+	}
+	start, end = rb.mark, rb.idx
+	if start > len(rb.buf) {
+		start = len(rb.buf)
+	}
+	if start > end {
+		start, end = end, start
+	}
+	return start, end, true
+}
+
+// UpperCaseWord converts the runes from the cursor to the end of the current or next word to upper case,
+// and moves the cursor to the end of the word.
+func (rb *RuneBuffer) UpperCaseWord() bool {
+	return rb.caseWord(func(i int, r rune) rune {
+		return unicode.ToUpper(r)
+	})
+}
+
+// LowerCaseWord converts the runes from the cursor to the end of the current or next word to lower case,
+// and moves the cursor to the end of the word.
+func (rb *RuneBuffer) LowerCaseWord() bool {
+	return rb.caseWord(func(i int, r rune) rune {
+		return unicode.ToLower(r)
+	})
+}
+
+// CapitalizeWord converts the first rune from the cursor in the current or next word to upper case and the rest to
+// lower case, and moves the cursor to the end of the word.
+func (rb *RuneBuffer) CapitalizeWord() bool {
+	return rb.caseWord(func(i int, r rune) rune {
+		if i == 0 {
+			return unicode.ToUpper(r)
+		}
+		return unicode.ToLower(r)
+	})
+}
+
+// caseWord converts the runes from the cursor to the end of the current or next word by f.
+// i is the index of the rune from the first converted one.
+func (rb *RuneBuffer) caseWord(f func(i int, r rune) rune) (success bool) {
 	rb.Refresh(func() {
-		if rb.idx == len(rb.buf) {
+		if rb.readOnly {
 			return
 		}
-		init := rb.idx
-		for init < len(rb.buf) && IsWordBreak(rb.buf[init]) {
-			init++
+		start := rb.idx
+		for start < len(rb.buf) && rb.isWordBreak(rb.buf[start]) {
+			start++
 		}
-		for i := init + 1; i < len(rb.buf); i++ {
-			if !IsWordBreak(rb.buf[i]) && IsWordBreak(rb.buf[i-1]) {
-				rb.pushKill(rb.buf[rb.idx : i-1])
-				rb.buf = append(rb.buf[:rb.idx], rb.buf[i-1:]...)
-				success = true
-				return
-			}
+		if start >= len(rb.buf) {
+			return
 		}
-		rb.pushKill(rb.buf[rb.idx:])
-		rb.buf = rb.buf[:rb.idx]
+		rb.pushUndo()
+		i := start
+		for ; i < len(rb.buf) && !rb.isWordBreak(rb.buf[i]); i++ {
+			rb.buf[i] = f(i-start, rb.buf[i])
+		}
+		rb.idx = i
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) KillWordFront() (success bool) {
+// CaseMode is the case conversion of ApplyCase.
+type CaseMode int
+
+const (
+	// CaseLower converts the runes to lower case.
+	CaseLower CaseMode = iota
+	// CaseUpper converts the runes to upper case.
+	CaseUpper
+	// CaseTitle converts the first rune of each word to title case and the rest to lower case.
+	CaseTitle
+	// CaseToggle converts the upper case runes to lower case and the others to upper case.
+	CaseToggle
+)
+
+// ApplyCase converts the runes from start to end by mode. The conversion is rune by rune, so the length of the buffer
+// doesn't change, and a rune without a single-rune mapping like ß is kept. It fails if the range is out of the buffer.
+func (rb *RuneBuffer) ApplyCase(start, end int, mode CaseMode) (success bool) {
 	rb.Refresh(func() {
-		if rb.idx == 0 {
+		if rb.readOnly {
 			return
 		}
-		for i := rb.idx - 1; i > 0; i-- {
-			if !IsWordBreak(rb.buf[i]) && IsWordBreak(rb.buf[i-1]) {
-				rb.pushKill(rb.buf[i:rb.idx])
-				rb.buf = append(rb.buf[:i], rb.buf[rb.idx:]...)
-				rb.idx = i
-				success = true
-				return
+		if start < 0 || end > len(rb.buf) || start > end {
+			return
+		}
+		rb.pushUndo()
+		for i := start; i < end; i++ {
+			r := rb.buf[i]
+			switch mode {
+			case CaseLower:
+				r = unicode.ToLower(r)
+			case CaseUpper:
+				r = unicode.ToUpper(r)
+			case CaseTitle:
+				if i == 0 || rb.isTitleBreak(rb.buf[i-1]) {
+					r = unicode.ToTitle(r)
+				} else {
+					r = unicode.ToLower(r)
+				}
+			case CaseToggle:
+				if unicode.IsUpper(r) {
+					r = unicode.ToLower(r)
+				} else {
+					r = unicode.ToUpper(r)
+				}
 			}
+			rb.buf[i] = r
 		}
-
-		rb.buf = rb.buf[:0]
-		rb.idx = 0
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) Kill() (success bool) {
+// isTitleBreak reports whether a word starts after r in CaseTitle. The letters and the digits don't break the words
+// in it even if they aren't ASCII, like ö in "wörld".
+func (rb *RuneBuffer) isTitleBreak(r rune) bool {
+	return rb.isWordBreak(r) && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Undo reverts the last modification of the buffer.
+func (rb *RuneBuffer) Undo() (success bool) {
 	rb.Refresh(func() {
-		if rb.idx == len(rb.buf) {
+		if rb.readOnly {
 			return
 		}
-		rb.pushKill(rb.buf[rb.idx:])
-		rb.buf = rb.buf[:rb.idx]
+		if len(rb.undoStack) == 0 {
+			return
+		}
+		rb.redoStack = append(rb.redoStack, rb.snapshot())
+		rb.restoreSnapshot(&rb.undoStack, len(rb.undoStack)-1)
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) KillFront() (success bool) {
+// Redo reapplies the last modification reverted by Undo.
+func (rb *RuneBuffer) Redo() (success bool) {
 	rb.Refresh(func() {
-		if rb.idx == 0 {
+		if rb.readOnly {
 			return
 		}
-		length := len(rb.buf) - rb.idx
-		rb.pushKill(rb.buf[:rb.idx])
-		copy(rb.buf[:length], rb.buf[rb.idx:])
-		rb.idx = 0
-		rb.buf = rb.buf[:length]
+		if len(rb.redoStack) == 0 {
+			return
+		}
+		rb.undoStack = append(rb.undoStack, rb.snapshot())
+		rb.restoreSnapshot(&rb.redoStack, len(rb.redoStack)-1)
 		success = true
 	})
 	return
 }
 
-func (rb *RuneBuffer) Yank() (success bool) {
-	if len(rb.lastKill) == 0 {
-		return
+func (rb *RuneBuffer) snapshot() runeBufferBackup {
+	return runeBufferBackup{Copy(rb.buf), rb.idx}
+}
+
+// restoreSnapshot restores the snapshot at i in stack, and removes it from stack.
+func (rb *RuneBuffer) restoreSnapshot(stack *[]runeBufferBackup, i int) {
+	b := (*stack)[i]
+	*stack = (*stack)[:i]
+	rb.buf = b.buf
+	rb.idx = b.idx
+}
+
+// pushUndo saves the current state to the undo stack before a modification, and discards the redo stack.
+func (rb *RuneBuffer) pushUndo() {
+	rb.redoStack = rb.redoStack[:0]
+	rb.undoStack = append(rb.undoStack, rb.snapshot())
+	if len(rb.undoStack) > rb.undoDepth {
+		rb.undoStack = append(rb.undoStack[:0], rb.undoStack[len(rb.undoStack)-rb.undoDepth:]...)
 	}
-	rb.Refresh(func() {
-		buf := make([]rune, 0, len(rb.buf)+len(rb.lastKill))
-		buf = append(buf, rb.buf[:rb.idx]...)
-		buf = append(buf, rb.lastKill...)
-		buf = append(buf, rb.buf[rb.idx:]...)
-		rb.buf = buf
-		rb.idx += len(rb.lastKill)
-		success = true
-	})
-	return
 }
 
-func (rb *RuneBuffer) pushKill(s []rune) {
-	rb.lastKill = Copy(s)
+// pushUndoInsert is pushUndo for inserting n runes. Consecutive single rune insertions are coalesced into one undo step.
+func (rb *RuneBuffer) pushUndoInsert(n int) {
+	if n <= 0 {
+		return
+	}
+	if n > 1 {
+		rb.pushUndo()
+		return
+	}
+	if rb.lastOp != editInsert {
+		rb.pushUndo()
+	}
+	rb.op = editInsert
 }
 
 func (rb *RuneBuffer) Clear() {
+	_ = rb.ClearScreen()
+}
+
+// ClearScreen is Clear, and it returns the first error which occurs while writing.
+func (rb *RuneBuffer) ClearScreen() error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.writeErr = nil
 	rb.write([]byte("\033[H"))
-	rb.Refresh(nil)
+	rb.refresh(nil)
+	return rb.writeErr
 }
 
+// ClearAndForget is Clear, and it discards the backup stack.
+func (rb *RuneBuffer) ClearAndForget() {
+	rb.mu.Lock()
+	rb.backupStack = nil
+	rb.mu.Unlock()
+	rb.Clear()
+}
+
+// SetStyled writes the runes from start to end in s over the displayed buffer, like SetStyle.
+func (rb *RuneBuffer) SetStyled(start, end int, s Style) {
+	rb.SetStyle(start, end, s.SGR())
+}
+
+// SetStyle writes the runes from start to end in the SGR parameter style over the displayed buffer. The style isn't
+// kept after the next refresh.
 func (rb *RuneBuffer) SetStyle(start, end int, style string) {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
@@ -751,21 +2530,59 @@ func (rb *RuneBuffer) SetStyle(start, end int, style string) {
 	if end < start {
 		panic("end < start")
 	}
+	if rb.dumb || rb.noColor || !rb.interactive {
+		return
+	}
 
+	buf := getOutputBuffer()
+	row, _ := rb.position(rb.idx)
+	rb.writeStyle(buf, row, start, end, style, rb.idx)
+	rb.write(buf.Bytes())
+	putOutputBuffer(buf)
+	rb.flush()
+}
+
+// writeStyle writes the runes from start to end in style over the displayed buffer. The cursor moves from the row
+// fromRow to start, and back to the position of idx after writing.
+func (rb *RuneBuffer) writeStyle(buf *bytes.Buffer, fromRow, start, end int, style string, idx int) {
 	// goto start
-	move := start - rb.idx
-	if move > 0 {
-		rb.write([]byte(string(rb.buf[rb.idx : rb.idx+move])))
-	} else {
-		rb.write(bytes.Repeat([]byte("\b"), rb.widthAt(-1, move)))
+	rb.writeMoveSequence(buf, fromRow, start)
+	buf.WriteString("\033[" + style + "m")
+	row, col := rb.position(start)
+	for i := start; i < end; i++ {
+		c := rb.buf[i]
+		var w int
+		row, col, w = rb.advance(row, col, c)
+		switch {
+		case c == '\n' && rb.multiLine:
+			rb.writeNewline(buf, i)
+		case c == '\t':
+			writeRepeat(buf, " ", w)
+		default:
+			buf.WriteRune(c)
+		}
 	}
-	rb.write([]byte("\033[" + style + "m"))
-	rb.write([]byte(string(rb.buf[start:end])))
-	rb.write([]byte("\033[0m"))
-	// TODO: move back
+	buf.WriteString("\033[0m")
+	// move back, the cursor stays on the previous row if the styled text ends at the screen edge
+	row, _ = rb.position(end)
+	if rb.isEdgeAt(end) {
+		row--
+	}
+	rb.writeMoveSequence(buf, row, idx)
 }
 
 type runeBufferBackup struct {
 	buf []rune
 	idx int
 }
+
+// editOp is the kind of an operation on RuneBuffer.
+type editOp int
+
+const (
+	editOther editOp = iota
+	editInsert
+	editYank
+	editKill
+	editBackspace
+)
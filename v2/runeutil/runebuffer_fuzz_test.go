@@ -0,0 +1,37 @@
+//go:build go1.18
+// +build go1.18
+
+package runeutil
+
+import (
+	"testing"
+)
+
+func FuzzRuneBufferMove(f *testing.F) {
+	f.Add("ab cd", 4, 'c')
+	f.Add("cat a.txt|grep x", 0, '|')
+	f.Add(" ", 1, ' ')
+	f.Add("", 0, 'a')
+	f.Fuzz(func(t *testing.T, s string, idx int, ch rune) {
+		buf := []rune(s)
+		if idx < 0 || idx > len(buf) {
+			idx = len(buf)
+		}
+		moves := []func(rb *RuneBuffer){
+			func(rb *RuneBuffer) { rb.MoveToPrevWord() },
+			func(rb *RuneBuffer) { rb.MoveToNextWord() },
+			func(rb *RuneBuffer) { rb.MoveToEndWord() },
+			func(rb *RuneBuffer) { rb.MoveTo(ch, false, false) },
+			func(rb *RuneBuffer) { rb.MoveTo(ch, true, false) },
+			func(rb *RuneBuffer) { rb.MoveTo(ch, false, true) },
+			func(rb *RuneBuffer) { rb.MoveTo(ch, true, true) },
+		}
+		for i, move := range moves {
+			rb := newTestRuneBuffer(t, s, idx)
+			move(rb)
+			if n := rb.Index(); n < 0 || n > len(buf) {
+				t.Fatalf("move %d from %d in %q: index %d out of range", i, idx, s, n)
+			}
+		}
+	})
+}
@@ -0,0 +1,1646 @@
+package runeutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode"
+)
+
+func newTestRuneBuffer(tb testing.TB, s string, idx int) *RuneBuffer {
+	tb.Helper()
+	rb, err := NewRuneBuffer(io.Discard, "> ", 0, false, 80)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	rb.SetBuf(idx, []rune(s))
+	return rb
+}
+
+func assertRuneBuffer(tb testing.TB, rb *RuneBuffer, s string, idx int) {
+	tb.Helper()
+	if got := rb.String(); got != s {
+		tb.Fatalf("buffer %q, expected %q", got, s)
+	}
+	if got := rb.Index(); got != idx {
+		tb.Fatalf("index %d, expected %d", got, idx)
+	}
+}
+
+func TestRuneBufferYankPop(t *testing.T) {
+	rb := newTestRuneBuffer(t, "one two three", 8)
+	if rb.Yank() || rb.YankPop() {
+		t.Fatal("yank succeeded with empty kill ring")
+	}
+	rb.Kill()
+	rb.SetBuf(4, rb.Runes())
+	rb.Kill()
+	rb.SetBuf(0, rb.Runes())
+	rb.Kill()
+	assertRuneBuffer(t, rb, "", 0)
+
+	if rb.YankPop() {
+		t.Fatal("yank pop succeeded without yank")
+	}
+	if !rb.Yank() {
+		t.Fatal("yank failed")
+	}
+	assertRuneBuffer(t, rb, "one ", 4)
+	for _, expected := range []string{"two ", "three", "one "} {
+		if !rb.YankPop() {
+			t.Fatal("yank pop failed")
+		}
+		assertRuneBuffer(t, rb, expected, len(expected))
+	}
+
+	rb.MoveBackward()
+	if rb.YankPop() {
+		t.Fatal("yank pop succeeded after a non-yank operation")
+	}
+}
+
+func TestRuneBufferKillAppend(t *testing.T) {
+	// the forward kills are appended, and the backward kills are prepended
+	rb := newTestRuneBuffer(t, "one two three four", 8)
+	rb.KillWord()
+	rb.KillWord()
+	rb.KillWordFront()
+	rb.Kill()
+	assertRuneBuffer(t, rb, "one ", 4)
+	if rb.Kill() {
+		t.Fatal("kill succeeded at the end")
+	}
+	rb.KillFront()
+	assertRuneBuffer(t, rb, "", 0)
+	rb.Yank()
+	assertRuneBuffer(t, rb, "one two three four", 18)
+
+	// the yank between the kills makes them separate entries
+	rb = newTestRuneBuffer(t, "abc def", 3)
+	rb.Kill()
+	rb.Yank()
+	rb.MoveToLineStart()
+	rb.MoveForward()
+	rb.Kill()
+	rb.Kill()
+	assertRuneBuffer(t, rb, "a", 1)
+	rb.Yank()
+	assertRuneBuffer(t, rb, "abc def", 7)
+	rb.YankPop()
+	assertRuneBuffer(t, rb, "a def", 5)
+
+	// the kills after a non-kill operation start a new entry
+	rb = newTestRuneBuffer(t, "abc", 1)
+	rb.Kill()
+	rb.WriteRune('x')
+	rb.KillFront()
+	rb.Yank()
+	assertRuneBuffer(t, rb, "ax", 2)
+	rb.YankPop()
+	assertRuneBuffer(t, rb, "bc", 2)
+}
+
+func TestRuneBufferKillRingSize(t *testing.T) {
+	rb := newTestRuneBuffer(t, "abcd", 0)
+	rb.SetKillRingSize(2)
+	for i := 0; i < 4; i++ {
+		rb.Delete()
+	}
+	rb.Yank()
+	assertRuneBuffer(t, rb, "d", 1)
+	rb.YankPop()
+	assertRuneBuffer(t, rb, "c", 1)
+	rb.YankPop()
+	assertRuneBuffer(t, rb, "d", 1)
+}
+
+func TestRuneBufferUndo(t *testing.T) {
+	rb := newTestRuneBuffer(t, "", 0)
+	if rb.Undo() || rb.Redo() {
+		t.Fatal("undo or redo succeeded without modification")
+	}
+
+	for _, r := range "hello" {
+		rb.WriteRune(r)
+	}
+	rb.WriteString(" world")
+	rb.MoveBackward()
+	rb.WriteRune('!')
+	rb.WriteRune('?')
+	assertRuneBuffer(t, rb, "hello worl!?d", 12)
+
+	rb.Undo()
+	assertRuneBuffer(t, rb, "hello world", 10)
+	rb.Undo()
+	assertRuneBuffer(t, rb, "hello", 5)
+	rb.Redo()
+	assertRuneBuffer(t, rb, "hello world", 10)
+
+	rb.MoveToLineStart()
+	rb.Kill()
+	assertRuneBuffer(t, rb, "", 0)
+	rb.Yank()
+	rb.Yank()
+	assertRuneBuffer(t, rb, "hello worldhello world", 22)
+	rb.Undo()
+	rb.Undo()
+	assertRuneBuffer(t, rb, "", 0)
+	rb.Undo()
+	assertRuneBuffer(t, rb, "hello world", 0)
+	if rb.Redo(); rb.String() != "" {
+		t.Fatalf("buffer %q after redo, expected empty", rb.String())
+	}
+
+	rb.WriteRune('x')
+	if rb.Redo() {
+		t.Fatal("redo succeeded after a new modification")
+	}
+	rb.Undo()
+	rb.Undo()
+	rb.Undo()
+	rb.Undo()
+	if rb.Undo() {
+		t.Fatal("undo succeeded past the first modification")
+	}
+	assertRuneBuffer(t, rb, "", 0)
+}
+
+func TestRuneBufferUndoCoalesce(t *testing.T) {
+	rb := newTestRuneBuffer(t, "", 0)
+	for _, r := range "0123456789" {
+		rb.WriteRune(r)
+	}
+	rb.Undo()
+	assertRuneBuffer(t, rb, "", 0)
+	rb.Redo()
+	assertRuneBuffer(t, rb, "0123456789", 10)
+
+	for i := 0; i < 3; i++ {
+		rb.Backspace()
+	}
+	assertRuneBuffer(t, rb, "0123456", 7)
+	rb.Undo()
+	assertRuneBuffer(t, rb, "0123456789", 10)
+
+	// the word operations aren't coalesced
+	rb.Backspace()
+	rb.KillWordFront()
+	assertRuneBuffer(t, rb, "", 0)
+	rb.Undo()
+	assertRuneBuffer(t, rb, "012345678", 9)
+	rb.Undo()
+	assertRuneBuffer(t, rb, "0123456789", 10)
+}
+
+func TestRuneBufferUndoDepth(t *testing.T) {
+	rb := newTestRuneBuffer(t, "abcde", 5)
+	rb.SetUndoDepth(2)
+	for i := 0; i < 5; i++ {
+		rb.Backspace()
+		// the cursor movement separates the undo steps of the backspaces
+		rb.MoveToLineEnd()
+	}
+	rb.Undo()
+	rb.Undo()
+	if rb.Undo() {
+		t.Fatal("undo succeeded past the undo depth")
+	}
+	assertRuneBuffer(t, rb, "ab", 2)
+}
+
+func TestRuneBufferApplyCase(t *testing.T) {
+	const s = "hELLo wÖRLD straße"
+	tests := []struct {
+		mode     CaseMode
+		expected string
+	}{
+		{CaseLower, "hello wörld straße"},
+		{CaseUpper, "HELLO WÖRLD STRAßE"},
+		{CaseTitle, "Hello Wörld Straße"},
+		{CaseToggle, "HellO Wörld STRAßE"},
+	}
+	for _, test := range tests {
+		rb := newTestRuneBuffer(t, s, 2)
+		if !rb.ApplyCase(0, len([]rune(s)), test.mode) {
+			t.Fatalf("mode %d: apply case failed", test.mode)
+		}
+		assertRuneBuffer(t, rb, test.expected, 2)
+		rb.Undo()
+		assertRuneBuffer(t, rb, s, 2)
+	}
+
+	rb := newTestRuneBuffer(t, "abc", 0)
+	if !rb.ApplyCase(1, 2, CaseUpper) {
+		t.Fatal("apply case failed")
+	}
+	assertRuneBuffer(t, rb, "aBc", 0)
+	for _, r := range [][2]int{{-1, 1}, {0, 4}, {2, 1}} {
+		if rb.ApplyCase(r[0], r[1], CaseUpper) {
+			t.Errorf("apply case succeeded from %d to %d", r[0], r[1])
+		}
+	}
+	assertRuneBuffer(t, rb, "aBc", 0)
+}
+
+func TestRuneBufferCaseWord(t *testing.T) {
+	tests := []struct {
+		op       func(rb *RuneBuffer) bool
+		s        string
+		idx      int
+		expected string
+		newIdx   int
+		success  bool
+	}{
+		{(*RuneBuffer).UpperCaseWord, "hello world", 0, "HELLO world", 5, true},
+		{(*RuneBuffer).UpperCaseWord, "hello world", 2, "heLLO world", 5, true},
+		{(*RuneBuffer).UpperCaseWord, "hello world", 5, "hello WORLD", 11, true},
+		{(*RuneBuffer).UpperCaseWord, "hello world", 11, "hello world", 11, false},
+		{(*RuneBuffer).UpperCaseWord, "你好 abc", 0, "你好 ABC", 6, true},
+		{(*RuneBuffer).LowerCaseWord, "HeLLo WORLD", 0, "hello WORLD", 5, true},
+		{(*RuneBuffer).LowerCaseWord, "  MiXed", 0, "  mixed", 7, true},
+		{(*RuneBuffer).LowerCaseWord, "你好", 0, "你好", 0, false},
+		{(*RuneBuffer).CapitalizeWord, "hELLO world", 0, "Hello world", 5, true},
+		{(*RuneBuffer).CapitalizeWord, "hELLO world", 1, "hEllo world", 5, true},
+		{(*RuneBuffer).CapitalizeWord, "你好, wORLD", 0, "你好, World", 9, true},
+	}
+	for _, test := range tests {
+		rb := newTestRuneBuffer(t, test.s, test.idx)
+		if success := test.op(rb); success != test.success {
+			t.Errorf("%q at %d: success %v, expected %v", test.s, test.idx, success, test.success)
+		}
+		if rb.String() != test.expected || rb.Index() != test.newIdx {
+			t.Errorf("%q at %d: got %q at %d, expected %q at %d", test.s, test.idx, rb.String(), rb.Index(), test.expected, test.newIdx)
+		}
+	}
+}
+
+func TestRuneBufferWordBreakChars(t *testing.T) {
+	rb := newTestRuneBuffer(t, "cat a.txt|grep x", 0)
+	var idxs []int
+	for rb.MoveToNextWord() && rb.Index() < rb.Len() {
+		idxs = append(idxs, rb.Index())
+	}
+	if !reflect.DeepEqual(idxs, []int{4, 6, 10, 15}) {
+		t.Fatalf("default word starts %v", idxs)
+	}
+
+	rb = newTestRuneBuffer(t, "cat a.txt|grep x", 0)
+	rb.SetWordBreakChars(" |")
+	idxs = nil
+	for rb.MoveToNextWord() && rb.Index() < rb.Len() {
+		idxs = append(idxs, rb.Index())
+	}
+	if !reflect.DeepEqual(idxs, []int{4, 10, 15}) {
+		t.Fatalf("custom word starts %v", idxs)
+	}
+
+	rb.SetBuf(9, rb.Runes())
+	rb.KillWordFront()
+	assertRuneBuffer(t, rb, "cat |grep x", 4)
+	rb.KillWord()
+	assertRuneBuffer(t, rb, "cat  x", 4)
+}
+
+func TestRuneBufferMenu(t *testing.T) {
+	rb := newTestRuneBuffer(t, "abc", 1)
+	rb.SetMenu([]string{"one", "two"})
+	expected := "> abc\none\ntwo\033[2A\r\033[5C\b\b"
+	var output bytes.Buffer
+	rb.outputPrint(&output)
+	if output := output.String(); output != expected {
+		t.Fatalf("output %q, expected %q", output, expected)
+	}
+}
+
+func TestRuneBufferPromptWidth(t *testing.T) {
+	rb := newTestRuneBuffer(t, "abc", 3)
+
+	tests := []struct {
+		prompt string
+		width  int
+	}{
+		{"> ", 2},
+		{"\033[31mred\033[0m> ", 5},
+		{"\033[1;34m世界\033[0m$ ", 6},
+		{"", 0},
+	}
+	for _, test := range tests {
+		rb.SetPrompt(test.prompt)
+		if width := rb.PromptWidth(); width != test.width {
+			t.Errorf("prompt %q: width %d, expected %d", test.prompt, width, test.width)
+		}
+	}
+}
+
+func TestRuneBufferWriteAbove(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "> ", 0, true, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.WriteString("abc")
+	buf.Reset()
+
+	if _, err := rb.WriteAbove([]byte("message\n")); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); !strings.HasSuffix(s, "message\n> abc") {
+		t.Fatalf("output %q, expected the message followed by the prompt and buffer", s)
+	}
+	assertRuneBuffer(t, rb, "abc", 3)
+}
+
+func TestRuneBufferNoEcho(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "> ", 0, true, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.SetNoEcho(true)
+	rb.WriteString("secret")
+	rb.WriteRune('\n')
+	if s := buf.String(); strings.ContainsAny(s, "secrt") {
+		t.Fatalf("output %q contains the buffer", s)
+	}
+	if s := buf.String(); !strings.HasSuffix(s, "> \n") {
+		t.Fatalf("output %q doesn't end with the prompt and newline", s)
+	}
+	assertRuneBuffer(t, rb, "secret\n", 7)
+}
+
+func TestRuneBufferExchangeMark(t *testing.T) {
+	rb := newTestRuneBuffer(t, "hello", 2)
+	if rb.ExchangeMark() {
+		t.Fatal("ExchangeMark succeeded without the mark")
+	}
+	rb.SetMark()
+	rb.MoveToLineEnd()
+	rb.WriteString(" world")
+	if !rb.ExchangeMark() {
+		t.Fatal("ExchangeMark failed")
+	}
+	assertRuneBuffer(t, rb, "hello world", 2)
+	if mark, ok := rb.Mark(); !ok || mark != 11 {
+		t.Fatalf("mark %d %v, expected 11 true", mark, ok)
+	}
+	if !rb.ExchangeMark() {
+		t.Fatal("ExchangeMark failed")
+	}
+	assertRuneBuffer(t, rb, "hello world", 11)
+	if mark, ok := rb.Mark(); !ok || mark != 2 {
+		t.Fatalf("mark %d %v, expected 2 true", mark, ok)
+	}
+
+	// the mark beyond the buffer
+	rb.SetMark()
+	rb.Set(0, []rune("ab"))
+	if !rb.ExchangeMark() {
+		t.Fatal("ExchangeMark failed")
+	}
+	assertRuneBuffer(t, rb, "ab", 2)
+	if mark, ok := rb.Mark(); !ok || mark != 0 {
+		t.Fatalf("mark %d %v, expected 0 true", mark, ok)
+	}
+}
+
+func TestRuneBufferSetContents(t *testing.T) {
+	rb := newTestRuneBuffer(t, "", 0)
+	rb.WriteString("hello")
+	rb.MoveBackward()
+	rb.Kill()
+	rb.SetContents("hello world")
+	assertRuneBuffer(t, rb, "hello world", 11)
+	rb.MoveToLineStart()
+	rb.Yank()
+	assertRuneBuffer(t, rb, "ohello world", 1)
+	rb.Undo()
+	rb.Undo()
+	assertRuneBuffer(t, rb, "hell", 4)
+
+	rb.SetContents("hell")
+	rb.Undo()
+	assertRuneBuffer(t, rb, "hello", 4)
+}
+
+func TestRuneBufferAppendString(t *testing.T) {
+	rb := newTestRuneBuffer(t, "", 0)
+	rb.WriteString("foo bar")
+	rb.MoveToLineStart()
+	rb.MoveToNextWord()
+	rb.AppendString(" baz")
+	assertRuneBuffer(t, rb, "foo bar baz", 4)
+	rb.AppendString("")
+	rb.WriteString("!")
+	assertRuneBuffer(t, rb, "foo !bar baz", 5)
+	rb.Undo()
+	rb.Undo()
+	assertRuneBuffer(t, rb, "foo bar", 4)
+}
+
+func TestRuneBufferReadOnly(t *testing.T) {
+	rb := newTestRuneBuffer(t, "hello world", 11)
+	rb.Backspace()
+	rb.MoveToLineStart()
+	rb.Kill()
+	rb.Set(5, []rune("hello world"))
+	rb.SetMark()
+	rb.SetReadOnly(true)
+	if !rb.IsReadOnly() {
+		t.Fatal("the buffer isn't read-only")
+	}
+	expected := string(rb.Bytes())
+
+	for name, op := range map[string]func() bool{
+		"Backspace":         rb.Backspace,
+		"Delete":            rb.Delete,
+		"Erase":             rb.Erase,
+		"DeleteCurrentWord": rb.DeleteCurrentWord,
+		"Kill":              rb.Kill,
+		"KillFront":         rb.KillFront,
+		"KillWord":          rb.KillWord,
+		"KillWordFront":     rb.KillWordFront,
+		"KillRegion":        rb.KillRegion,
+		"Yank":              rb.Yank,
+		"YankPop":           rb.YankPop,
+		"Transpose":         rb.Transpose,
+		"TransposeWords":    rb.TransposeWords,
+		"UpperCaseWord":     rb.UpperCaseWord,
+		"CapitalizeWord":    rb.CapitalizeWord,
+		"Undo":              rb.Undo,
+		"Redo":              rb.Redo,
+		"AcceptHint":        rb.AcceptHint,
+		"ApplyCase": func() bool {
+			return rb.ApplyCase(0, 5, CaseUpper)
+		},
+	} {
+		if op() {
+			t.Errorf("%s succeeded in the read-only mode", name)
+		}
+		assertRuneBuffer(t, rb, expected, 5)
+	}
+	rb.WriteString("x")
+	rb.WriteRune('x')
+	rb.InsertRunes([]rune("x"))
+	rb.AppendString("x")
+	rb.SetContents("x")
+	assertRuneBuffer(t, rb, expected, 5)
+
+	if !rb.MoveForward() || !rb.MoveToLineEnd() {
+		t.Fatal("the cursor doesn't move in the read-only mode")
+	}
+	rb.Set(3, []rune("--More--"))
+	assertRuneBuffer(t, rb, "--More--", 3)
+
+	rb.SetReadOnly(false)
+	rb.WriteString("x")
+	assertRuneBuffer(t, rb, "--Mxore--", 4)
+}
+
+func TestRuneBufferRegion(t *testing.T) {
+	rb := newTestRuneBuffer(t, "hello big world", 6)
+	if rb.KillRegion() {
+		t.Fatal("KillRegion succeeded without the mark")
+	}
+	rb.SetMark()
+	rb.MoveToNextWord()
+	if !rb.KillRegion() {
+		t.Fatal("KillRegion failed")
+	}
+	assertRuneBuffer(t, rb, "hello world", 6)
+	if rb.KillRegion() {
+		t.Fatal("KillRegion succeeded after the mark is cleared")
+	}
+
+	// the mark after the cursor
+	rb.SetMark()
+	rb.MoveToLineStart()
+	if !rb.KillRegion() {
+		t.Fatal("KillRegion failed")
+	}
+	assertRuneBuffer(t, rb, "world", 0)
+
+	rb.MoveToLineEnd()
+	rb.Yank()
+	assertRuneBuffer(t, rb, "worldhello ", 11)
+	rb.YankPop()
+	assertRuneBuffer(t, rb, "worldbig ", 9)
+
+	rb.MoveToLineStart()
+	rb.SetMark()
+	rb.MoveForward()
+	rb.MoveForward()
+	if !rb.CopyRegion() {
+		t.Fatal("CopyRegion failed")
+	}
+	assertRuneBuffer(t, rb, "worldbig ", 2)
+	if rb.CopyRegion() {
+		t.Fatal("CopyRegion succeeded after the mark is cleared")
+	}
+	rb.MoveToLineEnd()
+	rb.Yank()
+	assertRuneBuffer(t, rb, "worldbig wo", 11)
+}
+
+func TestRuneBufferRecover(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "> ", 0, true, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.WriteString("abc")
+	rb.MoveBackward()
+	buf.Reset()
+	rb.hadClean = false
+	if err := rb.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); s != "> abc\b" {
+		t.Fatalf("output %q, expected the prompt and the buffer only", s)
+	}
+	assertRuneBuffer(t, rb, "abc", 2)
+}
+
+func TestRuneBufferFlush(t *testing.T) {
+	w := &failWriter{}
+	rb, err := NewRuneBuffer(w, "> ", 0, true, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.WriteString("abc")
+	rb.MoveBackward()
+	w.calls = 0
+	rb.Refresh(nil)
+	if w.calls != 1 {
+		t.Fatalf("%d writes by a refresh, expected 1", w.calls)
+	}
+
+	w.fail = true
+	if err := rb.Redraw(); err == nil {
+		t.Fatal("Redraw succeeded with the failing writer")
+	}
+	w.fail = false
+	w.buf.Reset()
+	if err := rb.Redraw(); err != nil {
+		t.Fatal(err)
+	}
+	if s := w.buf.String(); !strings.HasSuffix(s, "> abc\b") {
+		t.Fatalf("output %q after the failed write, expected the buffer", s)
+	}
+	if err := rb.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failWriter counts the write calls, and fails them while fail is true.
+type failWriter struct {
+	buf   bytes.Buffer
+	calls int
+	fail  bool
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	w.calls++
+	if w.fail {
+		return 0, errors.New("write failed")
+	}
+	return w.buf.Write(p)
+}
+
+func TestRuneBufferRegionStyle(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "> ", 0, true, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.WriteString("abcd")
+	rb.MoveBackward()
+	rb.SetMark()
+	rb.MoveBackward()
+	rb.MoveBackward()
+	if s := buf.String(); !strings.HasSuffix(s, "> a\033["+RegionStyle+"mbc\033[0md\b\b\b") {
+		t.Fatalf("output %q doesn't highlight the region", s)
+	}
+}
+
+func TestRuneBufferKillWordFront(t *testing.T) {
+	tests := []struct {
+		s        string
+		idx      int
+		ok       bool
+		expected string
+		newIdx   int
+		killed   string
+	}{
+		{"", 0, false, "", 0, ""},
+		{"foo", 0, false, "foo", 0, ""},
+		{"   ", 3, true, "", 0, "   "},
+		{"   x", 2, true, " x", 0, "  "},
+		{" a", 2, true, " ", 1, "a"},
+		{"a", 1, true, "", 0, "a"},
+		{"foo", 2, true, "o", 0, "fo"},
+		{"foo bar baz", 11, true, "foo bar ", 8, "baz"},
+		{"foo bar baz", 9, true, "foo bar az", 8, "b"},
+		{"foo bar  baz", 9, true, "foo baz", 4, "bar  "},
+		{"foo bar baz", 4, true, "bar baz", 0, "foo "},
+	}
+	for _, test := range tests {
+		rb := newTestRuneBuffer(t, test.s, test.idx)
+		if ok := rb.KillWordFront(); ok != test.ok {
+			t.Errorf("%q at %d: KillWordFront returned %v, expected %v", test.s, test.idx, ok, test.ok)
+		}
+		if s, idx := rb.String(), rb.Index(); s != test.expected || idx != test.newIdx {
+			t.Errorf("%q at %d: buffer %q at %d, expected %q at %d", test.s, test.idx, s, idx, test.expected, test.newIdx)
+		}
+		if !test.ok {
+			continue
+		}
+		rb.SetBuf(0, nil)
+		rb.Yank()
+		if killed := rb.String(); killed != test.killed {
+			t.Errorf("%q at %d: killed %q, expected %q", test.s, test.idx, killed, test.killed)
+		}
+	}
+}
+
+func TestRuneBufferTransposeWords(t *testing.T) {
+	tests := []struct {
+		s        string
+		idx      int
+		ok       bool
+		expected string
+		newIdx   int
+	}{
+		{"foo bar baz", 6, true, "bar foo baz", 7},
+		{"foo bar baz", 4, true, "bar foo baz", 7},
+		{"foo  bar", 4, true, "bar  foo", 8},
+		{"foo bar baz", 11, true, "foo baz bar", 11},
+		{"foo bar  ", 9, true, "bar foo  ", 7},
+		{"foo bar", 0, false, "foo bar", 0},
+		{"foo bar", 2, false, "foo bar", 2},
+		{"foo", 3, false, "foo", 3},
+		{"   ", 2, false, "   ", 2},
+		{"", 0, false, "", 0},
+	}
+	for _, test := range tests {
+		rb := newTestRuneBuffer(t, test.s, test.idx)
+		if ok := rb.TransposeWords(); ok != test.ok {
+			t.Errorf("%q at %d: TransposeWords returned %v, expected %v", test.s, test.idx, ok, test.ok)
+		}
+		if s, idx := rb.String(), rb.Index(); s != test.expected || idx != test.newIdx {
+			t.Errorf("%q at %d: buffer %q at %d, expected %q at %d", test.s, test.idx, s, idx, test.expected, test.newIdx)
+		}
+	}
+}
+
+func TestRuneBufferDumb(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "\033[32m>\033[0m ", 0, true, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.SetDumb(true)
+	rb.WriteString("hello world")
+	rb.MoveBackward()
+	rb.MoveBackward()
+	rb.SetMark()
+	rb.MoveToLineStart()
+	rb.SetMenu([]string{"one", "two"})
+	rb.SetStyle(0, 2, "7")
+
+	var output bytes.Buffer
+	rb.outputPrint(&output)
+	if p := output.Bytes(); !bytes.Equal(p, []byte("> hello world"+strings.Repeat("\b", 11))) {
+		t.Fatalf("output %q", p)
+	}
+	if s := buf.String(); strings.Contains(s, "\033") {
+		t.Fatalf("output %q contains an escape sequence", s)
+	}
+}
+
+func TestRuneBufferHint(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "> ", 0, true, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.SetHintProvider(func(line []rune, pos int) []rune {
+		if string(line) == "hel" {
+			return []rune("lo")
+		}
+		return nil
+	})
+	rb.WriteString("hel")
+	if s := buf.String(); !strings.HasSuffix(s, "> hel\033["+HintStyle+"mlo\033[0m\b\b") {
+		t.Fatalf("output %q doesn't end with the hint", s)
+	}
+	assertRuneBuffer(t, rb, "hel", 3)
+
+	rb.MoveBackward()
+	if rb.AcceptHint() {
+		t.Fatal("AcceptHint succeeded with the cursor before the end")
+	}
+	rb.MoveToLineEnd()
+	if !rb.AcceptHint() {
+		t.Fatal("AcceptHint failed")
+	}
+	assertRuneBuffer(t, rb, "hello", 5)
+	if rb.AcceptHint() {
+		t.Fatal("AcceptHint succeeded without a hint")
+	}
+}
+
+func TestRuneBufferMultiLine(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "> ", 0, true, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.SetMultiLine(true, ". ")
+	rb.WriteString("ab\ncd")
+	if s := buf.String(); !strings.HasSuffix(s, "> ab\n. cd") {
+		t.Fatalf("output %q doesn't end with the continuation prompt and line", s)
+	}
+	if !rb.MoveUp() {
+		t.Fatal("MoveUp failed")
+	}
+	assertRuneBuffer(t, rb, "ab\ncd", 2)
+	if s := buf.String(); !strings.HasSuffix(s, "> ab\n. cd\033[1A\r\033[4C") {
+		t.Fatalf("output %q doesn't end with the cursor movement", s)
+	}
+	if n := rb.IdxLine(); n != 0 {
+		t.Fatalf("index line %d, expected 0", n)
+	}
+	if rb.MoveUp() {
+		t.Fatal("MoveUp succeeded on the first line")
+	}
+	if !rb.MoveDown() {
+		t.Fatal("MoveDown failed")
+	}
+	assertRuneBuffer(t, rb, "ab\ncd", 5)
+	if rb.MoveDown() {
+		t.Fatal("MoveDown succeeded on the last line")
+	}
+
+	buf.Reset()
+	rb.Finish()
+	if s := buf.String(); !strings.HasSuffix(s, "> ab\n. cd\n") {
+		t.Fatalf("output %q doesn't end with the accepted buffer", s)
+	}
+}
+
+func TestRuneBufferMoveUpDown(t *testing.T) {
+	tests := []struct {
+		s        string
+		idx      int
+		up       bool
+		expected int
+	}{
+		{"abc\nx", 5, true, 1},
+		{"x\nabc", 5, true, 1},
+		{"x\nabc", 1, false, 3},
+		{"abc\n\nx", 2, false, 4},
+		{"ab\n世界", 5, true, 2},
+		{"世界\nab", 5, true, 1},
+		{"世界\nab", 4, true, 0},
+	}
+	for _, test := range tests {
+		rb := newTestRuneBuffer(t, test.s, test.idx)
+		var ok bool
+		if test.up {
+			ok = rb.MoveUp()
+		} else {
+			ok = rb.MoveDown()
+		}
+		if !ok || rb.Index() != test.expected {
+			t.Errorf("%q at %d, up %v: index %d %v, expected %d true", test.s, test.idx, test.up, rb.Index(), ok, test.expected)
+		}
+	}
+}
+
+func TestRuneBufferCurrentWord(t *testing.T) {
+	tests := []struct {
+		s          string
+		idx        int
+		word       string
+		start, end int
+	}{
+		{"", 0, "", 0, 0},
+		{"foo bar", 0, "foo", 0, 3},
+		{"foo bar", 1, "foo", 0, 3},
+		{"foo bar", 3, "foo", 0, 3},
+		{"foo bar", 4, "bar", 4, 7},
+		{"foo bar", 7, "bar", 4, 7},
+		{"foo   bar", 5, "foo", 0, 3},
+		{"  foo", 1, "", 1, 1},
+		{"  foo", 2, "foo", 2, 5},
+		{"foo  ", 5, "foo", 0, 3},
+		{"git commit -m", 6, "commit", 4, 10},
+		{"a.b/c", 2, "b", 2, 3},
+		{"ab, cd", 3, "ab", 0, 2},
+	}
+	for _, test := range tests {
+		rb := newTestRuneBuffer(t, test.s, test.idx)
+		word, start, end := rb.CurrentWord()
+		if string(word) != test.word || start != test.start || end != test.end {
+			t.Errorf("%q at %d: %q %d %d, expected %q %d %d", test.s, test.idx, string(word), start, end,
+				test.word, test.start, test.end)
+		}
+		word, start, end = rb.WordAt(test.idx)
+		if string(word) != test.word || start != test.start || end != test.end {
+			t.Errorf("%q word at %d: %q %d %d, expected %q %d %d", test.s, test.idx, string(word), start, end,
+				test.word, test.start, test.end)
+		}
+	}
+
+	rb := newTestRuneBuffer(t, "foo bar", 0)
+	if word, start, end := rb.WordAt(100); string(word) != "bar" || start != 4 || end != 7 {
+		t.Errorf("word at 100: %q %d %d, expected \"bar\" 4 7", string(word), start, end)
+	}
+}
+
+func TestRuneBufferWords(t *testing.T) {
+	tests := []struct {
+		s     string
+		words []string
+	}{
+		{"", []string{}},
+		{"   ", []string{}},
+		{"git", []string{"git"}},
+		{"  git commit  ", []string{"git", "commit"}},
+		{"a,, b;;c", []string{"a", "b", "c"}},
+		{"git commit -m 'fix it'", []string{"git", "commit", "m", "fix", "it"}},
+	}
+	for _, test := range tests {
+		rb := newTestRuneBuffer(t, test.s, 0)
+		if words := rb.Words(); !reflect.DeepEqual(words, test.words) {
+			t.Errorf("%q: words %q, expected %q", test.s, words, test.words)
+		}
+		if n := rb.WordCount(); n != len(test.words) {
+			t.Errorf("%q: word count %d, expected %d", test.s, n, len(test.words))
+		}
+	}
+}
+
+func TestRuneBufferTokenAt(t *testing.T) {
+	tests := []struct {
+		s        string
+		idx      int
+		token    string
+		tokenIdx int
+	}{
+		{"", 0, "", 0},
+		{"  git  commit  ", 0, "", 0},
+		{"  git  commit  ", 2, "git", 0},
+		{"  git  commit  ", 5, "git", 0},
+		{"  git  commit  ", 6, "", 1},
+		{"  git  commit  ", 7, "commit", 1},
+		{"  git  commit  ", 13, "commit", 1},
+		{"  git  commit  ", 15, "", 2},
+		{"a,,b", 2, "", 1},
+	}
+	for _, test := range tests {
+		rb := newTestRuneBuffer(t, test.s, 0)
+		if token, tokenIdx := rb.TokenAt(test.idx); token != test.token || tokenIdx != test.tokenIdx {
+			t.Errorf("%q at %d: %q %d, expected %q %d", test.s, test.idx, token, tokenIdx, test.token, test.tokenIdx)
+		}
+	}
+}
+
+func TestRuneBufferDeleteCurrentWord(t *testing.T) {
+	rb := newTestRuneBuffer(t, "git comm -m", 6)
+	if !rb.DeleteCurrentWord() {
+		t.Fatal("deleting the current word failed")
+	}
+	assertRuneBuffer(t, rb, "git  -m", 4)
+	if !rb.Yank() {
+		t.Fatal("yank failed")
+	}
+	assertRuneBuffer(t, rb, "git comm -m", 8)
+
+	rb = newTestRuneBuffer(t, "  foo", 1)
+	if rb.DeleteCurrentWord() {
+		t.Fatal("deleting the current word succeeded without a word")
+	}
+	assertRuneBuffer(t, rb, "  foo", 1)
+}
+
+func TestRuneBufferSetStyle(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, ">>>> ", 0, true, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.WriteString("a\t世界xyz")
+	rb.MoveBackward()
+	rb.MoveBackward()
+
+	// the tab is written to the tab stop at the column 8, and "世界" ends at the screen edge, so the cursor stays on
+	// the first row after writing it.
+	buf.Reset()
+	rb.SetStyle(1, 3, "1")
+	rb.SetStyle(2, 4, "4")
+	expected := "\033[1A\r\033[6C\033[1m  世\033[0m\033[1B\r\033[1C" +
+		"\033[1A\r\033[8C\033[4m世界\033[0m\033[1B\r\033[1C"
+	if s := buf.String(); s != expected {
+		t.Fatalf("output %q, expected %q", s, expected)
+	}
+	assertRuneBuffer(t, rb, "a\t世界xyz", 5)
+}
+
+func TestRuneBufferSetScreenHeight(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "> ", 0, true, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []int{0, -1} {
+		if err := rb.SetScreenHeight(h); !errors.Is(err, ErrInvalidScreenHeight) {
+			t.Errorf("screen height %d: error %v, expected %v", h, err, ErrInvalidScreenHeight)
+		}
+	}
+	if err := rb.SetScreenHeight(3); err != nil {
+		t.Fatal(err)
+	}
+	if h := rb.ScreenHeight(); h != 3 {
+		t.Fatalf("screen height %d, expected 3", h)
+	}
+
+	// the buffer takes 6 rows, but the cursor can move up at most 2 rows in the screen
+	rb.WriteString(strings.Repeat("a", 50))
+	buf.Reset()
+	rb.Clean()
+	if n := strings.Count(buf.String(), "\033[A"); n != 2 {
+		t.Errorf("clean-up %q moves up %d rows, expected 2", buf.String(), n)
+	}
+
+	// only the rows below the buffer are used by the menu
+	rb.SetBuf(0, nil)
+	rb.SetMenu([]string{"one", "two", "three", "four"})
+	buf.Reset()
+	rb.Refresh(nil)
+	if s := buf.String(); !strings.Contains(s, "\none\ntwo\033[2A") || strings.Contains(s, "three") {
+		t.Errorf("output %q, expected the menu to be cut to 2 lines", s)
+	}
+}
+
+func TestRuneBufferSetTabWidth(t *testing.T) {
+	render := func(tabWidth int) string {
+		var buf bytes.Buffer
+		rb, err := NewRuneBuffer(&buf, "> ", 0, true, 80)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rb.SetTabWidth(tabWidth); err != nil {
+			t.Fatal(err)
+		}
+		if w := rb.TabWidth(); w != tabWidth {
+			t.Fatalf("tab width %d, expected %d", w, tabWidth)
+		}
+		rb.WriteString("a\tb")
+		buf.Reset()
+		rb.Refresh(nil)
+		return buf.String()
+	}
+
+	// "a" ends at the column 3, so the tab is 1 column with the tab width 4, and 5 columns with the tab width 8.
+	s4, s8 := render(4), render(8)
+	if !strings.Contains(s4, "a b") {
+		t.Errorf("output %q with tab width 4, expected it to contain %q", s4, "a b")
+	}
+	if !strings.Contains(s8, "a     b") {
+		t.Errorf("output %q with tab width 8, expected it to contain %q", s8, "a     b")
+	}
+	if s4 == s8 {
+		t.Errorf("same output %q with tab widths 4 and 8", s4)
+	}
+
+	rb := newTestRuneBuffer(t, "a\tb", 3)
+	for _, w := range []int{0, -1} {
+		if err := rb.SetTabWidth(w); !errors.Is(err, ErrInvalidTabWidth) {
+			t.Errorf("tab width %d: error %v, expected %v", w, err, ErrInvalidTabWidth)
+		}
+	}
+	if w := rb.TabWidth(); w != TabWidth {
+		t.Errorf("tab width %d after invalid widths, expected %d", w, TabWidth)
+	}
+}
+
+func TestRuneBufferHighlighter(t *testing.T) {
+	out := &syncBuffer{}
+	rb, err := NewRuneBuffer(out, "> ", 0, true, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	rb.SetHighlighter(func(buf []rune) []StyleRange {
+		atomic.AddInt32(&calls, 1)
+		var ranges []StyleRange
+		for i, r := range buf {
+			if unicode.IsDigit(r) {
+				ranges = append(ranges, StyleRange{i, i + 1, "31"})
+			}
+		}
+		return ranges
+	})
+	rb.WriteString("a1b22")
+	expected := "> a1b22" +
+		"\r\033[3C\033[31m1\033[0m\r\033[7C" +
+		"\r\033[5C\033[31m2\033[0m\r\033[7C" +
+		"\r\033[6C\033[31m2\033[0m\r\033[7C"
+	waitFor(t, func() bool {
+		return strings.HasSuffix(out.String(), expected)
+	})
+
+	rb.MoveBackward()
+	rb.MoveToLineEnd()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("highlighter is called %d times, expected 1", n)
+	}
+	rb.Backspace()
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&calls) == 2
+	})
+}
+
+func TestRuneBufferHighlighterConcurrentRefresh(t *testing.T) {
+	out := &syncBuffer{}
+	rb, err := NewRuneBuffer(out, "P> ", 0, true, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	rb.SetHighlighter(func(buf []rune) []StyleRange {
+		<-release
+		return []StyleRange{{0, 1, "31"}}
+	})
+
+	// the buffer is refreshed while the highlighter runs without holding the lock
+	rb.WriteString("x")
+	rb.Refresh(nil)
+	close(release)
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "\033[31mx")
+	})
+	if s := out.String(); strings.Contains(s, "P> xP> x") {
+		t.Fatalf("output %q draws the buffer twice without cleaning", s)
+	}
+}
+
+func TestRuneBufferRightPrompt(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "> ", 0, true, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.SetRightPrompt(func() string {
+		return "\033[32m[rp]\033[0m"
+	})
+	rb.WriteString("ab")
+	if s := buf.String(); !strings.HasSuffix(s, "> ab\033[17G\033[32m[rp]\033[0m\r\033[4C") {
+		t.Fatalf("output %q doesn't end with the right prompt", s)
+	}
+
+	buf.Reset()
+	rb.WriteString(strings.Repeat("x", 14))
+	if s := buf.String(); strings.Contains(s, "[rp]") {
+		t.Fatalf("output %q contains the colliding right prompt", s)
+	}
+
+	rb.SetMultiLine(true, "")
+	rb.Set(1, []rune("a\nb"))
+	if s := buf.String(); !strings.HasSuffix(s, "> a\nb\033[1A\033[17G\033[32m[rp]\033[0m\033[1B\r\033[1C\033[1A\r\033[3C") {
+		t.Fatalf("output %q doesn't end with the right prompt on the cursor row", s)
+	}
+}
+
+func TestRuneBufferBackspaceSequence(t *testing.T) {
+	rb, err := NewRuneBuffer(io.Discard, "> ", 0, true, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"abcdefghijklmnopqrstuvwxyz", "a\t世界xyz", "世界世界世界世界世界世界", "\t\t\tab世cd\tefgh",
+		"abcdefghi世jk", "a世界世界世界世"} {
+		rb.Set(0, []rune(s))
+		for idx := 0; idx <= len(rb.buf); idx++ {
+			rb.idx = idx
+			// replay the sequence from the end of the buffer
+			row, col := rb.position(len(rb.buf))
+			var seqBuf bytes.Buffer
+			rb.writeBackspaceSequence(&seqBuf)
+			seq := seqBuf.String()
+			for len(seq) > 0 {
+				switch {
+				case seq[0] == '\b':
+					col--
+					seq = seq[1:]
+				case seq[0] == '\r':
+					col = 0
+					seq = seq[1:]
+				case strings.HasPrefix(seq, "\033[A"):
+					row--
+					seq = seq[3:]
+				case strings.HasPrefix(seq, "\033[12C"):
+					col = 11
+					seq = seq[5:]
+				case strings.HasPrefix(seq, "\033[") && strings.IndexAny(seq, "AC") > 2:
+					// the cursor sequence of the buffer with a wrapped wide character
+					end := strings.IndexAny(seq, "AC")
+					n, err := strconv.Atoi(seq[2:end])
+					if err != nil {
+						t.Fatalf("%q at %d: unexpected sequence %q", s, idx, seq)
+					}
+					if seq[end] == 'A' {
+						row -= n
+					} else {
+						col += n
+					}
+					seq = seq[end+1:]
+				default:
+					t.Fatalf("%q at %d: unexpected sequence %q", s, idx, seq)
+				}
+			}
+			if r, c := rb.position(idx); row != r || col != c {
+				t.Errorf("%q at %d: cursor at %d:%d, expected %d:%d", s, idx, row, col, r, c)
+			}
+		}
+	}
+}
+
+func TestRuneBufferMoveToEndWord(t *testing.T) {
+	rb := newTestRuneBuffer(t, "ab cd", 4)
+	if !rb.MoveToEndWord() {
+		t.Fatal("move to end word failed at the last rune")
+	}
+	assertRuneBuffer(t, rb, "ab cd", 5)
+
+	rb.SetBuf(0, rb.Runes())
+	rb.MoveToEndWord()
+	assertRuneBuffer(t, rb, "ab cd", 1)
+}
+
+func TestRuneBufferMoveToBigWord(t *testing.T) {
+	tests := []struct {
+		move     func(rb *RuneBuffer) bool
+		big      func(rb *RuneBuffer) bool
+		idx      int
+		expected int
+		bigIdx   int
+	}{
+		{(*RuneBuffer).MoveToNextWord, (*RuneBuffer).MoveToNextBigWord, 0, 6, 12},
+		{(*RuneBuffer).MoveToPrevWord, (*RuneBuffer).MoveToPrevBigWord, 12, 6, 0},
+		{(*RuneBuffer).MoveToEndWord, (*RuneBuffer).MoveToEndBigWord, 0, 4, 10},
+	}
+	for i, test := range tests {
+		rb := newTestRuneBuffer(t, "hello.world foo", test.idx)
+		if !test.move(rb) {
+			t.Errorf("%d: word movement failed", i)
+		}
+		assertRuneBuffer(t, rb, "hello.world foo", test.expected)
+
+		rb.SetBuf(test.idx, rb.Runes())
+		if !test.big(rb) {
+			t.Errorf("%d: WORD movement failed", i)
+		}
+		assertRuneBuffer(t, rb, "hello.world foo", test.bigIdx)
+	}
+}
+
+func TestRuneBufferMoveToString(t *testing.T) {
+	tests := []struct {
+		idx               int
+		s                 string
+		prevChar, reverse bool
+		ok                bool
+		expected          int
+	}{
+		{0, "cd", false, false, true, 3},
+		{0, "cd", true, false, true, 2},
+		{3, "cd", false, false, true, 9},
+		{0, "xy", false, false, false, 0},
+		{0, "", false, false, false, 0},
+		{9, "cd", false, true, true, 3},
+		{9, "cd", true, true, true, 4},
+		{3, "cd", false, true, false, 3},
+		{11, "ab cd", false, true, true, 6},
+	}
+	for _, test := range tests {
+		rb := newTestRuneBuffer(t, "ab cd ab cd", test.idx)
+		if ok := rb.MoveToString(test.s, test.prevChar, test.reverse); ok != test.ok {
+			t.Errorf("%q from %d: %v, expected %v", test.s, test.idx, ok, test.ok)
+		}
+		if idx := rb.Index(); idx != test.expected {
+			t.Errorf("%q from %d: index %d, expected %d", test.s, test.idx, idx, test.expected)
+		}
+	}
+}
+
+func TestRuneBufferFind(t *testing.T) {
+	rb := newTestRuneBuffer(t, "a.b.c.d", 3)
+	tests := []struct {
+		backward bool
+		ch       rune
+		nth      int
+		idx      int
+		found    bool
+	}{
+		{false, '.', 1, 5, true},
+		{false, '.', 2, -1, false},
+		{false, 'd', 1, 6, true},
+		{false, 'x', 1, -1, false},
+		{false, '.', 0, -1, false},
+		{true, '.', 1, 1, true},
+		{true, 'a', 1, 0, true},
+		{true, '.', 2, -1, false},
+	}
+	for _, test := range tests {
+		find := rb.FindForward
+		if test.backward {
+			find = rb.FindBackward
+		}
+		if idx, found := find(test.ch, test.nth); idx != test.idx || found != test.found {
+			t.Errorf("backward %v, %q %d: %d %v, expected %d %v", test.backward, test.ch, test.nth, idx, found,
+				test.idx, test.found)
+		}
+	}
+
+	// there is nothing after the end or before the start
+	rb.SetBuf(7, rb.Runes())
+	if _, found := rb.FindForward('d', 1); found {
+		t.Error("found after the end")
+	}
+	rb.SetBuf(0, rb.Runes())
+	if _, found := rb.FindBackward('a', 1); found {
+		t.Error("found before the start")
+	}
+}
+
+func TestRuneBufferMoveToNthChar(t *testing.T) {
+	rb := newTestRuneBuffer(t, "a.b.c.d", 0)
+	if !rb.MoveToNthChar('.', 2, false, false) {
+		t.Fatal("move failed")
+	}
+	assertRuneBuffer(t, rb, "a.b.c.d", 3)
+	if !rb.MoveToNthChar('.', 1, false, true) {
+		t.Fatal("move failed")
+	}
+	assertRuneBuffer(t, rb, "a.b.c.d", 4)
+	if rb.MoveToNthChar('.', 2, false, false) {
+		t.Fatal("move succeeded without the 2nd rune")
+	}
+	assertRuneBuffer(t, rb, "a.b.c.d", 4)
+	if !rb.MoveToNthChar('.', 2, true, true) {
+		t.Fatal("move failed")
+	}
+	assertRuneBuffer(t, rb, "a.b.c.d", 2)
+}
+
+func TestRuneBufferMoveToWordBoundary(t *testing.T) {
+	rb := newTestRuneBuffer(t, "ab  cd ef", 1)
+	for _, expected := range []int{4, 7} {
+		if !rb.MoveToWordBoundary(true) {
+			t.Fatal("move forward failed")
+		}
+		assertRuneBuffer(t, rb, "ab  cd ef", expected)
+	}
+	if rb.MoveToWordBoundary(true) {
+		t.Fatal("move forward succeeded in the last word")
+	}
+	assertRuneBuffer(t, rb, "ab  cd ef", 7)
+
+	for _, expected := range []int{6, 2} {
+		if !rb.MoveToWordBoundary(false) {
+			t.Fatal("move backward failed")
+		}
+		assertRuneBuffer(t, rb, "ab  cd ef", expected)
+	}
+	if rb.MoveToWordBoundary(false) {
+		t.Fatal("move backward succeeded in the first word")
+	}
+	assertRuneBuffer(t, rb, "ab  cd ef", 2)
+}
+
+func TestRuneBufferBackupStack(t *testing.T) {
+	rb := newTestRuneBuffer(t, "one", 1)
+	rb.Backup()
+	rb.PushBackup()
+	rb.Set(3, []rune("two"))
+	rb.PushBackup()
+	rb.WriteString("!")
+	rb.PushBackup()
+	rb.Set(0, []rune("four"))
+	if depth := rb.BackupDepth(); depth != 3 {
+		t.Fatalf("backup depth %d, expected 3", depth)
+	}
+
+	for _, expected := range []struct {
+		s   string
+		idx int
+	}{{"two!", 4}, {"two", 3}, {"one", 1}} {
+		if !rb.PopRestore() {
+			t.Fatal("pop restore failed")
+		}
+		assertRuneBuffer(t, rb, expected.s, expected.idx)
+	}
+	if rb.PopRestore() {
+		t.Fatal("pop restore succeeded on the empty backup stack")
+	}
+
+	rb.Set(0, []rune("five"))
+	rb.Restore()
+	assertRuneBuffer(t, rb, "one", 1)
+
+	rb.PushBackup()
+	rb.ClearAndForget()
+	if depth := rb.BackupDepth(); depth != 0 {
+		t.Fatalf("backup depth %d after ClearAndForget, expected 0", depth)
+	}
+}
+
+func TestRuneBufferWriteRunesAllocs(t *testing.T) {
+	const n = 10000
+	rb := newTestRuneBuffer(t, "", 0)
+	before := RuneBufferAllocStats()
+	for i := 0; i < n; i++ {
+		rb.WriteRune('a')
+	}
+	// the insertions in the middle of the buffer grow it like the appends
+	rb.SetBuf(n/2, rb.Runes())
+	for i := 0; i < n; i++ {
+		rb.WriteRune('b')
+	}
+	assertRuneBuffer(t, rb, strings.Repeat("a", n/2)+strings.Repeat("b", n)+strings.Repeat("a", n/2), n/2+n)
+	// the buffer is doubled at most log2(2*n) times, and SetBuf copies it once
+	if allocs := RuneBufferAllocStats().Allocs - before.Allocs; allocs > 16 {
+		t.Fatalf("%d slices are allocated for %d insertions, expected at most 16", allocs, 2*n)
+	}
+}
+
+func BenchmarkWriteRunesSequential(b *testing.B) {
+	const n = 10000
+	b.ReportAllocs()
+	var allocs float64
+	for i := 0; i < b.N; i++ {
+		allocs += testing.AllocsPerRun(1, func() {
+			rb := newTestRuneBuffer(b, "", 0)
+			for j := 0; j < n; j++ {
+				rb.WriteRune('a')
+			}
+		})
+	}
+	b.ReportMetric(allocs/float64(b.N)/n, "allocs/rune")
+}
+
+func TestRuneBufferLineCount(t *testing.T) {
+	tests := []struct {
+		s         string
+		idx       int
+		lineCount int
+		idxLine   int
+	}{
+		{"", 0, 1, 0},
+		{"abcdefgh", 8, 1, 1},
+		{"abcdefgh", 4, 1, 0},
+		{"abcdefg\t", 8, 2, 1},
+		{"abcdefg\tx", 7, 2, 0},
+		{"abcdefg世", 8, 2, 1},
+		{"abcdefg世", 7, 2, 0},
+		{"abcdef世", 7, 1, 1},
+		{"a\t\t\t\tx", 6, 2, 1},
+	}
+	for _, test := range tests {
+		rb, err := NewRuneBuffer(io.Discard, "> ", 0, true, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rb.SetBuf(test.idx, []rune(test.s))
+		if n := rb.LineCount(); n != test.lineCount {
+			t.Errorf("%q: line count %d, expected %d", test.s, n, test.lineCount)
+		}
+		if n := rb.IdxLine(); n != test.idxLine {
+			t.Errorf("%q at %d: index line %d, expected %d", test.s, test.idx, n, test.idxLine)
+		}
+	}
+}
+
+func TestRuneBufferMultiLinePrompt(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "\033[1m~/src\033[0m\nline 2\n> ", 0, true, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := rb.PromptWidth(); w != 2 {
+		t.Fatalf("prompt width %d, expected 2", w)
+	}
+
+	tests := []struct {
+		s         string
+		idx       int
+		lineCount int
+		idxLine   int
+	}{
+		{"", 0, 3, 2},
+		{"abc", 1, 3, 2},
+		{"abcdefghij", 10, 4, 3},
+		{"abcdefghij", 2, 4, 2},
+	}
+	for _, test := range tests {
+		rb.SetBuf(test.idx, []rune(test.s))
+		if n := rb.LineCount(); n != test.lineCount {
+			t.Errorf("%q: line count %d, expected %d", test.s, n, test.lineCount)
+		}
+		if n := rb.IdxLine(); n != test.idxLine {
+			t.Errorf("%q at %d: index line %d, expected %d", test.s, test.idx, n, test.idxLine)
+		}
+		// the clean-up moves up to the first line of the prompt
+		rb.Refresh(nil)
+		buf.Reset()
+		rb.Clean()
+		if n := strings.Count(buf.String(), "\033[A"); n != test.idxLine {
+			t.Errorf("%q at %d: clean-up %q moves up %d rows, expected %d", test.s, test.idx, buf.String(), n, test.idxLine)
+		}
+	}
+
+	rb.SetPrompt("> ")
+	if n := rb.IdxLine(); n != 0 {
+		t.Fatalf("index line %d with the single-line prompt, expected 0", n)
+	}
+}
+
+func TestRuneBufferRefreshAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops the buffers randomly with the race detector")
+	}
+	rb, err := NewRuneBuffer(ioutil.Discard, "> ", 0, true, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.WriteString("hello world, wrapped at the screen edge")
+	rb.MoveBackward()
+	if allocs := testing.AllocsPerRun(100, func() { rb.Refresh(nil) }); allocs != 0 {
+		t.Fatalf("%v allocations per refresh, expected 0", allocs)
+	}
+}
+
+func TestRuneBufferIncrementalAppend(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "> ", 0, true, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the empty buffer is written with " \b" like at the screen edge, so the first rune is redrawn
+	rb.WriteRune('a')
+
+	// "> abcdefgh" ends at the screen edge with " \b", so "i" is redrawn on the next row
+	for _, r := range "bcdefghij" {
+		buf.Reset()
+		rb.WriteRune(r)
+		s := buf.String()
+		switch r {
+		case 'h':
+			if s != "h \b" {
+				t.Fatalf("output %q after appending %q, expected %q", s, r, "h \b")
+			}
+		case 'i':
+			if !strings.Contains(s, "\033[2K") {
+				t.Fatalf("output %q after appending %q, expected the full redraw", s, r)
+			}
+		default:
+			if s != string(r) {
+				t.Fatalf("output %q after appending %q, expected %q", s, r, string(r))
+			}
+		}
+	}
+	assertRuneBuffer(t, rb, "abcdefghij", 10)
+
+	tests := []struct {
+		name string
+		f    func()
+	}{
+		{"backspace", func() { rb.Backspace() }},
+		{"insert in the middle", func() { rb.MoveBackward(); rb.WriteRune('x'); rb.MoveToLineEnd() }},
+		{"insert multiple runes", func() { rb.WriteString("yz") }},
+		{"refresh", func() { rb.Refresh(nil) }},
+	}
+	for _, test := range tests {
+		buf.Reset()
+		test.f()
+		if s := buf.String(); !strings.Contains(s, "\033[2K") {
+			t.Errorf("%s: output %q, expected the full redraw", test.name, s)
+		}
+	}
+
+	// the region is closed after the buffer, so it isn't a prefix of the next output
+	rb.SetMark()
+	rb.MoveBackward()
+	rb.MoveToLineEnd()
+	buf.Reset()
+	rb.WriteRune('w')
+	if s := buf.String(); !strings.Contains(s, "\033[2K") {
+		t.Errorf("output %q with the region, expected the full redraw", s)
+	}
+}
+
+func BenchmarkAppendChar(b *testing.B) {
+	var w countWriter
+	rb, err := NewRuneBuffer(&w, "> ", 0, true, 80)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%64 == 0 {
+			rb.Set(0, nil)
+		}
+		rb.WriteRune('a')
+	}
+	b.ReportMetric(float64(w)/float64(b.N), "bytes/op")
+}
+
+// syncBuffer is a bytes.Buffer which is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor waits until cond returns true, and fails the test after a second.
+func waitFor(tb testing.TB, cond func() bool) {
+	tb.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			tb.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// countWriter counts the written bytes.
+type countWriter int
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	*w += countWriter(len(p))
+	return len(p), nil
+}
+
+// BenchmarkTypingLatency types 1000 keys before the last rune of the buffer, so every key redraws the buffer.
+func BenchmarkTypingLatency(b *testing.B) {
+	var w callCountWriter
+	rb, err := NewRuneBuffer(&w, "> ", 0, true, 80)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.Set(0, []rune("!"))
+		for j := 0; j < 1000; j++ {
+			if j%50 == 0 {
+				rb.Set(0, []rune("!"))
+			}
+			rb.WriteRune('a')
+		}
+	}
+	b.ReportMetric(float64(w)/float64(b.N), "writes/op")
+}
+
+// callCountWriter counts the write calls.
+type callCountWriter int
+
+func (w *callCountWriter) Write(p []byte) (int, error) {
+	*w++
+	return len(p), nil
+}
+
+func BenchmarkRefresh(b *testing.B) {
+	rb, err := NewRuneBuffer(ioutil.Discard, "> ", 0, true, 80)
+	if err != nil {
+		b.Fatal(err)
+	}
+	rb.WriteString("hello world")
+	rb.MoveBackward()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.Refresh(nil)
+	}
+}
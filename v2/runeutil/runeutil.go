@@ -3,3 +3,24 @@ package runeutil
 var (
 	TabWidth = 4
 )
+
+// DefaultKillRingSize is the default number of entries kept in the kill ring.
+const DefaultKillRingSize = 10
+
+// DefaultUndoDepth is the default maximum number of undo steps.
+const DefaultUndoDepth = 100
+
+// writeBufferSize is the size of the output buffer of RuneBuffer, so a redraw is written at once.
+const writeBufferSize = 4096
+
+// HintStyle is the SGR parameter of the hint displayed after the buffer.
+const HintStyle = "2"
+
+// RegionStyle is the SGR parameter of the region between the mark and the cursor.
+const RegionStyle = "7"
+
+// StyleRange is the SGR parameter Style of the runes from Start to End in the buffer.
+type StyleRange struct {
+	Start, End int
+	Style      string
+}
@@ -0,0 +1,85 @@
+package runeutil
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ColorMode is the kind of a Color.
+type ColorMode int
+
+const (
+	// ColorDefault is the default color of the terminal.
+	ColorDefault ColorMode = iota
+	// Color16 is one of the 16 basic colors by Index, 8-15 are the bright ones.
+	Color16
+	// Color256 is one of the 256 colors of the xterm palette by Index.
+	Color256
+	// ColorTrue is the 24-bit color by R, G and B.
+	ColorTrue
+)
+
+// Color is a foreground or background color of a Style.
+type Color struct {
+	Mode    ColorMode
+	Index   int
+	R, G, B uint8
+}
+
+// Style is the text style which is written by an SGR sequence.
+type Style struct {
+	Fg, Bg    Color
+	Bold      bool
+	Italic    bool
+	Underline bool
+	Reverse   bool
+}
+
+// SGR returns the SGR parameter of s, like "1;38;5;208". It's "0" for the zero Style. The colors with an invalid
+// Index are ignored.
+func (s Style) SGR() string {
+	var params []string
+	if s.Bold {
+		params = append(params, "1")
+	}
+	if s.Italic {
+		params = append(params, "3")
+	}
+	if s.Underline {
+		params = append(params, "4")
+	}
+	if s.Reverse {
+		params = append(params, "7")
+	}
+	if p := s.Fg.sgr(30, 90, "38"); p != "" {
+		params = append(params, p)
+	}
+	if p := s.Bg.sgr(40, 100, "48"); p != "" {
+		params = append(params, p)
+	}
+	if len(params) == 0 {
+		return "0"
+	}
+	return strings.Join(params, ";")
+}
+
+// sgr returns the SGR parameter of c. base and brightBase are the parameters of the first basic and bright colors,
+// and extended is the parameter of the 256 colors and the 24-bit colors.
+func (c Color) sgr(base, brightBase int, extended string) string {
+	switch c.Mode {
+	case Color16:
+		switch {
+		case c.Index >= 0 && c.Index < 8:
+			return strconv.Itoa(base + c.Index)
+		case c.Index >= 8 && c.Index < 16:
+			return strconv.Itoa(brightBase + c.Index - 8)
+		}
+	case Color256:
+		if c.Index >= 0 && c.Index < 256 {
+			return extended + ";5;" + strconv.Itoa(c.Index)
+		}
+	case ColorTrue:
+		return extended + ";2;" + strconv.Itoa(int(c.R)) + ";" + strconv.Itoa(int(c.G)) + ";" + strconv.Itoa(int(c.B))
+	}
+	return ""
+}
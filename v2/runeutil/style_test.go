@@ -0,0 +1,46 @@
+package runeutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStyleSGR(t *testing.T) {
+	tests := []struct {
+		style    Style
+		expected string
+	}{
+		{Style{}, "0"},
+		{Style{Bold: true, Italic: true, Underline: true, Reverse: true}, "1;3;4;7"},
+		{Style{Fg: Color{Mode: Color16, Index: 1}}, "31"},
+		{Style{Fg: Color{Mode: Color16, Index: 9}, Bg: Color{Mode: Color16, Index: 4}}, "91;44"},
+		{Style{Bg: Color{Mode: Color16, Index: 15}}, "107"},
+		{Style{Fg: Color{Mode: Color16, Index: 16}}, "0"},
+		{Style{Bold: true, Fg: Color{Mode: Color256, Index: 208}}, "1;38;5;208"},
+		{Style{Bg: Color{Mode: Color256, Index: 0}}, "48;5;0"},
+		{Style{Fg: Color{Mode: Color256, Index: 256}}, "0"},
+		{Style{Fg: Color{Mode: ColorTrue, R: 255, G: 128}, Bg: Color{Mode: ColorTrue, B: 1}}, "38;2;255;128;0;48;2;0;0;1"},
+		{Style{Underline: true, Fg: Color{Mode: ColorDefault, Index: 1}}, "4"},
+	}
+	for _, test := range tests {
+		if sgr := test.style.SGR(); sgr != test.expected {
+			t.Errorf("%+v: SGR %q, expected %q", test.style, sgr, test.expected)
+		}
+	}
+}
+
+func TestRuneBufferSetStyled(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := NewRuneBuffer(&buf, "> ", 0, true, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.WriteString("hello")
+
+	buf.Reset()
+	rb.SetStyled(1, 3, Style{Bold: true, Fg: Color{Mode: ColorTrue, R: 1, G: 2, B: 3}})
+	expected := "\r\033[3C\033[1;38;2;1;2;3mel\033[0m\r\033[7C"
+	if s := buf.String(); s != expected {
+		t.Fatalf("output %q, expected %q", s, expected)
+	}
+}
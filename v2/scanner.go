@@ -0,0 +1,36 @@
+package readline
+
+import (
+	"bufio"
+	"io"
+)
+
+// terminalReader is an io.Reader which reads the lines of Terminal by ReadBytes. Each line is followed by a newline.
+type terminalReader struct {
+	t   *Terminal
+	buf []byte
+}
+
+// NewTerminalScanner returns a bufio.Scanner which scans the lines of t. The lines are read by ReadLine, so the
+// editing isn't different from ReadLine, and the reading can't be canceled by a context. The scanner stops without
+// an error at the end of the input or when the input is interrupted, like by Ctrl+C.
+func NewTerminalScanner(t *Terminal) *bufio.Scanner {
+	return bufio.NewScanner(&terminalReader{t: t})
+}
+
+func (r *terminalReader) Read(p []byte) (n int, err error) {
+	if len(r.buf) <= 0 {
+		line, err := r.t.ReadBytes()
+		switch err {
+		case nil:
+		case ErrInterrupted:
+			return 0, io.EOF
+		default:
+			return 0, err
+		}
+		r.buf = append(line, '\n')
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,31 @@
+package readline
+
+import (
+	"io"
+	"testing"
+)
+
+func TestTerminalScanner(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{})
+	scanner := NewTerminalScanner(term)
+	for _, line := range []string{"first", "second line", "third"} {
+		if _, err := io.WriteString(stdin, line+"\r"); err != nil {
+			t.Fatal(err)
+		}
+		if !scanner.Scan() {
+			t.Fatalf("scan failed: %v", scanner.Err())
+		}
+		if text := scanner.Text(); text != line {
+			t.Fatalf("line %q, expected %q", text, line)
+		}
+	}
+	if err := stdin.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if scanner.Scan() {
+		t.Fatalf("scan succeeded after the end of the input: %q", scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,166 @@
+package readline
+
+import (
+	"unicode/utf8"
+
+	"github.com/goinsane/readline/v2/runeutil"
+)
+
+// searchMatchStyle is the SGR parameter of the matched part of the line in search mode.
+const searchMatchStyle = "7"
+
+// searchState is the state of the incremental history search.
+type searchState struct {
+	backward bool
+	failed   bool
+	query    []rune
+
+	// start is the history index which the search begins from.
+	start int
+	// lineIdx and matchPos are the history index and the position of the current match, lineIdx is -1 if there is no match.
+	lineIdx  int
+	matchPos int
+
+	// prompt, buf and idx hold the state before the search.
+	prompt string
+	buf    []rune
+	idx    int
+}
+
+// searchKey processes p in search mode. It returns false if the search has been exited and
+// p should be processed normally.
+func (t *Terminal) searchKey(p []byte) bool {
+	switch p[0] {
+	case CharBckSearch:
+		t.searchNext(true)
+
+	case CharFwdSearch:
+		t.searchNext(false)
+
+	case CharInterrupt, CharBell:
+		t.searchExit(true)
+
+	case CharBackspace, CharBackspaceEx:
+		s := t.search
+		if len(s.query) <= 0 {
+			t.bell()
+			break
+		}
+		s.query = s.query[:len(s.query)-1]
+		s.lineIdx = -1
+		t.searchUpdate(s.start)
+
+	default:
+		if p[0] < 0x20 {
+			t.searchExit(false)
+			return false
+		}
+		s := t.search
+		for len(p) > 0 {
+			r, size := utf8.DecodeRune(p)
+			s.query = append(s.query, r)
+			p = p[size:]
+		}
+		start := s.lineIdx
+		if start < 0 {
+			start = s.start
+		}
+		t.searchUpdate(start)
+
+	}
+	return true
+}
+
+func (t *Terminal) searchStart(backward bool) {
+	t.historySync()
+	t.search = &searchState{
+		backward: backward,
+		start:    t.history.Len(),
+		lineIdx:  -1,
+		prompt:   t.rb.Prompt(),
+		buf:      t.rb.Runes(),
+		idx:      t.rb.Index(),
+	}
+	t.searchRender()
+}
+
+func (t *Terminal) searchNext(backward bool) {
+	s := t.search
+	s.backward = backward
+	if len(s.query) <= 0 {
+		t.searchRender()
+		return
+	}
+	start := s.lineIdx
+	if start < 0 {
+		start = s.start
+	}
+	if backward {
+		start--
+	} else {
+		start++
+	}
+	t.searchUpdate(start)
+}
+
+// searchUpdate searches the query beginning from the history line at start, and renders the result.
+func (t *Terminal) searchUpdate(start int) {
+	s := t.search
+	s.failed = false
+	if len(s.query) > 0 {
+		idx, pos := t.history.Search(s.query, start, s.backward, t.config.HistorySearchFold)
+		if idx < 0 {
+			s.failed = true
+			t.bell()
+		} else {
+			s.lineIdx = idx
+			s.matchPos = pos
+		}
+	}
+	t.searchRender()
+}
+
+func (t *Terminal) searchRender() {
+	s := t.search
+	prompt := "i-search"
+	if s.backward {
+		prompt = "reverse-" + prompt
+	}
+	if s.failed {
+		prompt = "failed " + prompt
+	}
+	t.rb.SetPrompt("(" + prompt + ")`" + string(s.query) + "': ")
+	if s.lineIdx < 0 || len(s.query) <= 0 {
+		t.rb.Set(s.idx, s.buf)
+		return
+	}
+	line, _ := t.history.Line(s.lineIdx)
+	buf := []rune(line)
+	end := s.matchPos + len(s.query)
+	t.rb.Set(end, buf)
+	t.rb.SetStyle(s.matchPos, end, searchMatchStyle)
+}
+
+// searchExit exits the search mode. If restore is true, the buffer before the search is restored.
+func (t *Terminal) searchExit(restore bool) {
+	s := t.search
+	t.search = nil
+	t.rb.SetPrompt(s.prompt)
+	if restore {
+		t.rb.Set(s.idx, s.buf)
+	}
+}
+
+// searchLine returns the position of query in line, or -1 if it is not present.
+func searchLine(line, query []rune, backward, fold bool) int {
+	if backward {
+		if fold {
+			return runeutil.IndexAllBckFold(line, query)
+		}
+		return runeutil.IndexAllBck(line, query)
+	}
+	if fold {
+		return runeutil.IndexAllFold(line, query)
+	}
+	return runeutil.IndexAll(line, query)
+}
@@ -0,0 +1,49 @@
+// +build darwin dragonfly freebsd netbsd openbsd linux,!appengine solaris
+
+package readline
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// StartSIGWINCHWatcher starts to watch SIGWINCH, and notifies the channels registered by RegisterOnScreenSizeChanged
+// when the terminal is resized. The returned stop function stops watching.
+func StartSIGWINCHWatcher() (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	go func() {
+		for range ch {
+			select {
+			case screenSizeChangedCh <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
+
+// startSIGCONTWatcher starts to watch SIGCONT, and calls f when the process is continued. The returned stop function
+// stops watching.
+func startSIGCONTWatcher(f func()) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGCONT)
+	go func() {
+		for range ch {
+			f()
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
+
+// suspendProcess suspends the current process by SIGTSTP.
+func suspendProcess() error {
+	return syscall.Kill(syscall.Getpid(), syscall.SIGTSTP)
+}
@@ -0,0 +1,17 @@
+// +build windows
+
+package readline
+
+import (
+	"syscall"
+)
+
+// startSIGCONTWatcher does nothing, there is no SIGCONT on Windows.
+func startSIGCONTWatcher(f func()) (stop func()) {
+	return func() {}
+}
+
+// suspendProcess returns syscall.EWINDOWS, a process can't be suspended by a signal on Windows.
+func suspendProcess() error {
+	return syscall.EWINDOWS
+}
@@ -1,6 +1,7 @@
 package readline
 
 import (
+	"os"
 	"sync"
 	"syscall"
 )
@@ -10,6 +11,20 @@ func init() {
 	go loopOnScreenSizeChanged()
 }
 
+// DefaultScreenWidth is the screen width used when the width of the screen can not be determined.
+const DefaultScreenWidth = 80
+
+// NoColor returns true if the NO_COLOR environment variable is set to a non-empty value.
+// See https://no-color.org.
+func NoColor() bool {
+	return os.Getenv("NO_COLOR") != ""
+}
+
+// IsTMux returns true if the program is running in tmux, which sets the TMUX environment variable.
+func IsTMux() bool {
+	return os.Getenv("TMUX") != ""
+}
+
 // IsScreenTerminal returns true if the current screen is a terminal.
 func IsScreenTerminal() bool {
 	return IsStdinTerminal() && (IsStdoutTerminal() || IsStderrTerminal())
@@ -17,28 +32,45 @@ func IsScreenTerminal() bool {
 
 // IsStdinTerminal returns true if stdin is a terminal.
 func IsStdinTerminal() bool {
-	return IsTerminal(syscall.Stdin)
+	return IsTerminal(int(syscall.Stdin))
 }
 
 // IsStdoutTerminal returns true if stdout is a terminal.
 func IsStdoutTerminal() bool {
-	return IsTerminal(syscall.Stdout)
+	return IsTerminal(int(syscall.Stdout))
 }
 
 // IsStderrTerminal returns true if stderr is a terminal.
 func IsStderrTerminal() bool {
-	return IsTerminal(syscall.Stderr)
+	return IsTerminal(int(syscall.Stderr))
 }
 
 // GetScreenSize gets size of the current screen.
 func GetScreenSize() (int, int, error) {
-	cols, rows, err := GetSize(syscall.Stdout)
+	cols, rows, err := GetSize(int(syscall.Stdout))
 	if err != nil {
-		cols, rows, err = GetSize(syscall.Stderr)
+		cols, rows, err = GetSize(int(syscall.Stderr))
 	}
 	return cols, rows, err
 }
 
+// TerminalFD is implemented by the readers and the writers which have a file descriptor, like *os.File.
+type TerminalFD interface {
+	Fd() uintptr
+}
+
+// terminalFD returns the file descriptor of v. It returns -1 and false if v doesn't implement TerminalFD.
+func terminalFD(v interface{}) (int, bool) {
+	f, ok := v.(TerminalFD)
+	if !ok {
+		return -1, false
+	}
+	if file, isFile := f.(*os.File); isFile && file == nil {
+		return -1, false
+	}
+	return int(f.Fd()), true
+}
+
 // GetWidth gets width of the given file descriptor. If error occurs, it returns -1.
 func GetWidth(stdoutFd int) int {
 	cols, _, err := GetSize(stdoutFd)
@@ -50,9 +82,9 @@ func GetWidth(stdoutFd int) int {
 
 // GetScreenWidth gets width of the current screen. If error occurs, it returns -1.
 func GetScreenWidth() int {
-	w := GetWidth(syscall.Stdout)
+	w := GetWidth(int(syscall.Stdout))
 	if w < 0 {
-		w = GetWidth(syscall.Stderr)
+		w = GetWidth(int(syscall.Stderr))
 	}
 	return w
 }
@@ -68,9 +100,9 @@ func GetHeight(stdoutFd int) int {
 
 // GetScreenHeight gets height of the current screen. If error occurs, it returns -1.
 func GetScreenHeight() int {
-	h := GetHeight(syscall.Stdout)
+	h := GetHeight(int(syscall.Stdout))
 	if h < 0 {
-		h = GetHeight(syscall.Stderr)
+		h = GetHeight(int(syscall.Stderr))
 	}
 	return h
 }
@@ -116,14 +148,19 @@ var (
 
 func loopOnScreenSizeChanged() {
 	for range screenSizeChangedCh {
-		onScreenSizeChangedMu.Lock()
-		for ch := range onScreenSizeChangedMap {
-			select {
-			case ch <- struct{}{}:
-			default:
-			}
+		notifyOnScreenSizeChanged()
+	}
+}
+
+// notifyOnScreenSizeChanged notifies the registered channels without blocking.
+func notifyOnScreenSizeChanged() {
+	onScreenSizeChangedMu.Lock()
+	defer onScreenSizeChangedMu.Unlock()
+	for ch := range onScreenSizeChangedMap {
+		select {
+		case ch <- struct{}{}:
+		default:
 		}
-		onScreenSizeChangedMu.Unlock()
 	}
 }
 
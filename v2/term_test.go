@@ -0,0 +1,77 @@
+package readline
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyOnScreenSizeChanged(t *testing.T) {
+	ch := make(chan struct{}, 1)
+	RegisterOnScreenSizeChanged(ch)
+	defer UnregisterOnScreenSizeChanged(ch)
+
+	notifyOnScreenSizeChanged()
+	// the channel is full, but notifying must not block
+	notifyOnScreenSizeChanged()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("channel isn't notified")
+	}
+}
+
+func TestTerminalScreenSizeChanged(t *testing.T) {
+	out := &syncBuffer{}
+	term, _ := newTestTerminalOutput(t, Config{Prompt: "> ", ForceUseInteractive: true}, out)
+
+	term.screenSizeChanged(120, 40)
+	if width := term.rb.ScreenWidth(); width != 120 {
+		t.Fatalf("screen width %d, expected 120", width)
+	}
+	if height := term.ScreenHeight(); height != 40 {
+		t.Fatalf("screen height %d, expected 40", height)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "> ")
+	})
+}
+
+// setenv sets the environment variable key to value, and returns the function which restores it.
+func setenv(tb testing.TB, key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		tb.Fatal(err)
+	}
+	return func() {
+		if ok {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	}
+}
+
+func TestTerminalNoColor(t *testing.T) {
+	defer setenv(t, "NO_COLOR", "1")()
+	if !NoColor() {
+		t.Fatal("NoColor returned false")
+	}
+
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{
+		Prompt:              "\033[1;31m>\033[0m ",
+		ForceUseInteractive: true,
+		HistoryLimit:        -1,
+	}, out)
+	writeAndReadLine(t, term, stdin, "abc\x01\x00\x05\x12b\x07\r")
+
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "\n")
+	})
+	if s := out.String(); regexp.MustCompile(`\033\[[0-9;]*m`).MatchString(s) {
+		t.Fatalf("output %q contains an SGR sequence", s)
+	}
+}
@@ -16,7 +16,7 @@ var (
 
 func init() {
 	initScreenBrokenPipe()
-	initScreenSizeChanged()
+	StartSIGWINCHWatcher()
 }
 
 func initScreenBrokenPipe() {
@@ -34,19 +34,6 @@ func initScreenBrokenPipe() {
 	}()
 }
 
-func initScreenSizeChanged() {
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGWINCH)
-	go func() {
-		for range ch {
-			select {
-			case screenSizeChangedCh <- struct{}{}:
-			default:
-			}
-		}
-	}()
-}
-
 // State contains the state of a terminal.
 type State struct {
 	termios Termios
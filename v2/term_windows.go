@@ -0,0 +1,175 @@
+package readline
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	enableProcessedInput            = 0x0001
+	enableLineInput                 = 0x0002
+	enableEchoInput                 = 0x0004
+	enableVirtualTerminalInput      = 0x0200
+	enableVirtualTerminalProcessing = 0x0004
+
+	ctrlBreakEvent = 1
+)
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+	procSetConsoleCtrlHandler      = kernel32.NewProc("SetConsoleCtrlHandler")
+)
+
+var (
+	screenBrokenPipeCh  = make(chan struct{}, 1)
+	screenSizeChangedCh = make(chan struct{}, 1)
+)
+
+func init() {
+	enableVirtualTerminal(int(syscall.Stdout))
+	enableVirtualTerminal(int(syscall.Stderr))
+	initCtrlBreak()
+}
+
+// enableVirtualTerminal enables the processing of ANSI escape sequences on the console output fd.
+func enableVirtualTerminal(fd int) {
+	var mode uint32
+	if err := syscall.GetConsoleMode(syscall.Handle(fd), &mode); err != nil {
+		return
+	}
+	_ = setConsoleMode(fd, mode|enableVirtualTerminalProcessing)
+}
+
+func initCtrlBreak() {
+	handler := syscall.NewCallback(func(ctrlType uint32) uintptr {
+		if ctrlType != ctrlBreakEvent {
+			return 0
+		}
+		select {
+		case screenBrokenPipeCh <- struct{}{}:
+		default:
+		}
+		return 1
+	})
+	_, _, _ = procSetConsoleCtrlHandler.Call(handler, 1)
+}
+
+type Termios struct {
+	Mode uint32
+}
+
+func getTermios(fd int) (*Termios, error) {
+	termios := new(Termios)
+	if err := syscall.GetConsoleMode(syscall.Handle(fd), &termios.Mode); err != nil {
+		return nil, err
+	}
+	return termios, nil
+}
+
+func setTermios(fd int, termios *Termios) error {
+	return setConsoleMode(fd, termios.Mode)
+}
+
+func setConsoleMode(fd int, mode uint32) error {
+	r, _, err := procSetConsoleMode.Call(uintptr(fd), uintptr(mode))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// State contains the state of a terminal.
+type State struct {
+	termios Termios
+}
+
+// Duplicate duplicates the underlying State.
+func (s *State) Duplicate() *State {
+	r := *s
+	return &r
+}
+
+// GetState returns the current state of the given file descriptor which may be useful to
+// restore the terminal after a signal.
+func GetState(fd int) (*State, error) {
+	termios, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &State{termios: *termios}, nil
+}
+
+// RestoreState restores the terminal connected to the given file descriptor to a
+// given state.
+func RestoreState(fd int, state *State) error {
+	return setTermios(fd, &state.termios)
+}
+
+// SetRawMode put the terminal connected to the given file descriptor into raw
+// mode and returns the previous state of the terminal so that it can be
+// restored.
+func SetRawMode(fd int) (*State, error) {
+	oldState, err := GetState(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	newState := oldState.Duplicate()
+	newState.termios.Mode &^= enableEchoInput | enableLineInput
+	newState.termios.Mode |= enableProcessedInput | enableVirtualTerminalInput
+
+	return oldState, RestoreState(fd, newState)
+}
+
+// IsTerminal returns true if the given file descriptor is a terminal.
+func IsTerminal(fd int) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+type coord struct {
+	x int16
+	y int16
+}
+
+type smallRect struct {
+	left   int16
+	top    int16
+	right  int16
+	bottom int16
+}
+
+type consoleScreenBufferInfo struct {
+	size              coord
+	cursorPosition    coord
+	attributes        uint16
+	window            smallRect
+	maximumWindowSize coord
+}
+
+// GetSize returns the dimensions of the given terminal.
+func GetSize(stdoutFd int) (int, int, error) {
+	var info consoleScreenBufferInfo
+	r, _, err := procGetConsoleScreenBufferInfo.Call(uintptr(stdoutFd), uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return -1, -1, err
+	}
+	return int(info.window.right-info.window.left) + 1, int(info.window.bottom-info.window.top) + 1, nil
+}
+
+// WaitForInput waits until the given file descriptor has input to read or timeout elapses.
+// It returns false if timeout elapsed. A negative timeout means no timeout.
+func WaitForInput(fd int, timeout time.Duration) (bool, error) {
+	ms := uint32(syscall.INFINITE)
+	if timeout >= 0 {
+		ms = uint32(timeout / time.Millisecond)
+	}
+	event, err := syscall.WaitForSingleObject(syscall.Handle(fd), ms)
+	if err != nil {
+		return false, err
+	}
+	return event == syscall.WAIT_OBJECT_0, nil
+}
@@ -3,10 +3,13 @@ package readline
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unicode/utf8"
 
 	"github.com/goinsane/readline/v2/runeutil"
@@ -14,70 +17,211 @@ import (
 	"github.com/goinsane/xcontext"
 )
 
+// DefaultEscapeTimeout is the default time limit of completing an escape sequence after the escape key.
+const DefaultEscapeTimeout = 100 * time.Millisecond
+
+// DefaultTabWidth is the distance of the tab stops used when Config.TabWidth is zero.
+const DefaultTabWidth = 8
+
+// maxNumericArg is the max value of the numeric argument which is typed with Meta and digits.
+const maxNumericArg = 1000000
+
 type Terminal struct {
 	config              *Config
 	stdin               int
 	stdout              int
 	stderr              int
+	output              io.Writer
 	screenBrokenPipeCh  chan struct{}
 	screenSizeChangedCh chan struct{}
 	lineResultCh        chan lineResult
+	unitReqCh           chan unitRequest
+	lineReqCh           chan []byte
+	unitRest            []byte
+	ioloopDoneCh        chan struct{}
 	rb                  *runeutil.RuneBuffer
+	history             *History
 	stdinReader         io.ReadCloser
 	stdinWriter         io.Writer
 	ctx                 context.Context
 	ctxCancel           context.CancelFunc
 	wg                  sync.WaitGroup
 	onceClose           sync.Once
+	closed              int32
 	ioErr               atomic.Value
-	ioInsMode           bool
+	ioInsMode           int32 // 1 in the overwrite mode, it's accessed atomically
 	lckr                xcontext.Locker
+	rawMu               sync.Mutex
 	oldState            *State
+	suspended           bool
+	stopSIGCONTWatcher  func()
+	isTerminal          bool
+	search              *searchState
+	grep                *grepState
+	completion          *completionState
+	asyncCompletion     *asyncCompletionState
+	hintDebounce        *hintDebounceState
+	// interruptConfirming is true while ConfirmInterruptPrompt is displayed in place of interruptPrompt
+	interruptConfirming bool
+	interruptPrompt     string
+	vi                  viState
+	keyMap              KeyMap
+	keyMapMu            sync.RWMutex
+	prompt              atomic.Value
+	pasting             bool
+	pasteBuf            []byte
+	macroRecord         bool
+	macroRecording      [][]byte
+	macroBusy           bool
+	macroPending        int
+	numericArg          int
+	numericArgSet       bool
+	numericArgNeg       bool
+	// callbackDone is closed when the last callback which is called with CallbackTimeout returns
+	callbackDone chan struct{}
+	// historyLine is the line which is set by the last history navigation
+	historyLine []rune
+	// lastFindCmd and lastFindChar are the last character search in the vi normal mode, which ';' and ',' repeat
+	lastFindCmd  rune
+	lastFindChar rune
+	// trace is the state of Config.TraceWriter, it's nil if the input isn't traced
+	trace *traceState
 }
 
 func NewTerminal(config Config) (*Terminal, error) {
 	var err error
-	if config.Stdin == nil {
-		config.Stdin = os.Stdin
-	}
-	if config.Stdout == nil {
-		config.Stdout = os.Stdout
-	}
-	if config.Stderr == nil {
-		config.Stderr = os.Stderr
+	var input io.Reader
+	var output io.Writer
+	if config.ReadWriter != nil {
+		input, output = config.ReadWriter, config.ReadWriter
+	} else {
+		if config.Stdin == nil {
+			config.Stdin = os.Stdin
+		}
+		if config.Stdout == nil {
+			config.Stdout = os.Stdout
+		}
+		if config.Stderr == nil {
+			config.Stderr = os.Stderr
+		}
+		input, output = config.Stdin, config.Stdout
 	}
 	t := &Terminal{
 		config:              &config,
-		stdin:               int(config.Stdin.Fd()),
-		stdout:              int(config.Stdout.Fd()),
-		stderr:              int(config.Stderr.Fd()),
+		output:              output,
 		screenBrokenPipeCh:  make(chan struct{}, 1),
 		screenSizeChangedCh: make(chan struct{}, 1),
 		lineResultCh:        make(chan lineResult, 1),
+		unitReqCh:           make(chan unitRequest),
+		lineReqCh:           make(chan []byte, 1),
+		ioloopDoneCh:        make(chan struct{}),
+		history:             NewHistory(config.HistoryLimit),
+		numericArg:          1,
+	}
+	t.stdin, _ = terminalFD(input)
+	t.stdout, _ = terminalFD(output)
+	t.stderr = -1
+	if config.ReadWriter == nil {
+		t.stderr, _ = terminalFD(config.Stderr)
+	}
+	t.history.CommentPrefix = config.HistoryCommentPrefix
+	t.history.Duplicates = config.HistoryDuplicates
+	t.history.IgnoreSpace = config.HistoryIgnoreSpace
+	t.history.backend = config.History
+	if t.history.backend == nil {
+		switch {
+		case config.HistoryFile != "" && config.HistoryFileMode == HistoryFileAppend:
+			t.history.backend = newAppendFileHistory(config.HistoryFile, config.HistoryCommentPrefix, t.history.limit)
+		case config.HistoryFile != "":
+			t.history.backend = newFileHistory(config.HistoryFile, config.HistoryCommentPrefix, t.history.limit)
+		default:
+			t.history.backend = newMemoryHistory(t.history.limit)
+		}
+	}
+	t.historySync()
+	if config.SubmitKey == "" {
+		t.config.SubmitKey = "\r"
 	}
-	interactive := IsTerminal(t.stdin)
+	t.prompt.Store(config.Prompt)
+	t.isTerminal = t.stdin >= 0 && IsTerminal(t.stdin)
+	interactive := t.isTerminal
 	if config.ForceUseInteractive {
 		interactive = true
 	}
-	t.rb, err = runeutil.NewRuneBuffer(config.Stdout, config.Prompt, config.Mask, interactive, t.GetWidth())
+	width := t.GetWidth()
+	if width <= 0 {
+		width = DefaultScreenWidth
+	}
+	t.rb, err = runeutil.NewRuneBuffer(output, config.Prompt, config.Mask, interactive, width)
 	if err != nil {
 		return nil, err
 	}
-	t.stdinReader, t.stdinWriter = newExtendedStdin(config.Stdin)
+	if height := t.GetHeight(); height > 0 {
+		_ = t.rb.SetScreenHeight(height)
+	}
+	if config.ForceDumb || os.Getenv("TERM") == "dumb" {
+		t.rb.SetDumb(true)
+		t.config.BracketedPaste = false
+		t.config.EnableModifyOtherKeys = false
+	}
+	if config.NoColor || NoColor() {
+		t.rb.SetNoColor(true)
+	}
+	hintProvider := config.HintProvider
+	if config.HistoryAutoSuggest {
+		hintProvider = t.historySuggestion
+		if config.HintProvider == nil {
+			t.rb.SetHintStyle(HistorySuggestionStyle)
+		}
+	}
+	if hintProvider != nil && config.HintDebounce > 0 {
+		t.hintDebounce = newHintDebounceState(hintProvider, config.HintDebounce)
+		hintProvider = t.hintDebounce.get
+	}
+	if config.TraceWriter != nil {
+		t.trace = &traceState{w: config.TraceWriter}
+	}
+	t.rb.SetHintProvider(hintProvider)
+	t.rb.SetRightPrompt(config.RightPrompt)
+	t.rb.SetHighlighter(config.Highlighter)
+	t.rb.SetMultiLine(config.MultiLine, config.ContinuationPrompt)
+	t.rb.SetKillRingSize(config.KillRingSize)
+	t.rb.SetUndoDepth(config.UndoDepth)
+	t.rb.SetReadOnly(config.ReadOnly)
+	t.rb.SetWordBreakChars(config.WordBreakChars)
+	tabWidth := config.TabWidth
+	if tabWidth == 0 {
+		tabWidth = DefaultTabWidth
+	}
+	if err = t.rb.SetTabWidth(tabWidth); err != nil {
+		return nil, err
+	}
+	if config.VimMode {
+		t.viRefreshPrompt()
+	}
+	t.stdinReader, t.stdinWriter = newExtendedStdin(input)
 	t.ctx, t.ctxCancel = context.WithCancel(context.Background())
 	RegisterOnScreenBrokenPipe(t.screenBrokenPipeCh)
 	RegisterOnScreenSizeChanged(t.screenSizeChangedCh)
-	t.wg.Add(1)
+	t.stopSIGCONTWatcher = startSIGCONTWatcher(t.resume)
+	t.wg.Add(2)
 	go t.ioloop()
+	go t.sizeloop()
 	return t, nil
 }
 
+// Close stops the terminal. The reads, WriteStdin and EnterRawMode return ErrClosed after it.
 func (t *Terminal) Close() error {
 	var err error
 	t.onceClose.Do(func() {
+		atomic.StoreInt32(&t.closed, 1)
 		t.ctxCancel()
 		_ = t.stdinReader.Close()
 		t.wg.Wait()
+		if t.hintDebounce != nil {
+			t.hintDebounce.stop()
+		}
+		t.stopSIGCONTWatcher()
 		UnregisterOnScreenBrokenPipe(t.screenBrokenPipeCh)
 		UnregisterOnScreenSizeChanged(t.screenSizeChangedCh)
 		err = t.ExitRawMode()
@@ -85,47 +229,198 @@ func (t *Terminal) Close() error {
 	return err
 }
 
+// Stdin returns Config.Stdin, it's nil if Config.ReadWriter is used instead of it.
 func (t *Terminal) Stdin() *os.File {
 	return t.config.Stdin
 }
 
+// Stdout returns Config.Stdout, it's nil if Config.ReadWriter is used instead of it. Writing to it corrupts the line
+// being edited, SafeStdout should be used instead of it while reading a line.
 func (t *Terminal) Stdout() *os.File {
 	return t.config.Stdout
 }
 
+// Stderr returns Config.Stderr, it's nil if Config.ReadWriter is used instead of Config.Stdin and Config.Stdout.
 func (t *Terminal) Stderr() *os.File {
 	return t.config.Stderr
 }
 
+// History returns the history of the terminal.
+func (t *Terminal) History() *History {
+	return t.history
+}
+
+// SetWordBreakChars sets the characters which separate words for word movements and word kills.
+// If s is empty, the default set is used.
+func (t *Terminal) SetWordBreakChars(s string) {
+	t.rb.SetWordBreakChars(s)
+}
+
+// IsInteractive returns true if the line is displayed and edited interactively.
+func (t *Terminal) IsInteractive() bool {
+	return t.rb.Interactive()
+}
+
+// GetPrompt returns the prompt of ReadLine which is set by Config.Prompt or SetPrompt, or returned by PromptFunc last.
+func (t *Terminal) GetPrompt() string {
+	return t.prompt.Load().(string)
+}
+
+// SetPrompt sets the prompt of ReadLine, and updates the displayed prompt if a line is being read. PromptFunc takes
+// precedence over it in the next read. It's safe for concurrent use.
+func (t *Terminal) SetPrompt(prompt string) {
+	old := t.prompt.Load().(string)
+	t.prompt.Store(prompt)
+	// keep the prefix of the displayed prompt like the vi mode indicator
+	if current := t.rb.Prompt(); !t.rb.NoEcho() && strings.HasSuffix(current, old) {
+		t.rb.SetPrompt(current[:len(current)-len(old)] + prompt)
+	}
+}
+
+// GetMask returns the rune which is displayed instead of the typed runes, or 0 if they are displayed.
+func (t *Terminal) GetMask() rune {
+	return t.rb.Mask()
+}
+
+// SetMask sets the rune which is displayed instead of the typed runes, the typed runes are displayed if mask is 0.
+// It's safe for concurrent use.
+func (t *Terminal) SetMask(mask rune) {
+	t.rb.SetMask(mask)
+}
+
+// ForceRefresh redraws the prompt with the line being edited, like after the hint or the right prompt is changed in
+// another goroutine. It's safe for concurrent use.
+func (t *Terminal) ForceRefresh() error {
+	return t.rb.Redraw()
+}
+
+// Recover prints the prompt with the line being edited again, unlike ForceRefresh without erasing the previous
+// ones, like after an external command has cleared the screen. It's safe for concurrent use.
+func (t *Terminal) Recover() error {
+	return t.rb.Recover()
+}
+
+// Bell rings the bell. It's safe for concurrent use.
+func (t *Terminal) Bell() error {
+	return t.rb.Bell()
+}
+
+// ClearScreen clears the screen, and redraws the prompt with the line being edited at the top. It's safe for
+// concurrent use.
+func (t *Terminal) ClearScreen() error {
+	return t.rb.ClearScreen()
+}
+
+// PasteFromClipboard inserts the text from the clipboard into the buffer. It reads the text with
+// Config.ClipboardReader, or ReadClipboard if it's nil. The newlines are replaced with spaces unless MultiLine is true.
+// It returns ErrReadOnly if the line is read-only.
+func (t *Terminal) PasteFromClipboard() error {
+	read := t.config.ClipboardReader
+	if read == nil {
+		read = ReadClipboard
+	}
+	if t.rb.IsReadOnly() {
+		return ErrReadOnly
+	}
+	p, err := read()
+	if err != nil {
+		return err
+	}
+	if len(p) > MaxClipboardSize {
+		return fmt.Errorf("%w: %d bytes", ErrClipboardTooLarge, len(p))
+	}
+	t.rb.WriteBytes(clipboardText(p, t.config.MultiLine))
+	return nil
+}
+
 func (t *Terminal) StdinWriter() io.Writer {
 	return t.stdinWriter
 }
 
 func (t *Terminal) Write(p []byte) (int, error) {
-	return t.config.Stdout.Write(p)
+	return t.output.Write(p)
+}
+
+// WriteOutput writes p above the line being edited, and redraws the prompt with the buffer. p is just written if
+// the terminal isn't interactive. p should end with a newline. It is safe to call concurrently with ReadLine.
+func (t *Terminal) WriteOutput(p []byte) (int, error) {
+	return t.rb.WriteAbove(p)
+}
+
+// SafeStdout returns the writer which writes by WriteOutput, so the line being edited isn't corrupted by the output.
+// It can be used like log.SetOutput(t.SafeStdout()), and every write should end with a newline.
+func (t *Terminal) SafeStdout() io.Writer {
+	return outputWriter{t}
+}
+
+// outputWriter is the writer of SafeStdout.
+type outputWriter struct {
+	t *Terminal
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	return w.t.WriteOutput(p)
+}
+
+// Println formats its arguments like fmt.Println, and writes the line by WriteOutput.
+func (t *Terminal) Println(a ...interface{}) (int, error) {
+	return t.WriteOutput([]byte(fmt.Sprintln(a...)))
+}
+
+// Printf formats its arguments like fmt.Printf, and writes the line by WriteOutput.
+// A newline is appended if the formatted line doesn't end with it.
+func (t *Terminal) Printf(format string, a ...interface{}) (int, error) {
+	return t.WriteOutput([]byte(sprintfLine(format, a...)))
+}
+
+// sprintfLine formats its arguments like fmt.Sprintf, and appends a newline if the result doesn't end with it.
+func sprintfLine(format string, a ...interface{}) string {
+	s := fmt.Sprintf(format, a...)
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return s
 }
 
 // WriteStdin prefill the next Stdin fetch
 // Next time you call ReadLine() this value will be writen before the user input
+// A rune may be split across the calls, its bytes are decoded together once they're all written.
 func (t *Terminal) WriteStdin(p []byte) (int, error) {
+	if t.isClosed() {
+		return 0, ErrClosed
+	}
 	return t.stdinWriter.Write(p)
 }
 
 func (t *Terminal) EnterRawMode() error {
+	if t.isClosed() {
+		return ErrClosed
+	}
 	t.lckr.Lock()
 	defer t.lckr.Unlock()
 	return t.enterRawMode()
 }
 
+// isClosed reports whether Close is called.
+func (t *Terminal) isClosed() bool {
+	return atomic.LoadInt32(&t.closed) != 0
+}
+
 func (t *Terminal) enterRawMode() error {
 	var err error
+	t.rawMu.Lock()
+	defer t.rawMu.Unlock()
 	if t.oldState != nil {
 		return ErrAlreadyInRawMode
 	}
+	if t.stdin < 0 {
+		return ErrNotTerminal
+	}
 	t.oldState, err = SetRawMode(t.stdin)
 	if err != nil {
 		return err
 	}
+	t.writeModes(true)
 	return nil
 }
 
@@ -136,35 +431,107 @@ func (t *Terminal) ExitRawMode() error {
 }
 
 func (t *Terminal) exitRawMode() error {
+	t.rawMu.Lock()
+	defer t.rawMu.Unlock()
 	if t.oldState == nil {
 		return ErrNotInRawMode
 	}
-	if err := RestoreState(t.stdin, t.oldState); err != nil {
-		return err
+	if !t.suspended {
+		t.writeModes(false)
+		if err := RestoreState(t.stdin, t.oldState); err != nil {
+			return err
+		}
 	}
 	t.oldState = nil
+	t.suspended = false
 	return nil
 }
 
+// writeModes writes the sequences which enable or disable the terminal modes of the raw mode, like the bracketed
+// paste mode.
+func (t *Terminal) writeModes(enable bool) {
+	if enable {
+		t.writeCursorShape()
+	} else if t.config.InsertModeCursorShape != "" || t.config.OverwriteModeCursorShape != "" {
+		_ = t.rb.WriteSequence([]byte("\033[0 q"))
+	}
+	if t.config.BracketedPaste {
+		if enable {
+			t.write(t.passthrough([]byte("\033[?2004h")))
+		} else {
+			t.write(t.passthrough([]byte("\033[?2004l")))
+		}
+	}
+	if t.config.EnableModifyOtherKeys {
+		if enable {
+			t.write([]byte("\033[>4;2m"))
+		} else {
+			t.write([]byte("\033[>4;0m"))
+		}
+	}
+}
+
+// suspend restores the terminal state and suspends the process by SIGTSTP. The raw mode is entered again by resume
+// when the process is continued.
+func (t *Terminal) suspend() {
+	t.rawMu.Lock()
+	if t.oldState == nil || t.suspended {
+		t.rawMu.Unlock()
+		return
+	}
+	t.rb.Clean()
+	t.writeModes(false)
+	_ = RestoreState(t.stdin, t.oldState)
+	t.suspended = true
+	t.rawMu.Unlock()
+	if err := suspendProcess(); err != nil {
+		t.resume()
+		t.bell()
+	}
+}
+
+// resume enters the raw mode again and redraws the line if the terminal is suspended by suspend.
+func (t *Terminal) resume() {
+	t.rawMu.Lock()
+	if !t.suspended {
+		t.rawMu.Unlock()
+		return
+	}
+	t.suspended = false
+	_, _ = SetRawMode(t.stdin)
+	t.writeModes(true)
+	t.rawMu.Unlock()
+	t.rb.Refresh(nil)
+}
+
 func (t *Terminal) GetSize() (int, int, error) {
-	cols, rows, err := GetSize(t.stdout)
-	if err != nil {
+	cols, rows, err := 0, 0, ErrNotTerminal
+	if t.stdout >= 0 {
+		cols, rows, err = GetSize(t.stdout)
+	}
+	if err != nil && t.stderr >= 0 {
 		cols, rows, err = GetSize(t.stderr)
 	}
 	return cols, rows, err
 }
 
 func (t *Terminal) GetWidth() int {
-	w := GetWidth(t.stdout)
-	if w < 0 {
+	w := -1
+	if t.stdout >= 0 {
+		w = GetWidth(t.stdout)
+	}
+	if w < 0 && t.stderr >= 0 {
 		w = GetWidth(t.stderr)
 	}
 	return w
 }
 
 func (t *Terminal) GetHeight() int {
-	h := GetHeight(t.stdout)
-	if h < 0 {
+	h := -1
+	if t.stdout >= 0 {
+		h = GetHeight(t.stdout)
+	}
+	if h < 0 && t.stderr >= 0 {
 		h = GetHeight(t.stderr)
 	}
 	return h
@@ -175,27 +542,120 @@ func (t *Terminal) ReadBytes() ([]byte, error) {
 }
 
 func (t *Terminal) ReadBytesContext(ctx context.Context) (line []byte, err error) {
+	line, _, err = t.readBytes(ctx, t.linePrompt, false, false)
+	return
+}
+
+// ReadBytesContextPartial is like ReadBytesContext, but it returns the partially typed line as partial if ctx is done
+// before reading a line. The partial line is discarded from the buffer and cleaned from the screen then.
+func (t *Terminal) ReadBytesContextPartial(ctx context.Context) (line []byte, partial []byte, err error) {
+	return t.readBytes(ctx, t.linePrompt, false, true)
+}
+
+// readBytes reads a line with the prompt returned by prompt. The typed characters aren't displayed if noEcho is true.
+// If discard is true and ctx is done before reading a line, the buffer is discarded and returned as partial.
+func (t *Terminal) readBytes(ctx context.Context, prompt func() string, noEcho, discard bool) (line []byte, partial []byte, err error) {
+	if t.isClosed() {
+		return nil, nil, ErrClosed
+	}
 	err = t.lckr.LockContext(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer t.lckr.Unlock()
+	// the line accepted before the read is returned even if ioloop has exited after it
+	select {
+	case c := <-t.lineResultCh:
+		return c.Line, nil, c.Err
+	default:
+	}
 	ioErr := t.ioErr.Load()
 	if ioErr != nil {
-		return nil, ioErr.(error)
+		return nil, nil, ioErr.(error)
 	}
-	err = t.enterRawMode()
-	if err != nil {
-		return nil, err
+	if t.isTerminal {
+		err = t.enterRawMode()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer t.exitRawMode()
+	}
+	if key, value := t.config.HistoryContextKey, t.config.HistoryContextValue; key != nil && value != nil {
+		if v := ctx.Value(key); v != nil {
+			value = v
+		}
+		t.history.setTag(value)
+	}
+	if t.config.HistoryAutoRefresh {
+		t.historySync()
+	}
+	t.rb.SetNoEcho(noEcho)
+	if p := prompt(); p != t.rb.Prompt() {
+		t.rb.SetPrompt(p)
+	}
+	if rest := t.unitRest; len(rest) > 0 {
+		// the rest of the unit which is partly read by ReadByte is processed by the line editing
+		select {
+		case t.lineReqCh <- rest:
+			t.unitRest = nil
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-t.ioloopDoneCh:
+			return nil, nil, t.ioErr.Load().(error)
+		}
+	} else {
+		select {
+		case t.lineReqCh <- nil:
+		default:
+		}
 	}
-	defer t.exitRawMode()
-	t.rb.Refresh(nil)
 	select {
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		if !discard {
+			return nil, nil, ctx.Err()
+		}
+		select {
+		case c := <-t.lineResultCh:
+			return c.Line, nil, c.Err
+		default:
+		}
+		return nil, []byte(string(t.rb.Discard())), ctx.Err()
 	case c := <-t.lineResultCh:
-		return c.Line, c.Err
+		return c.Line, nil, c.Err
+	case <-t.ioloopDoneCh:
+		select {
+		case c := <-t.lineResultCh:
+			return c.Line, nil, c.Err
+		default:
+		}
+		return nil, nil, t.ioErr.Load().(error)
+	}
+}
+
+// linePrompt returns the prompt of ReadLine. PromptFunc is called if it isn't nil.
+func (t *Terminal) linePrompt() string {
+	if t.config.PromptFunc != nil {
+		t.prompt.Store(t.config.PromptFunc())
+	}
+	prompt := t.prompt.Load().(string)
+	if t.config.VimMode && t.config.VimModeIndicator != nil {
+		prompt = t.config.VimModeIndicator(false) + prompt
 	}
+	return prompt
+}
+
+// ReadPassword reads a line with prompt without displaying the typed characters.
+// The line isn't added to the history.
+func (t *Terminal) ReadPassword(prompt string) (string, error) {
+	return t.ReadPasswordContext(context.Background(), prompt)
+}
+
+// ReadPasswordContext is like ReadPassword, but it returns the error of ctx if ctx is done before reading a line.
+func (t *Terminal) ReadPasswordContext(ctx context.Context, prompt string) (string, error) {
+	p, _, err := t.readBytes(ctx, func() string {
+		return prompt
+	}, true, false)
+	return string(p), err
 }
 
 func (t *Terminal) ReadString() (string, error) {
@@ -215,12 +675,119 @@ func (t *Terminal) ReadLineContext(ctx context.Context) (string, error) {
 	return t.ReadStringContext(ctx)
 }
 
+// ReadRune reads a single rune without the line editing. It returns the size of the rune too, unlike a plain
+// (rune, error) reader, so it implements io.RuneReader.
+func (t *Terminal) ReadRune() (r rune, size int, err error) {
+	return t.ReadRuneContext(context.Background())
+}
+
+// ReadRuneContext is like ReadRune, but it returns the error of ctx if ctx is done before reading a rune.
+// The escape sequences aren't decoded, so a key like an arrow key is read as multiple runes. The input after the rune
+// isn't processed by the line editing until the next read.
+func (t *Terminal) ReadRuneContext(ctx context.Context) (r rune, size int, err error) {
+	if t.isClosed() {
+		return 0, 0, ErrClosed
+	}
+	err = t.lckr.LockContext(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer t.lckr.Unlock()
+	p := t.unitRest
+	if len(p) <= 0 {
+		p, err = t.readUnit(ctx)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	r, size = utf8.DecodeRune(p)
+	t.unitRest = p[size:]
+	return r, size, nil
+}
+
+// ReadByte reads a single byte without the line editing. It implements io.ByteReader. The rest of a multi-byte
+// rune is read by the next ReadByte or ReadRune, or it's processed by the line editing of the next line read.
+func (t *Terminal) ReadByte() (byte, error) {
+	return t.ReadByteContext(context.Background())
+}
+
+// ReadByteContext is like ReadByte, but it returns the error of ctx if ctx is done before reading a byte.
+func (t *Terminal) ReadByteContext(ctx context.Context) (b byte, err error) {
+	if t.isClosed() {
+		return 0, ErrClosed
+	}
+	err = t.lckr.LockContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer t.lckr.Unlock()
+	p := t.unitRest
+	if len(p) <= 0 {
+		p, err = t.readUnit(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+	t.unitRest = p[1:]
+	return p[0], nil
+}
+
+// readUnit requests the next input unit from the input loop, so it isn't processed by the line editing. The raw mode
+// is entered while reading if it isn't already entered. t.lckr must be locked by the caller.
+func (t *Terminal) readUnit(ctx context.Context) ([]byte, error) {
+	ioErr := t.ioErr.Load()
+	if ioErr != nil {
+		return nil, ioErr.(error)
+	}
+	if t.isTerminal {
+		switch err := t.enterRawMode(); err {
+		case nil:
+			defer t.exitRawMode()
+		case ErrAlreadyInRawMode:
+		default:
+			return nil, err
+		}
+	}
+	ch := make(chan inputUnit)
+	select {
+	case t.unitReqCh <- unitRequest{ch: ch, done: ctx.Done()}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.ioloopDoneCh:
+		return nil, t.ioErr.Load().(error)
+	}
+	select {
+	case u := <-ch:
+		return u.p, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.ioloopDoneCh:
+		return nil, t.ioErr.Load().(error)
+	}
+}
+
 func (t *Terminal) ioloop() {
 	defer t.wg.Done()
 
-	br := bufio.NewReader(t.stdinReader)
 	escaped := false
 	escBuf := make([]byte, 0, 16)
+	// escTimer expires if an escape sequence isn't completed in EscapeTimeout
+	var escTimer *time.Timer
+	// runeBuf holds the bytes of a rune which is read byte by byte while the escape sequence has timed out
+	var runeBuf []byte
+	pendingCtrlX := false
+
+	reqCh := make(chan bool, 1)
+	unitCh := make(chan inputUnit)
+	t.wg.Add(1)
+	go t.readloop(bufio.NewReader(t.stdinReader), reqCh, unitCh)
+	defer close(reqCh)
+	reading := false
+	// holding is true after a unit is read by ReadRune or ReadByte, and the next unit isn't read until the next read
+	holding := false
+	var unitReq *unitRequest
+	// unitRest is the rest of the unit which is partly read by ReadByte, it's processed before the next unit
+	var unitRest []byte
 
 	var err error
 	for err == nil {
@@ -228,9 +795,68 @@ func (t *Terminal) ioloop() {
 		if err != nil {
 			continue
 		}
-		var b byte
-		var p []byte
-		b, err = br.ReadByte()
+		if !reading && !holding {
+			reqCh <- escaped || len(runeBuf) > 0
+			reading = true
+		}
+		var escTimeoutCh <-chan time.Time
+		if escaped && escTimer != nil {
+			escTimeoutCh = escTimer.C
+		}
+		var u inputUnit
+		if len(unitRest) > 0 {
+			_, size := utf8.DecodeRune(unitRest)
+			u = inputUnit{b: unitRest[0], p: unitRest[:size]}
+			unitRest = unitRest[size:]
+		} else {
+			select {
+			case <-t.ctx.Done():
+				continue
+			case <-escTimeoutCh:
+				escaped = false
+				t.traceEvent("escape", 0, 0)
+				t.escapeTimeout(escBuf)
+				continue
+			case req := <-t.unitReqCh:
+				unitReq = &req
+				holding = false
+				continue
+			case rest := <-t.lineReqCh:
+				unitRest = append(unitRest, rest...)
+				holding = false
+				continue
+			case candidates, ok := <-t.asyncCompletionCh():
+				t.asyncCompletionDone(candidates, ok)
+				continue
+			case <-t.hintDebounceCh():
+				t.hintDebounceDone()
+				continue
+			case u = <-unitCh:
+				reading = false
+			}
+		}
+		if u.err == nil && !escaped && (len(runeBuf) > 0 || u.b >= utf8.RuneSelf && !utf8.FullRune(u.p)) {
+			runeBuf = append(runeBuf, u.p...)
+			if !utf8.FullRune(runeBuf) {
+				continue
+			}
+			u.b, u.p, runeBuf = runeBuf[0], runeBuf, nil
+		}
+		if unitReq != nil && !escaped && u.err == nil {
+			// the unit is read by ReadRune or ReadByte, unless it has given up
+			req := unitReq
+			unitReq = nil
+			select {
+			case req.ch <- u:
+				t.traceInput(u.p)
+				t.traceEvent("read", 0, 0)
+				holding = true
+				continue
+			case <-req.done:
+			}
+		}
+		b, p := u.b, u.p
+		err = u.err
 		if err != nil {
 			if isInterruptedSyscall(err) {
 				err = nil
@@ -239,17 +865,40 @@ func (t *Terminal) ioloop() {
 			}
 			continue
 		}
-		if b >= utf8.RuneSelf && !escaped {
-			_ = br.UnreadByte()
-			var r rune
-			r, _, err = br.ReadRune()
-			if err != nil {
-				continue
+		t.asyncCompletionCancel()
+
+		if pendingCtrlX {
+			pendingCtrlX = false
+			t.traceInput(p)
+			t.traceEvent("key", 0, 0)
+			t.ctrlXKey(p)
+			continue
+		}
+		if b == CharCtrlX && !escaped && !t.pasting {
+			pendingCtrlX = true
+			t.traceInput(p)
+			t.traceEvent("key", 0, 0)
+			continue
+		}
+		t.traceInput(p)
+		t.macroInput(p)
+
+		if t.completion != nil && !t.completion.menu && b != CharTab {
+			t.completionExit()
+		}
+
+		if b == CharEscape && !escaped && t.search != nil {
+			t.searchExit(true)
+		}
+
+		if escaped && len(escBuf) == 0 && t.config.VimMode && p[0] != '[' && p[0] != 'O' {
+			// bare escape in vi mode, p is processed in the normal mode
+			escaped = false
+			t.traceEvent("escape", 0, len(p))
+			if t.completion != nil {
+				t.completionExit()
 			}
-			var utf8Array [utf8.UTFMax]byte
-			p = utf8Array[:utf8.EncodeRune(utf8Array[:], r)]
-		} else {
-			p = []byte{b}
+			t.viEnterNormal()
 		}
 
 		if b == CharEscape || escaped {
@@ -260,11 +909,18 @@ func (t *Terminal) ioloop() {
 				escBuf = append(escBuf, p...)
 			}
 			escKeyPair := decodeEscapeKeyPair(escBuf)
-			if escKeyPair != nil && t.escape(escKeyPair) {
+			if escKeyPair != nil && t.escape(escBuf, escKeyPair) {
 				escaped = false
 				p = escKeyPair.Remainder
+				t.traceEvent("escape", escKeyPair.finalChar(), len(p))
 			} else {
 				if len(escBuf) < cap(escBuf) {
+					if escTimer != nil {
+						escTimer.Stop()
+					}
+					if timeout := t.escapeTimeoutDuration(); timeout > 0 {
+						escTimer = time.NewTimer(timeout)
+					}
 					continue
 				}
 				escaped = false
@@ -275,108 +931,216 @@ func (t *Terminal) ioloop() {
 		if len(p) <= 0 {
 			continue
 		}
+		t.traceEvent("key", 0, 0)
 
-		switch p[0] {
-		case CharLineStart:
-			t.opLineStart()
-
-		case CharBackward:
-			t.opBackward()
-
-		case CharInterrupt:
-			err = ErrInterrupted
-
-		case CharDelete:
-			err = io.EOF
+		if t.pasting {
+			t.pasteBuf = append(t.pasteBuf, p...)
+			continue
+		}
 
-		case CharLineEnd:
-			t.opLineEnd()
+		if p = t.keyPress(p); len(p) <= 0 {
+			continue
+		}
 
-		case CharForward:
-			t.opForward()
+		if t.completion != nil && t.completion.menu {
+			if t.completionMenuKey(KeyEvent(p)) {
+				continue
+			}
+			t.completionExit()
+		}
 
-		case CharBell:
-			t.bell()
+		if t.interruptConfirming {
+			if t.interruptConfirmKey(p) {
+				err = ErrInterrupted
+			}
+			continue
+		}
 
-		case CharBackspace, CharBackspaceEx:
-			t.opBackspace()
+		if t.search != nil && t.searchKey(p) {
+			continue
+		}
 
-		case CharTab:
-			t.opTab()
+		if t.grep != nil && t.grepKey(KeyEvent(p)) {
+			continue
+		}
 
-		case CharFeed, CharReturn:
-			t.opReturn()
+		if t.vi.mode == viNormalMode && t.viNormalKey(p) {
+			continue
+		}
 
-		case CharKill:
-			t.opKill()
+		if fn := t.keyHandler(KeyEvent(p)); fn != nil {
+			t.callKeyHandler(fn)
+			continue
+		}
 
-		case CharClear:
-			t.opClear()
+		switch p[0] {
+		case CharInterrupt:
+			if t.confirmInterrupt() {
+				err = ErrInterrupted
+			}
 
-		case CharNext:
-			t.opNext()
+		case CharSuspend:
+			t.suspend()
 
-		case CharPrev:
-			t.opPrev()
+		case CharDelete:
+			if t.rb.Len() > 0 {
+				t.opDelete()
+				break
+			}
+			err = io.EOF
 
-		case CharBckSearch:
-			t.opBckSearch()
+		default:
+			s := string(encodeControlChars(p))
+			if t.numericArgSet && !t.numericArgNeg && t.numericArg > 1 {
+				// the repeated runes are inserted at once, so they're undone at once
+				s = strings.Repeat(s, t.numericArg)
+			}
+			t.insertText(s)
 
-		case CharFwdSearch:
-			t.opFwdSearch()
+		}
+		t.numericArgReset()
+	}
 
-		case CharTranspose:
-			t.opTranspose()
+	if xcontext.IsContextError(err) {
+		err = io.EOF
+	}
+	t.ioErr.Store(err)
+	// the error isn't sent if an accepted line is pending, it's returned by the read after the line by ioErr
+	select {
+	case t.lineResultCh <- lineResult{Line: t.rb.Bytes(), Err: err}:
+	default:
+	}
+	close(t.ioloopDoneCh)
+}
 
-		case CharKillFront:
-			t.opKillFront()
+// sizeloop updates the screen size when the registered screenSizeChangedCh is notified.
+func (t *Terminal) sizeloop() {
+	defer t.wg.Done()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-t.screenSizeChangedCh:
+			width, height, err := t.GetSize()
+			if err == nil {
+				t.screenSizeChanged(width, height)
+			}
+		}
+	}
+}
 
-		case CharKillWordFront:
-			t.opKillWordFront()
+// inputUnit is a byte or a whole rune which is read from the stdin.
+type inputUnit struct {
+	b   byte
+	p   []byte
+	err error
+}
 
-		case CharYank:
-			t.opYank()
+// unitRequest is a request of the next input unit. The unit is sent to ch unless done is closed.
+type unitRequest struct {
+	ch   chan<- inputUnit
+	done <-chan struct{}
+}
 
-		default:
-			p = encodeControlChars(p)
-			if !t.ioInsMode {
-				t.rb.WriteBytes(p)
+// readloop reads an input unit from br for each request from reqCh, and sends it to unitCh. A request is true while
+// an escape sequence is being read, and the bytes of a multi-byte rune are read one by one then.
+func (t *Terminal) readloop(br *bufio.Reader, reqCh <-chan bool, unitCh chan<- inputUnit) {
+	defer t.wg.Done()
+	for escaped := range reqCh {
+		var u inputUnit
+		u.b, u.err = br.ReadByte()
+		if u.err == nil {
+			if u.b >= utf8.RuneSelf && !escaped {
+				_ = br.UnreadByte()
+				var r rune
+				r, _, u.err = br.ReadRune()
+				u.p = []byte(string(r))
 			} else {
-				t.rb.InsertBytes(p)
+				u.p = []byte{u.b}
 			}
-
+		}
+		select {
+		case unitCh <- u:
+		case <-t.ctx.Done():
+			return
 		}
 	}
+}
 
-	if xcontext.IsContextError(err) {
-		err = io.EOF
+// escapeTimeoutDuration returns EscapeTimeout, or DefaultEscapeTimeout if it's zero.
+func (t *Terminal) escapeTimeoutDuration() time.Duration {
+	if t.config.EscapeTimeout == 0 {
+		return DefaultEscapeTimeout
 	}
-	t.ioErr.Store(err)
-	t.sendLineResult(t.rb.Bytes(), err)
+	return t.config.EscapeTimeout
 }
 
-func (t *Terminal) escape(escKeyPair *escapeKeyPair) bool {
-	switch escKeyPair.Char {
-	case CharBackspace, CharBackspaceEx:
-		t.opKillWordFront()
+// escapeTimeout processes the escape which isn't completed in EscapeTimeout. A bare escape enters the vi normal mode,
+// or it's processed like the other keys. An incomplete escape sequence is discarded.
+func (t *Terminal) escapeTimeout(escBuf []byte) {
+	if len(escBuf) > 0 {
+		t.bell()
+		return
+	}
+	if t.completion != nil {
+		t.completionExit()
+	}
+	if t.config.VimMode {
+		t.viEnterNormal()
+		return
+	}
+	p := t.keyPress([]byte{CharEscape})
+	if len(p) <= 0 {
+		return
+	}
+	if fn := t.keyHandler(KeyEvent(p)); fn != nil {
+		t.callKeyHandler(fn)
+		t.numericArgReset()
+	}
+}
 
-	case CharTranspose:
-		t.opTranspose()
+func (t *Terminal) escape(escBuf []byte, escKeyPair *escapeKeyPair) bool {
+	if (escKeyPair.Char != 'O' && escKeyPair.Char != '[') || escKeyPair.Type != '\x00' {
+		key, _ := ParseBytes(append([]byte{CharEscape}, escBuf[:len(escBuf)-len(escKeyPair.Remainder)]...))
+		if len(key) > 0 && key[0] != CharEscape {
+			// a modified key which is translated into a rune or a control character is processed like it's typed
+			escKeyPair.Remainder = append([]byte(key), escKeyPair.Remainder...)
+			return true
+		}
+		p := t.keyPress([]byte(key))
+		if len(p) <= 0 {
+			return true
+		}
+		key = KeyEvent(p)
+		if t.interruptConfirming {
+			// the escape keys cancel the interrupt
+			t.interruptConfirmKey(p)
+			return true
+		}
+		if t.completion != nil && t.completion.menu {
+			if t.completionMenuKey(key) {
+				return true
+			}
+			t.completionExit()
+		}
+		if t.grep != nil && t.grepKey(key) {
+			return true
+		}
+		if fn := t.keyHandler(key); fn != nil {
+			t.callKeyHandler(fn)
+			return true
+		}
+	}
 
+	switch escKeyPair.Char {
 	case CharEscape:
 
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		t.numericArgKey(escKeyPair.Char)
+
 	case 'O', '[':
 		return t.escapeEx(escKeyPair)
 
-	case 'b':
-		t.opBackwardWord()
-
-	case 'd':
-		t.opKillWord()
-
-	case 'f':
-		t.opForwardWord()
-
 	default:
 		t.bell()
 
@@ -391,35 +1155,19 @@ func (t *Terminal) escapeEx(escKeyPair *escapeKeyPair) bool {
 		return false
 
 	case '~':
-		t.escapeTilda(escKeyPair)
-		return true
-
-	case 'R':
-		t.escapeR(escKeyPair)
-		return true
-
-	default:
-		if escKeyPair.Attribute <= 0 && escKeyPair.Attribute2 < 0 {
-			switch escKeyPair.Type {
-			case 'A':
-				t.opPrev()
-
-			case 'B':
-				t.opNext()
+		if escKeyPair.Attribute2 < 0 {
+			switch escKeyPair.Attribute {
+			case 200:
+				t.pasteStart()
 
-			case 'C':
-				t.opForward()
+			case 201:
+				t.pasteEnd()
 
-			case 'D':
-				t.opBackward()
+			case 5:
+				// pageup
 
-			//case 'E':
-
-			case 'F':
-				t.opLineEnd()
-
-			case 'H':
-				t.opLineStart()
+			case 6:
+				// pagedown
 
 			default:
 				t.bell()
@@ -429,44 +1177,45 @@ func (t *Terminal) escapeEx(escKeyPair *escapeKeyPair) bool {
 			t.bell()
 		}
 
+	case 'R':
+		t.escapeR(escKeyPair)
+
+	default:
+		t.bell()
+
 	}
 
 	return true
 }
 
-func (t *Terminal) escapeTilda(escKeyPair *escapeKeyPair) {
-	if escKeyPair.Attribute2 < 0 {
-		switch escKeyPair.Attribute {
-		case 1:
-			t.opLineStart()
-
-		case 2:
-			t.ioInsMode = !t.ioInsMode
-
-		case 3:
-			t.opDelete()
-
-		case 4:
-			t.opLineEnd()
-
-		case 5:
-			// pageup
-
-		case 6:
-			// pagedown
-
-		case 7:
-			t.opLineStart()
-
-		case 8:
-			t.opLineEnd()
+// pasteStart starts to accumulate the pasted text until the end of the bracketed paste.
+func (t *Terminal) pasteStart() {
+	t.pasting = true
+	t.pasteBuf = t.pasteBuf[:0]
+}
 
-		default:
-			t.bell()
+// pasteEnd inserts the accumulated pasted text into the buffer at once.
+func (t *Terminal) pasteEnd() {
+	if !t.pasting {
+		return
+	}
+	t.pasting = false
+	s := strings.ReplaceAll(string(t.pasteBuf), "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	t.insertText(s)
+}
 
-		}
-	} else {
+// insertText inserts s at the cursor, or writes it over the runes at the cursor in the overwrite mode. It rings
+// the bell if the buffer is read-only.
+func (t *Terminal) insertText(s string) {
+	if t.rb.IsReadOnly() {
 		t.bell()
+		return
+	}
+	if !t.overwriteMode() {
+		t.rb.WriteString(s)
+	} else {
+		t.rb.InsertString(s)
 	}
 }
 
@@ -478,6 +1227,8 @@ func (t *Terminal) escapeR(escKeyPair *escapeKeyPair) {
 	}
 }
 
+// sendLineResult sends the accepted line to the next read. At most one line is kept until it's read, so it blocks
+// while the previous one isn't read yet, and the input typed ahead of the reads isn't processed until then.
 func (t *Terminal) sendLineResult(line []byte, e error) {
 	r := lineResult{
 		Line: line,
@@ -485,20 +1236,55 @@ func (t *Terminal) sendLineResult(line []byte, e error) {
 	}
 	select {
 	case t.lineResultCh <- r:
-	default:
+	case <-t.ctx.Done():
 	}
 }
 
+// confirmInterrupt returns true if the line should be interrupted by Ctrl+C. The bell rings if ConfirmInterrupt
+// returns false, and ConfirmInterruptPrompt is displayed to confirm it by the next key if it's set.
+func (t *Terminal) confirmInterrupt() bool {
+	if t.config.ConfirmInterrupt != nil && !t.config.ConfirmInterrupt(t.rb.String()) {
+		t.bell()
+		return false
+	}
+	if t.config.ConfirmInterruptPrompt != "" {
+		t.interruptConfirming = true
+		t.interruptPrompt = t.rb.Prompt()
+		t.rb.SetPrompt(t.config.ConfirmInterruptPrompt)
+		return false
+	}
+	return true
+}
+
+// interruptConfirmKey restores the prompt which is replaced by ConfirmInterruptPrompt, and returns true if p confirms
+// the interrupt.
+func (t *Terminal) interruptConfirmKey(p []byte) bool {
+	t.interruptConfirming = false
+	t.rb.SetPrompt(t.interruptPrompt)
+	return len(p) == 1 && (p[0] == 'y' || p[0] == 'Y' || p[0] == CharInterrupt)
+}
+
 func (t *Terminal) screenSizeChanged(width, height int) {
+	if height > 0 {
+		_ = t.rb.SetScreenHeight(height)
+	}
 	_ = t.rb.SetScreenWidth(width)
 }
 
+// ScreenHeight returns the last known height of the screen. It returns -1 if it's unknown.
+func (t *Terminal) ScreenHeight() int {
+	if h := t.rb.ScreenHeight(); h > 0 {
+		return h
+	}
+	return -1
+}
+
 func (t *Terminal) write(p []byte) {
 	_, _ = t.Write(p)
 }
 
 func (t *Terminal) bell() {
-	t.write([]byte{CharBell})
+	_ = t.rb.Bell()
 }
 
 func (t *Terminal) opLineStart() {
@@ -507,18 +1293,106 @@ func (t *Terminal) opLineStart() {
 	}
 }
 
-func (t *Terminal) opBackward() {
-	if !t.rb.MoveBackward() {
-		t.bell()
+// keyPress returns the key which OnKeyPress returns for p, or p if OnKeyPress isn't set or it times out.
+func (t *Terminal) keyPress(p []byte) []byte {
+	if t.config.OnKeyPress == nil {
+		return p
+	}
+	var result []byte
+	key := append([]byte(nil), p...)
+	if !t.callback(func() {
+		result = t.config.OnKeyPress(key)
+	}) {
+		return p
 	}
+	return result
 }
 
-func (t *Terminal) opDelete() {
-	if !t.rb.Delete() {
+// callback calls f, and waits for it until CallbackTimeout if it's positive. It returns false if f times out. f isn't
+// called and it returns false if the last callback which timed out is still running, so the callbacks don't run
+// concurrently.
+func (t *Terminal) callback(f func()) bool {
+	if t.config.CallbackTimeout <= 0 {
+		f()
+		return true
+	}
+	if t.callbackDone != nil {
+		select {
+		case <-t.callbackDone:
+		default:
+			return false
+		}
+	}
+	done := make(chan struct{})
+	t.callbackDone = done
+	go func() {
+		defer close(done)
+		f()
+	}()
+	timer := time.NewTimer(t.config.CallbackTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// callKeyHandler calls the key handler fn, and resets the numeric argument after it.
+func (t *Terminal) callKeyHandler(fn func(*Terminal)) {
+	fn(t)
+	t.numericArgReset()
+}
+
+// numericArgKey accumulates the numeric argument by r which is a digit or '-' typed with Meta.
+func (t *Terminal) numericArgKey(r rune) {
+	if !t.numericArgSet {
+		t.numericArg, t.numericArgSet, t.numericArgNeg = 0, true, false
+	}
+	switch {
+	case r == '-' && t.numericArg == 0 && !t.numericArgNeg:
+		t.numericArgNeg = true
+	case r >= '0' && r <= '9' && t.numericArg < maxNumericArg:
+		t.numericArg = t.numericArg*10 + int(r-'0')
+	default:
 		t.bell()
 	}
 }
 
+func (t *Terminal) numericArgReset() {
+	t.numericArg, t.numericArgSet, t.numericArgNeg = 1, false, false
+}
+
+// repeat calls f as many times as the numeric argument, or calls g instead if the numeric argument is negative.
+// It rings the bell if f or g fails.
+func (t *Terminal) repeat(f, g func() bool) {
+	n := t.numericArg
+	if t.numericArgNeg {
+		n = -n
+		if n == 0 {
+			n = -1
+		}
+	}
+	if n < 0 {
+		f, n = g, -n
+	}
+	for i := 0; i < n; i++ {
+		if !f() {
+			t.bell()
+			return
+		}
+	}
+}
+
+func (t *Terminal) opBackward() {
+	t.repeat(t.rb.MoveBackward, t.rb.MoveForward)
+}
+
+func (t *Terminal) opDelete() {
+	t.repeat(t.rb.Delete, t.rb.Backspace)
+}
+
 func (t *Terminal) opLineEnd() {
 	if !t.rb.MoveToLineEnd() {
 		t.bell()
@@ -526,34 +1400,135 @@ func (t *Terminal) opLineEnd() {
 }
 
 func (t *Terminal) opForward() {
-	if !t.rb.MoveForward() {
-		t.bell()
+	if !t.numericArgSet && t.rb.IsCursorInEnd() && t.rb.AcceptHint() {
+		return
 	}
+	t.repeat(t.rb.MoveForward, t.rb.MoveBackward)
 }
 
 func (t *Terminal) opBackspace() {
-	if !t.rb.Backspace() {
-		t.bell()
-	}
+	t.repeat(t.rb.Backspace, t.rb.Delete)
 }
 
 func (t *Terminal) opTab() {
-	t.bell()
+	t.completionTab()
+}
+
+func (t *Terminal) opInsertKey() {
+	if t.vi.mode == viNormalMode {
+		t.viEnterInsert()
+		return
+	}
+	t.SetInsertMode(t.overwriteMode())
+}
+
+// SetInsertMode sets the insert mode if on is true, and the overwrite mode otherwise. The typed characters replace
+// the ones under the cursor in the overwrite mode. It's toggled by the Insert key, and it's safe to call concurrently
+// with ReadLine.
+func (t *Terminal) SetInsertMode(on bool) {
+	var v int32
+	if !on {
+		v = 1
+	}
+	if atomic.SwapInt32(&t.ioInsMode, v) != v {
+		t.writeCursorShape()
+	}
+}
+
+// IsInsertMode returns true in the insert mode, and false in the overwrite mode.
+func (t *Terminal) IsInsertMode() bool {
+	return !t.overwriteMode()
+}
+
+func (t *Terminal) overwriteMode() bool {
+	return atomic.LoadInt32(&t.ioInsMode) != 0
+}
+
+// writeCursorShape writes the cursor shape of the current mode if it's configured.
+func (t *Terminal) writeCursorShape() {
+	shape := t.config.InsertModeCursorShape
+	if t.overwriteMode() {
+		shape = t.config.OverwriteModeCursorShape
+	}
+	if shape == "" {
+		return
+	}
+	_ = t.rb.WriteSequence([]byte("\033[" + shape + " q"))
 }
 
 func (t *Terminal) opReturn() {
-	t.rb.MoveToLineEnd()
-	t.rb.WriteRune('\n')
-	p := t.rb.Bytes()
-	if len(p) > 0 {
+	// expanded is the line which Expander returns if it differs from the typed one
+	var expanded []byte
+	if t.config.Expander != nil && !t.rb.NoEcho() {
+		line := t.rb.String()
+		if e := t.config.Expander(line); e != line {
+			if t.config.ShowExpansion {
+				r := []rune(e)
+				t.rb.Set(len(r), r)
+			} else {
+				expanded = []byte(e)
+			}
+		}
+	}
+	if t.config.Validator != nil && !t.rb.NoEcho() {
+		line := t.rb.String()
+		if expanded != nil {
+			line = string(expanded)
+		}
+		if err := t.config.Validator(line); err != nil {
+			t.rb.MoveToLineEnd()
+			t.rb.WriteRune('\n')
+			t.bell()
+			return
+		}
+	}
+	prompt := t.rb.Prompt()
+	if t.config.TransientPrompt != nil && !t.rb.NoEcho() {
+		// the accepted line is displayed with the transient prompt
+		t.rb.MoveToLineEnd()
+		t.rb.SetPrompt(t.config.TransientPrompt(t.rb.String()))
+	}
+	var p []byte
+	if t.config.MultiLine || t.rb.IsReadOnly() {
+		// the newline can't be written to the read-only buffer, and Finish writes it only to the screen
+		t.rb.Finish()
+		p = t.rb.Bytes()
+	} else {
+		t.rb.MoveToLineEnd()
+		t.rb.WriteRune('\n')
+		p = t.rb.Bytes()
 		p = p[:len(p)-1]
 	}
+	if expanded != nil {
+		// the typed line stays on the screen
+		p = expanded
+	}
+	if !t.config.DisableAutoSaveHistory && len(p) > 0 && !t.rb.NoEcho() {
+		if err := t.history.addLine(string(p)); err != nil {
+			t.logError(err)
+		}
+	} else {
+		// the navigation and its search prefix don't continue to the next line
+		t.history.Reset()
+	}
+	if t.config.OnLineAccepted != nil {
+		line := string(p)
+		t.callback(func() {
+			t.config.OnLineAccepted(line)
+		})
+	}
+	t.emitOSC7()
 	t.sendLineResult(p, nil)
 	t.rb.ResetBuf()
+	t.rb.SetPromptBuf(prompt)
 }
 
 func (t *Terminal) opKill() {
-	if !t.rb.Kill() {
+	kill := t.rb.Kill
+	if t.numericArgNeg {
+		kill = t.rb.KillFront
+	}
+	if !kill() {
 		t.bell()
 	}
 }
@@ -562,20 +1537,107 @@ func (t *Terminal) opClear() {
 	t.rb.Clear()
 }
 
-func (t *Terminal) opNext() {
+// opNewline inserts a newline in the multi-line editing.
+func (t *Terminal) opNewline() {
+	if t.rb.IsReadOnly() {
+		t.bell()
+		return
+	}
+	t.rb.WriteRune('\n')
+}
 
+func (t *Terminal) opNext() {
+	if t.config.MultiLine && t.rb.MoveDown() {
+		return
+	}
+	t.historyPrefix()
+	line, ok := t.history.Newer()
+	if !ok {
+		t.bell()
+		return
+	}
+	t.rb.SetRunes(line)
+	t.historyLine = runeutil.Copy(line)
 }
 
 func (t *Terminal) opPrev() {
+	if t.config.MultiLine && t.rb.MoveUp() {
+		return
+	}
+	if !t.history.navigating() {
+		t.historySync()
+	}
+	t.historyPrefix()
+	line, ok := t.history.Older(t.rb.Runes())
+	if !ok {
+		t.bell()
+		return
+	}
+	t.rb.SetRunes(line)
+	t.historyLine = runeutil.Copy(line)
+}
 
+// historyPrefix sets the search prefix of the history navigation to the line if HistoryPrefixSearch is enabled and
+// the navigation starts with the cursor at the end of the line. The prefix is cleared if the line is changed after
+// the last navigation, so the navigation isn't filtered after editing.
+func (t *Terminal) historyPrefix() {
+	if !t.config.HistoryPrefixSearch {
+		return
+	}
+	line := t.rb.Runes()
+	if !t.history.navigating() {
+		if len(line) > 0 && t.rb.Index() == len(line) {
+			t.history.SetSearchPrefix(line)
+		}
+		return
+	}
+	if !runeutil.Equal(line, t.historyLine) {
+		t.history.SetSearchPrefix(nil)
+	}
 }
 
-func (t *Terminal) opBckSearch() {
+// historySuggestion is the hint provider of HistoryAutoSuggest. It returns the hint of HintProvider if there is one,
+// or the rest of the history line which is suggested for line.
+func (t *Terminal) historySuggestion(line []rune, pos int) []rune {
+	if t.config.HintProvider != nil {
+		if hint := t.config.HintProvider(line, pos); len(hint) > 0 {
+			return hint
+		}
+	}
+	if pos != len(line) {
+		return nil
+	}
+	s, ok := t.history.Suggest(string(line))
+	if !ok {
+		return nil
+	}
+	return []rune(s)[len(line):]
+}
+
+// historySync loads the history lines from the history backend. The error is logged, and the loaded lines are kept.
+func (t *Terminal) historySync() {
+	if err := t.history.sync(); err != nil {
+		t.logError(err)
+	}
+}
+
+// logError writes err to ErrorWriter if it isn't nil.
+func (t *Terminal) logError(err error) {
+	if t.config.ErrorWriter != nil {
+		_, _ = fmt.Fprintf(t.config.ErrorWriter, "readline: %v\n", err)
+	}
+}
 
+func (t *Terminal) opBckSearch() {
+	t.searchStart(true)
 }
 
 func (t *Terminal) opFwdSearch() {
+	t.searchStart(false)
+}
 
+func (t *Terminal) opHistoryGrep() {
+	t.grepStart()
 }
 
 func (t *Terminal) opTranspose() {
@@ -584,6 +1646,12 @@ func (t *Terminal) opTranspose() {
 	}
 }
 
+func (t *Terminal) opTransposeWords() {
+	if !t.rb.TransposeWords() {
+		t.bell()
+	}
+}
+
 func (t *Terminal) opKillFront() {
 	if !t.rb.KillFront() {
 		t.bell()
@@ -602,20 +1670,95 @@ func (t *Terminal) opKillWordFront() {
 	}
 }
 
+func (t *Terminal) opSetMark() {
+	if !t.rb.SetMark() {
+		t.bell()
+	}
+}
+
+// opKillRegion kills the region if the mark is set, or the word before the cursor otherwise.
+func (t *Terminal) opKillRegion() {
+	if t.rb.KillRegion() {
+		return
+	}
+	t.opKillWordFront()
+}
+
+func (t *Terminal) opExchangeMark() {
+	if !t.rb.ExchangeMark() {
+		t.bell()
+	}
+}
+
+func (t *Terminal) opCopyRegion() {
+	if !t.rb.CopyRegion() {
+		t.bell()
+	}
+}
+
 func (t *Terminal) opYank() {
 	if !t.rb.Yank() {
 		t.bell()
 	}
 }
 
-func (t *Terminal) opBackwardWord() {
-	if !t.rb.MoveToPrevWord() {
+func (t *Terminal) opYankPop() {
+	if !t.rb.YankPop() {
 		t.bell()
 	}
 }
 
-func (t *Terminal) opForwardWord() {
-	if !t.rb.MoveToNextWord() {
+func (t *Terminal) opUndo() {
+	if !t.rb.Undo() {
 		t.bell()
 	}
 }
+
+func (t *Terminal) opRedo() {
+	if !t.rb.Redo() {
+		t.bell()
+	}
+}
+
+// toggleCaseChar toggles the case of the rune at the cursor, and moves the cursor forward. It's '~' in the vi normal
+// mode, and it isn't bound in the emacs mode like in GNU readline.
+func (t *Terminal) toggleCaseChar() bool {
+	idx := t.rb.Index()
+	if !t.rb.ApplyCase(idx, idx+1, runeutil.CaseToggle) {
+		return false
+	}
+	t.rb.MoveForward()
+	return true
+}
+
+func (t *Terminal) opUpperCaseWord() {
+	if !t.rb.UpperCaseWord() {
+		t.bell()
+	}
+}
+
+func (t *Terminal) opLowerCaseWord() {
+	if !t.rb.LowerCaseWord() {
+		t.bell()
+	}
+}
+
+func (t *Terminal) opCapitalizeWord() {
+	if !t.rb.CapitalizeWord() {
+		t.bell()
+	}
+}
+
+func (t *Terminal) opPasteClipboard() {
+	if err := t.PasteFromClipboard(); err != nil {
+		t.bell()
+	}
+}
+
+func (t *Terminal) opBackwardWord() {
+	t.repeat(t.rb.MoveToPrevWord, t.rb.MoveToNextWord)
+}
+
+func (t *Terminal) opForwardWord() {
+	t.repeat(t.rb.MoveToNextWord, t.rb.MoveToPrevWord)
+}
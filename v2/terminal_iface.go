@@ -0,0 +1,137 @@
+package readline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// TerminalInterface is the interface of the methods of Terminal which are used by a REPL loop. The loop can be tested
+// with MockTerminal instead of Terminal, which needs a terminal to edit the lines.
+type TerminalInterface interface {
+	ReadLine() (string, error)
+	ReadLineContext(ctx context.Context) (string, error)
+	ReadPassword(prompt string) (string, error)
+	WriteOutput(p []byte) (int, error)
+	Println(a ...interface{}) (int, error)
+	Printf(format string, a ...interface{}) (int, error)
+	GetPrompt() string
+	SetPrompt(prompt string)
+	Close() error
+}
+
+var (
+	_ TerminalInterface = (*Terminal)(nil)
+	_ TerminalInterface = (*MockTerminal)(nil)
+)
+
+// MockTerminal is a TerminalInterface which returns the pre-programmed lines, and records the output. The zero
+// value is ready to use. It is safe for concurrent use.
+type MockTerminal struct {
+	// OnReadLine returns the line of ReadLine, ReadLineContext and ReadPassword if it isn't nil, instead of
+	// the responses of AddResponse.
+	OnReadLine func() (string, error)
+
+	mu        sync.Mutex
+	responses []mockResponse
+	outputs   [][]byte
+	prompt    string
+	closed    bool
+}
+
+type mockResponse struct {
+	line string
+	err  error
+}
+
+// AddResponse appends the line and the error which are returned by a read. The reads return io.EOF after
+// the responses run out.
+func (m *MockTerminal) AddResponse(line string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responses = append(m.responses, mockResponse{line, err})
+}
+
+func (m *MockTerminal) ReadLine() (string, error) {
+	return m.ReadLineContext(context.Background())
+}
+
+// ReadLineContext is like ReadLine, but it returns the error of ctx if ctx is done.
+func (m *MockTerminal) ReadLineContext(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return "", ErrClosed
+	}
+	if m.OnReadLine != nil {
+		onReadLine := m.OnReadLine
+		m.mu.Unlock()
+		return onReadLine()
+	}
+	defer m.mu.Unlock()
+	if len(m.responses) <= 0 {
+		return "", io.EOF
+	}
+	r := m.responses[0]
+	m.responses = m.responses[1:]
+	return r.line, r.err
+}
+
+// ReadPassword returns the next response like ReadLine, and prompt is ignored.
+func (m *MockTerminal) ReadPassword(prompt string) (string, error) {
+	return m.ReadLine()
+}
+
+// WriteOutput records a copy of p, which is returned by Outputs.
+func (m *MockTerminal) WriteOutput(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, ErrClosed
+	}
+	m.outputs = append(m.outputs, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (m *MockTerminal) Println(a ...interface{}) (int, error) {
+	return m.WriteOutput([]byte(fmt.Sprintln(a...)))
+}
+
+func (m *MockTerminal) Printf(format string, a ...interface{}) (int, error) {
+	return m.WriteOutput([]byte(sprintfLine(format, a...)))
+}
+
+// Outputs returns the output of the calls to WriteOutput, Println and Printf, one element per call.
+func (m *MockTerminal) Outputs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]string, len(m.outputs))
+	for i, p := range m.outputs {
+		result[i] = string(p)
+	}
+	return result
+}
+
+func (m *MockTerminal) GetPrompt() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prompt
+}
+
+func (m *MockTerminal) SetPrompt(prompt string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prompt = prompt
+}
+
+// Close makes the reads and the writes return ErrClosed.
+func (m *MockTerminal) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
@@ -0,0 +1,107 @@
+package readline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testREPL echoes the lines in upper case until "exit" or an error, like the loop of an application.
+func testREPL(term TerminalInterface) error {
+	term.SetPrompt("> ")
+	for {
+		line, err := term.ReadLine()
+		if err == ErrInterrupted {
+			if _, err := term.Println("^C"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if line == "exit" {
+			return nil
+		}
+		if _, err := term.Printf("%s", strings.ToUpper(line)); err != nil {
+			return err
+		}
+	}
+}
+
+func TestMockTerminal(t *testing.T) {
+	m := &MockTerminal{}
+	m.AddResponse("hello", nil)
+	m.AddResponse("", ErrInterrupted)
+	m.AddResponse("world", nil)
+	m.AddResponse("exit", nil)
+	m.AddResponse("ignored", nil)
+
+	if err := testREPL(m); err != nil {
+		t.Fatal(err)
+	}
+	if outputs := m.Outputs(); !reflect.DeepEqual(outputs, []string{"HELLO\n", "^C\n", "WORLD\n"}) {
+		t.Fatalf("outputs %q", outputs)
+	}
+	if prompt := m.GetPrompt(); prompt != "> " {
+		t.Fatalf("prompt %q, expected \"> \"", prompt)
+	}
+	if line, err := m.ReadLine(); err != nil || line != "ignored" {
+		t.Fatalf("line %q %v, expected \"ignored\"", line, err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReadLine(); err != ErrClosed {
+		t.Fatalf("read after close: error %v, expected ErrClosed", err)
+	}
+
+	// the loop ends with io.EOF after the responses run out
+	m = &MockTerminal{}
+	m.AddResponse("a", nil)
+	if err := testREPL(m); err != io.EOF {
+		t.Fatalf("error %v, expected io.EOF", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.ReadLineContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, expected context.Canceled", err)
+	}
+}
+
+func TestMockTerminalOnReadLine(t *testing.T) {
+	lines := []string{"one", "two"}
+	m := &MockTerminal{
+		OnReadLine: func() (string, error) {
+			if len(lines) <= 0 {
+				return "exit", nil
+			}
+			line := lines[0]
+			lines = lines[1:]
+			return line, nil
+		},
+	}
+	if err := testREPL(m); err != nil {
+		t.Fatal(err)
+	}
+	if outputs := m.Outputs(); !reflect.DeepEqual(outputs, []string{"ONE\n", "TWO\n"}) {
+		t.Fatalf("outputs %q", outputs)
+	}
+}
+
+func TestTerminalInterface(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+	if _, err := io.WriteString(stdin, "hello\rexit\r"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testREPL(term); err != nil {
+		t.Fatal(err)
+	}
+	if prompt := term.GetPrompt(); prompt != "> " {
+		t.Fatalf("prompt %q, expected \"> \"", prompt)
+	}
+}
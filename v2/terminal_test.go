@@ -0,0 +1,1505 @@
+package readline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestTerminal creates a Terminal reading from a pipe. It returns the write end of that pipe.
+func newTestTerminal(tb testing.TB, config Config) (*Terminal, *os.File) {
+	tb.Helper()
+	return newTestTerminalOutput(tb, config, io.Discard)
+}
+
+// newTestTerminalOutput is like newTestTerminal, but copies the output of the Terminal to out.
+func newTestTerminalOutput(tb testing.TB, config Config, out io.Writer) (*Terminal, *os.File) {
+	tb.Helper()
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go func() {
+		_, _ = io.Copy(out, stdoutR)
+	}()
+	config.Stdin = stdinR
+	config.Stdout = stdoutW
+	config.Stderr = stdoutW
+	term, err := NewTerminal(config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = term.Close()
+		_ = stdinW.Close()
+		_ = stdinR.Close()
+		_ = stdoutW.Close()
+		_ = stdoutR.Close()
+	})
+	return term, stdinW
+}
+
+func writeAndReadLine(tb testing.TB, term *Terminal, stdin io.Writer, input string) string {
+	tb.Helper()
+	if _, err := io.WriteString(stdin, input); err != nil {
+		tb.Fatal(err)
+	}
+	line, err := term.ReadLine()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return line
+}
+
+func TestTerminalHistoryNavigation(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{})
+
+	for _, s := range []string{"first", "second", "third"} {
+		if line := writeAndReadLine(t, term, stdin, s+"\r"); line != s {
+			t.Fatalf("line %q, expected %q", line, s)
+		}
+	}
+	if n := term.History().Len(); n != 3 {
+		t.Fatalf("history length %d, expected 3", n)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"\x10\r", "third"},
+		{"\x10\x10\r", "third"},
+		{"\x10\x10\x10\x10\x10\r", "first"},
+		{"draft\x10\x10\x0e\x0e\r", "draft"},
+		{"dra\x1b[A\x1b[Bft\r", "draft"},
+		{"\x0e\r", ""},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
+
+func TestTerminalHistoryContext(t *testing.T) {
+	type modeKey struct{}
+	term, stdin := newTestTerminal(t, Config{HistoryContextKey: modeKey{}, HistoryContextValue: "shell"})
+	sql := context.WithValue(context.Background(), modeKey{}, "sql")
+
+	readLine := func(ctx context.Context, mode string, input string) string {
+		result := make(chan string, 1)
+		go func() {
+			line, err := term.ReadLineContext(ctx)
+			if err != nil {
+				t.Error(err)
+			}
+			result <- line
+		}()
+		// the input is written after the read sets the context
+		waitFor(t, func() bool {
+			term.history.mu.Lock()
+			defer term.history.mu.Unlock()
+			return term.history.tag == mode
+		})
+		if _, err := io.WriteString(stdin, input); err != nil {
+			t.Fatal(err)
+		}
+		return <-result
+	}
+
+	tests := []struct {
+		ctx      context.Context
+		mode     string
+		input    string
+		expected string
+	}{
+		{context.Background(), "shell", "ls\r", "ls"},
+		{sql, "sql", "select 1\r", "select 1"},
+		{context.Background(), "shell", "pwd\r", "pwd"},
+		{sql, "sql", "\x1b[A\r", "select 1"},
+		{context.Background(), "shell", "\x1b[A\x1b[A\r", "ls"},
+		{sql, "sql", "\x1b[A\x1b[A\x1b[A\r", "select 1"},
+	}
+	for _, test := range tests {
+		if line := readLine(test.ctx, test.mode, test.input); line != test.expected {
+			t.Errorf("%s input %q: line %q, expected %q", test.mode, test.input, line, test.expected)
+		}
+	}
+	if n := len(term.History().AllEntries()); n != len(tests) {
+		t.Fatalf("history length %d, expected %d", n, len(tests))
+	}
+}
+
+func TestTerminalDisableAutoSaveHistory(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	writeAndReadLine(t, term, stdin, "line\r")
+	if n := term.History().Len(); n != 0 {
+		t.Fatalf("history length %d, expected 0", n)
+	}
+}
+
+func TestTerminalSearch(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+	for _, s := range []string{"git status", "ls -l", "git commit"} {
+		term.History().Add(s)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"\x12git\r", "git commit"},
+		{"\x12git\x12\r", "git status"},
+		{"\x12git\x12\x12\x12\r", "git status"},
+		{"\x12git\x12\x13\r", "git commit"},
+		{"\x12lz\x7fs\r", "ls -l"},
+		{"draft\x12zzz\x07\r", "draft"},
+		{"draft\x12ls\x03\r", "draft"},
+		{"\x12stat\x05!\r", "git status!"},
+		{"\x12stat\x01#\r", "#git status"},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+		term.History().Reset()
+	}
+}
+
+func TestTerminalSearchDisplay(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{
+		Prompt:                 "> ",
+		ForceUseInteractive:    true,
+		DisableAutoSaveHistory: true,
+	}, out)
+	for _, s := range []string{"git status", "ls -l"} {
+		term.History().Add(s)
+	}
+
+	done := make(chan string)
+	go func() {
+		line, _ := term.ReadLine()
+		done <- line
+	}()
+
+	// the search prompt replaces the prompt, and the match is highlighted in the line
+	if _, err := stdin.Write([]byte("draft\x12sta")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		s := out.String()
+		return strings.Contains(s, "(reverse-i-search)`sta': git status") &&
+			strings.Contains(s, "\033["+searchMatchStyle+"msta\033[0m")
+	})
+
+	// there is no older match
+	if _, err := stdin.Write([]byte("\x12")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "(failed reverse-i-search)`sta': ")
+	})
+
+	// aborting restores the prompt and the line before the search
+	if _, err := stdin.Write([]byte("\x07")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return strings.HasSuffix(out.String(), "> draft")
+	})
+	if _, err := stdin.Write([]byte("\r")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-done; line != "draft" {
+		t.Fatalf("line %q, expected \"draft\"", line)
+	}
+}
+
+func TestTerminalClearScreen(t *testing.T) {
+	out := &syncBuffer{}
+	term, _ := newTestTerminalOutput(t, Config{
+		Prompt:              "> ",
+		ForceUseInteractive: true,
+	}, out)
+
+	done := make(chan string)
+	go func() {
+		line, _ := term.ReadLine()
+		done <- line
+	}()
+
+	// the cursor moves home, and the prompt is drawn again with the line
+	if _, err := term.WriteStdin([]byte{'l', 's', CharClearScreen}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		s := out.String()
+		i := strings.LastIndex(s, "\033[H")
+		return i >= 0 && strings.HasSuffix(s[i:], "\r> ls")
+	})
+	if _, err := term.WriteStdin([]byte("\r")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-done; line != "ls" {
+		t.Fatalf("line %q, expected \"ls\"", line)
+	}
+}
+
+func TestTerminalClosed(t *testing.T) {
+	term, _ := newTestTerminal(t, Config{})
+	_ = term.Close()
+
+	if _, err := term.ReadLine(); !errors.Is(err, ErrClosed) {
+		t.Errorf("ReadLine: error %v, expected %v", err, ErrClosed)
+	}
+	if _, err := term.ReadBytesContext(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("ReadBytesContext: error %v, expected %v", err, ErrClosed)
+	}
+	if _, err := term.ReadByte(); !errors.Is(err, ErrClosed) {
+		t.Errorf("ReadByte: error %v, expected %v", err, ErrClosed)
+	}
+	if _, err := term.WriteStdin([]byte("ls\r")); !errors.Is(err, ErrClosed) {
+		t.Errorf("WriteStdin: error %v, expected %v", err, ErrClosed)
+	}
+	if err := term.EnterRawMode(); !errors.Is(err, ErrClosed) {
+		t.Errorf("EnterRawMode: error %v, expected %v", err, ErrClosed)
+	}
+}
+
+func TestTerminalInsertMode(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{
+		Prompt:                   "> ",
+		ForceUseInteractive:      true,
+		InsertModeCursorShape:    "6",
+		OverwriteModeCursorShape: "2",
+	}, out)
+	if !term.IsInsertMode() {
+		t.Fatal("not in the insert mode initially")
+	}
+
+	done := make(chan string)
+	go func() {
+		line, _ := term.ReadLine()
+		done <- line
+	}()
+
+	// the Insert key enters the overwrite mode, and the typed character replaces the one under the cursor
+	if _, err := stdin.Write([]byte("abc\x01\x1b[2~X")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "\033[2 q") && strings.Contains(out.String(), "Xbc")
+	})
+	if term.IsInsertMode() {
+		t.Fatal("not in the overwrite mode after the Insert key")
+	}
+
+	term.SetInsertMode(true)
+	if !term.IsInsertMode() {
+		t.Fatal("not in the insert mode after SetInsertMode(true)")
+	}
+	waitFor(t, func() bool {
+		return strings.HasSuffix(out.String(), "\033[6 q")
+	})
+	if _, err := stdin.Write([]byte("Y\r")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-done; line != "XYbc" {
+		t.Fatalf("line %q, expected \"XYbc\"", line)
+	}
+}
+
+func TestTerminalReadLineClose(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		term, stdin := newTestTerminal(t, Config{})
+		done := make(chan error)
+		go func() {
+			for {
+				if _, err := term.ReadLine(); err != nil {
+					done <- err
+					return
+				}
+			}
+		}()
+		go func() {
+			for j := 0; j < 10; j++ {
+				if _, err := stdin.Write([]byte("ls\r")); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(time.Duration(i) * time.Millisecond)
+		_ = term.Close()
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrClosed) && !errors.Is(err, io.EOF) {
+				t.Fatalf("error %v, expected %v or %v", err, ErrClosed, io.EOF)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("ReadLine doesn't return after Close")
+		}
+	}
+}
+
+func TestTerminalTypeAhead(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{})
+
+	// the lines typed ahead of the reads aren't dropped
+	if _, err := io.WriteString(stdin, "a\rb\rc\r"); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"a", "b", "c"} {
+		done := make(chan string, 1)
+		go func() {
+			line, _ := term.ReadLine()
+			done <- line
+		}()
+		select {
+		case line := <-done:
+			if line != expected {
+				t.Fatalf("line %q, expected %q", line, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("ReadLine doesn't return %q", expected)
+		}
+	}
+}
+
+func TestTerminalLineBeforeExit(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{})
+
+	// the line is accepted before stdin is closed, so it's read before the error of ioloop
+	if _, err := stdin.Write([]byte("ls\r")); err != nil {
+		t.Fatal(err)
+	}
+	_ = stdin.Close()
+	select {
+	case <-term.ioloopDoneCh:
+	case <-time.After(time.Second):
+		t.Fatal("ioloop doesn't exit")
+	}
+	if line, err := term.ReadLine(); err != nil || line != "ls" {
+		t.Fatalf("line %q, error %v, expected \"ls\"", line, err)
+	}
+	if _, err := term.ReadLine(); !errors.Is(err, io.EOF) {
+		t.Fatalf("error %v, expected %v", err, io.EOF)
+	}
+}
+
+func TestTerminalExpander(t *testing.T) {
+	expander := func(line string) string {
+		if line == "gc" {
+			return "git commit"
+		}
+		return line
+	}
+	for _, show := range []bool{false, true} {
+		out := &syncBuffer{}
+		term, stdin := newTestTerminalOutput(t, Config{
+			Prompt:              "> ",
+			ForceUseInteractive: true,
+			Expander:            expander,
+			ShowExpansion:       show,
+		}, out)
+
+		for _, test := range []struct{ input, expected string }{{"gc", "git commit"}, {"ls", "ls"}} {
+			if line := writeAndReadLine(t, term, stdin, test.input+"\r"); line != test.expected {
+				t.Fatalf("show %v: line %q, expected %q", show, line, test.expected)
+			}
+		}
+		if entries := term.History().Entries(); !reflect.DeepEqual(entries, []string{"git commit", "ls"}) {
+			t.Fatalf("show %v: history %q, expected the expanded line", show, entries)
+		}
+		waitFor(t, func() bool {
+			return strings.Contains(out.String(), "> ls")
+		})
+		if displayed := strings.Contains(out.String(), "> git commit"); displayed != show {
+			t.Fatalf("show %v: output %q", show, out.String())
+		}
+	}
+}
+
+func TestTerminalHistoryGrep(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{
+		Prompt:                 "> ",
+		ForceUseInteractive:    true,
+		DisableAutoSaveHistory: true,
+	}, out)
+	for _, s := range []string{"git status", "ls -l", "git commit", "make"} {
+		term.History().Add(s)
+	}
+
+	done := make(chan string)
+	go func() {
+		line, _ := term.ReadLine()
+		done <- line
+	}()
+
+	// all the matches are listed from the newest, and the newest one is selected
+	if _, err := stdin.Write([]byte("draft\x1b!git")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		s := out.String()
+		return strings.Contains(s, "(history-grep) git") &&
+			strings.Contains(s, "\n\033["+grepMenuStyle+"mgit commit\033[0m\ngit status")
+	})
+
+	// the next match is selected by the down arrow, and Enter sets it to the buffer
+	if _, err := stdin.Write([]byte("\x1b[B\r")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return strings.HasSuffix(out.String(), "> git status")
+	})
+	if _, err := stdin.Write([]byte("\r")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-done; line != "git status" {
+		t.Fatalf("line %q, expected \"git status\"", line)
+	}
+
+	// Ctrl+G restores the buffer before the history grep
+	go func() {
+		line, _ := term.ReadLine()
+		done <- line
+	}()
+	if _, err := stdin.Write([]byte("draft\x1b!ls\x07")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return strings.HasSuffix(out.String(), "> draft")
+	})
+	if _, err := stdin.Write([]byte("\r")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-done; line != "draft" {
+		t.Fatalf("line %q, expected \"draft\"", line)
+	}
+}
+
+func TestTerminalHistoryGrepRegex(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{HistoryRegexSearch: true, DisableAutoSaveHistory: true})
+	for _, s := range []string{"git status", "ls -l", "git commit"} {
+		term.History().Add(s)
+	}
+	if line := writeAndReadLine(t, term, stdin, "\x1b!^g.*s$\r\r"); line != "git status" {
+		t.Fatalf("line %q, expected \"git status\"", line)
+	}
+}
+
+func TestTerminalHintDebounce(t *testing.T) {
+	var calls int32
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{
+		Prompt:              "> ",
+		ForceUseInteractive: true,
+		HintProvider: func(line []rune, pos int) []rune {
+			atomic.AddInt32(&calls, 1)
+			return []rune(" <" + string(line) + ">")
+		},
+		HintDebounce: 50 * time.Millisecond,
+	}, out)
+
+	done := make(chan string)
+	go func() {
+		line, _ := term.ReadLine()
+		done <- line
+	}()
+
+	const input = "abcdefghij"
+	for _, c := range input {
+		if _, err := stdin.Write([]byte(string(c))); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), " <"+input+">")
+	})
+	if n := atomic.LoadInt32(&calls); n >= int32(len(input))/2 {
+		t.Fatalf("hint provider is called %d times for %d keystrokes", n, len(input))
+	}
+	if strings.Contains(out.String(), " <abc>") {
+		t.Fatalf("output %q, expected no hint while typing", out.String())
+	}
+
+	if _, err := stdin.Write([]byte("\r")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-done; line != input {
+		t.Fatalf("line %q, expected %q", line, input)
+	}
+}
+
+func TestTerminalConfirmInterrupt(t *testing.T) {
+	var lines []string
+	term, stdin := newTestTerminal(t, Config{
+		DisableAutoSaveHistory: true,
+		ConfirmInterrupt: func(line string) bool {
+			lines = append(lines, line)
+			return line == "quit"
+		},
+	})
+
+	if line := writeAndReadLine(t, term, stdin, "ab\x03c\r"); line != "abc" {
+		t.Fatalf("line %q, expected \"abc\"", line)
+	}
+	if _, err := io.WriteString(stdin, "quit\x03"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := term.ReadLine(); err != ErrInterrupted {
+		t.Fatalf("error %v, expected ErrInterrupted", err)
+	}
+	if !reflect.DeepEqual(lines, []string{"ab", "quit"}) {
+		t.Fatalf("confirmed lines %q, expected [ab quit]", lines)
+	}
+}
+
+func TestTerminalConfirmInterruptPrompt(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{
+		Prompt:                 "> ",
+		ForceUseInteractive:    true,
+		DisableAutoSaveHistory: true,
+		ConfirmInterruptPrompt: "cancel? ",
+	}, out)
+
+	// the other keys than "y" cancel the interrupt, and the key isn't inserted
+	if line := writeAndReadLine(t, term, stdin, "ab\x03nc\r"); line != "abc" {
+		t.Fatalf("line %q, expected \"abc\"", line)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "cancel? ab")
+	})
+
+	if _, err := io.WriteString(stdin, "ab\x03y"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := term.ReadLine(); err != ErrInterrupted {
+		t.Fatalf("error %v, expected ErrInterrupted", err)
+	}
+}
+
+func TestTerminalYankPop(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{})
+
+	// the cursor movement between the kills makes them separate entries
+	if line := writeAndReadLine(t, term, stdin, "a b c\x17\x02\x06\x17\x19\x1by\r"); line != "a c" {
+		t.Fatalf("line %q, expected \"a c\"", line)
+	}
+	// the consecutive kills are yanked at once
+	if line := writeAndReadLine(t, term, stdin, "a b c\x17\x17\x01\x19\r"); line != "b ca " {
+		t.Fatalf("line %q, expected \"b ca \"", line)
+	}
+}
+
+func TestTerminalUndo(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{})
+
+	if line := writeAndReadLine(t, term, stdin, "abc def\x17\x1f\x1f\x1br\x1br\r"); line != "abc " {
+		t.Fatalf("line %q, expected \"abc \"", line)
+	}
+}
+
+func TestTerminalPromptFunc(t *testing.T) {
+	calls := 0
+	term, stdin := newTestTerminal(t, Config{
+		Prompt: "unused> ",
+		PromptFunc: func() string {
+			calls++
+			return "\033[1;32m" + strings.Repeat("~", calls) + "\033[0m> "
+		},
+	})
+
+	for i := 1; i <= 3; i++ {
+		writeAndReadLine(t, term, stdin, "line\r")
+		if calls != i {
+			t.Fatalf("PromptFunc called %d times, expected %d", calls, i)
+		}
+		expected := "\033[1;32m" + strings.Repeat("~", i) + "\033[0m> "
+		if prompt := term.rb.Prompt(); prompt != expected {
+			t.Fatalf("prompt %q, expected %q", prompt, expected)
+		}
+		if width := term.rb.PromptWidth(); width != i+2 {
+			t.Fatalf("prompt width %d, expected %d", width, i+2)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer which is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTerminalPrintln(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{Prompt: "> ", ForceUseInteractive: true}, out)
+
+	if _, err := term.Println("hello", "world"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := term.Printf("%d%%", 42); err != nil {
+		t.Fatal(err)
+	}
+	writeAndReadLine(t, term, stdin, "abc\r")
+
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "42%\n> ")
+	})
+	s := out.String()
+	if !strings.Contains(s, "hello world\n> ") {
+		t.Fatalf("output %q doesn't contain the printed line followed by the prompt", s)
+	}
+}
+
+func TestTerminalSafeStdout(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{Prompt: "> ", ForceUseInteractive: true}, out)
+
+	done := make(chan string)
+	go func() {
+		line, _ := term.ReadLine()
+		done <- line
+	}()
+	if _, err := stdin.Write([]byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return strings.HasSuffix(out.String(), "> ab")
+	})
+
+	logger := log.New(term.SafeStdout(), "log: ", 0)
+	logger.Print("first")
+	logger.Print("second")
+	if _, err := stdin.Write([]byte("c\r")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-done; line != "abc" {
+		t.Fatalf("line %q, expected \"abc\"", line)
+	}
+
+	waitFor(t, func() bool {
+		return strings.HasSuffix(out.String(), "> abc\n")
+	})
+
+	// the log lines are written above the line being edited, which is redrawn after every one of them
+	s := out.String()
+	i := strings.Index(s, "> ab")
+	j := strings.Index(s, "log: first\n> ab")
+	k := strings.Index(s, "log: second\n> ab")
+	if i < 0 || j < i || k < j {
+		t.Fatalf("output %q doesn't contain the prompt and the log lines in order", s)
+	}
+}
+
+func TestTerminalWriteOutputNotInteractive(t *testing.T) {
+	out := &syncBuffer{}
+	term, _ := newTestTerminalOutput(t, Config{Prompt: "> "}, out)
+	if _, err := term.SafeStdout().Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return out.String() != ""
+	})
+	if s := out.String(); s != "hello\n" {
+		t.Fatalf("output %q, expected \"hello\\n\"", s)
+	}
+}
+
+// waitFor waits until cond returns true, and fails if it doesn't in a second.
+func waitFor(tb testing.TB, cond func() bool) {
+	tb.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			tb.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTerminalReadPassword(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{Prompt: "> ", ForceUseInteractive: true}, out)
+
+	type result struct {
+		password string
+		err      error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		password, err := term.ReadPassword("Password: ")
+		resultCh <- result{password, err}
+	}()
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "Password: ")
+	})
+	if _, err := io.WriteString(stdin, "s3cr\x7fret\r"); err != nil {
+		t.Fatal(err)
+	}
+	r := <-resultCh
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.password != "s3cret" {
+		t.Fatalf("password %q, expected \"s3cret\"", r.password)
+	}
+	if n := term.History().Len(); n != 0 {
+		t.Fatalf("history length %d, expected 0", n)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "\n")
+	})
+	s := out.String()
+	echo := strings.ReplaceAll(s[:strings.Index(s, "\n")], "Password: ", "")
+	if i := strings.IndexAny(echo, "s3cret*"); i >= 0 {
+		t.Fatalf("output %q contains a character of the password at %d", echo, i)
+	}
+
+	if line := writeAndReadLine(t, term, stdin, "visible\r"); line != "visible" {
+		t.Fatalf("line %q, expected \"visible\"", line)
+	}
+	if prompt := term.rb.Prompt(); prompt != "> " {
+		t.Fatalf("prompt %q, expected \"> \"", prompt)
+	}
+}
+
+func TestTerminalReadPasswordCtrlD(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{})
+
+	// Ctrl+D doesn't end the input unless the password is empty
+	if _, err := io.WriteString(stdin, "ab\x04\x02\x04c\r"); err != nil {
+		t.Fatal(err)
+	}
+	password, err := term.ReadPassword("Password: ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "ac" {
+		t.Fatalf("password %q, expected \"ac\"", password)
+	}
+
+	if _, err := io.WriteString(stdin, "\x04"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := term.ReadPassword("Password: "); err != io.EOF {
+		t.Fatalf("error %v, expected %v", err, io.EOF)
+	}
+}
+
+func TestTerminalBracketedPaste(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{BracketedPaste: true, DisableAutoSaveHistory: true})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"\x1b[200~line1\nline2\x1b[201~\r", "line1\nline2"},
+		{"\x1b[200~a\r\nb\rc\x1b[201~\r", "a\nb\nc"},
+		{"xy\x02\x1b[200~\tpasted\x01\x1b[201~\r", "x\tpasted\x01y"},
+		{"\x1b[201~abc\r", "abc"},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
+
+func TestTerminalPasteFromClipboard(t *testing.T) {
+	var clipboard []byte
+	var clipboardErr error
+	reader := func() ([]byte, error) {
+		return clipboard, clipboardErr
+	}
+
+	tests := []struct {
+		multiLine bool
+		clipboard string
+		input     string
+		expected  string
+	}{
+		{false, "pasted", "a\x1bvb\r", "apastedb"},
+		{false, "line1\r\nline2\n", "\x1bv\r", "line1 line2"},
+		{false, "a\x00b\x00", "\x1bv\r", "ab"},
+		{true, "line1\rline2\n", "\x1bv\r", "line1\nline2"},
+	}
+	for _, test := range tests {
+		term, stdin := newTestTerminal(t, Config{
+			DisableAutoSaveHistory: true,
+			MultiLine:              test.multiLine,
+			ClipboardReader:        reader,
+		})
+		clipboard = []byte(test.clipboard)
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("clipboard %q, input %q: line %q, expected %q", test.clipboard, test.input, line, test.expected)
+		}
+	}
+
+	term, _ := newTestTerminal(t, Config{ClipboardReader: reader})
+	clipboard = bytes.Repeat([]byte("a"), MaxClipboardSize+1)
+	if err := term.PasteFromClipboard(); !errors.Is(err, ErrClipboardTooLarge) {
+		t.Errorf("error %v, expected %v", err, ErrClipboardTooLarge)
+	}
+	clipboard, clipboardErr = nil, ErrNoClipboard
+	if err := term.PasteFromClipboard(); !errors.Is(err, ErrNoClipboard) {
+		t.Errorf("error %v, expected %v", err, ErrNoClipboard)
+	}
+	if s := term.rb.String(); s != "" {
+		t.Errorf("buffer %q after errors, expected empty", s)
+	}
+}
+
+func TestTerminalRegion(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"foo bar\x17\r", "foo "},
+		{"foo bar\x01\x00\x06\x06\x17\r", "o bar"},
+		{"foo bar\x01\x1b \x1bf\x1bw\x05\x19\r", "foo barfoo "},
+		{"foo\x00 bar\x18\x18X\r", "fooX bar"},
+		{"ab\x00\x01\x18\x18X\x18\x18Y\r", "YabX"},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
+
+func TestTerminalCtrlD(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"abc\x01\x04\r", "bc"},
+		{"abc\x04\x04\r", "abc"},
+		{"a\x02\x04\r", ""},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+
+	if _, err := io.WriteString(stdin, "\x04"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := term.ReadLine(); err != io.EOF {
+		t.Fatalf("error %v, expected %v", err, io.EOF)
+	}
+}
+
+func TestTerminalHint(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		ForceUseInteractive:    true,
+		DisableAutoSaveHistory: true,
+		HintProvider: func(line []rune, pos int) []rune {
+			if s := "git status"; len(line) > 0 && strings.HasPrefix(s, string(line)) {
+				return []rune(s[len(line):])
+			}
+			return nil
+		},
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"gi\r", "gi"},
+		{"gi\x1b[C\r", "git status"},
+		{"gi\x06!\r", "git status!"},
+		{"gi\x02\x1b[C\r", "gi"},
+		{"x\x1b[C\r", "x"},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
+
+func TestTerminalHistoryAutoSuggest(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{
+		Prompt:                 "> ",
+		ForceUseInteractive:    true,
+		DisableAutoSaveHistory: true,
+		HistoryAutoSuggest:     true,
+	}, out)
+	for _, s := range []string{"git status", "ls -l"} {
+		term.History().Add(s)
+	}
+
+	done := make(chan string)
+	go func() {
+		line, _ := term.ReadLine()
+		done <- line
+	}()
+	if _, err := stdin.Write([]byte("gi")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "> gi\033["+HistorySuggestionStyle+"mt status\033[0m")
+	})
+	if _, err := stdin.Write([]byte("\x1b[C\r")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-done; line != "git status" {
+		t.Fatalf("line %q, expected \"git status\"", line)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"l\x1b[C\r", "ls -l"},
+		{"gx\x1b[C\r", "gx"},
+		{"gi\x02\x1b[C\r", "gi"},
+		{"git\x7f\x7f\x7f\x1b[C\r", ""},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
+
+func TestTerminalMultiLine(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		ForceUseInteractive:    true,
+		DisableAutoSaveHistory: true,
+		MultiLine:              true,
+		ContinuationPrompt:     "... ",
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a\nb\r", "a\nb"},
+		{"ab\ncd\x10x\r", "abx\ncd"},
+		{"ab\ncd\x10\x0ex\r", "ab\ncdx"},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
+
+func TestTerminalSubmitKey(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		DisableAutoSaveHistory: true,
+		MultiLine:              true,
+		SubmitKey:              "\n",
+	})
+
+	if line := writeAndReadLine(t, term, stdin, "a\rb\n"); line != "a\nb" {
+		t.Fatalf("line %q, expected \"a\\nb\"", line)
+	}
+}
+
+func TestTerminalSuspendNotRaw(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	if line := writeAndReadLine(t, term, stdin, "a\x1ab\r"); line != "ab" {
+		t.Fatalf("line %q, expected \"ab\"", line)
+	}
+}
+
+func TestTerminalNumericArg(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"abcdef\x01\x1b3\x06!\r", "abc!def"},
+		{"abcdef\x1b2\x02!\r", "abcd!ef"},
+		{"abcdef\x01\x1b-\x1b2\x06!\r", "!abcdef"},
+		{"abcdef\x1b-\x06!\r", "abcde!f"},
+		{"abcdef\x1b1\x1b2\x08\r", ""},
+		{"one two three\x01\x1b2\x1bf!\r", "one two !three"},
+		{"abcdef\x1b3\x08\x06!\r", "abc!"},
+		{"xy\x1b5a\r", "xyaaaaa"},
+		{"xy\x1b5a\x1f\r", "xy"},
+		{"xy\x1b-a\r", "xya"},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
+
+func TestTerminalValidator(t *testing.T) {
+	validated := 0
+	term, stdin := newTestTerminal(t, Config{
+		DisableAutoSaveHistory: true,
+		MultiLine:              true,
+		Validator: func(line string) error {
+			validated++
+			if !strings.HasSuffix(line, ";") {
+				return errors.New("missing semicolon")
+			}
+			return nil
+		},
+	})
+
+	if line := writeAndReadLine(t, term, stdin, "select 1\rfrom t;\r"); line != "select 1\nfrom t;" {
+		t.Fatalf("line %q, expected \"select 1\\nfrom t;\"", line)
+	}
+	if validated != 2 {
+		t.Fatalf("validated %d times, expected 2", validated)
+	}
+}
+
+func TestTerminalCallbacks(t *testing.T) {
+	var keys []string
+	var accepted []string
+	term, stdin := newTestTerminal(t, Config{
+		DisableAutoSaveHistory: true,
+		OnKeyPress: func(key []byte) []byte {
+			keys = append(keys, string(key))
+			switch string(key) {
+			case "a":
+				return []byte("b")
+			case "x", "\x1b[D":
+				return nil
+			}
+			return key
+		},
+		OnLineAccepted: func(line string) {
+			accepted = append(accepted, line)
+		},
+	})
+
+	if line := writeAndReadLine(t, term, stdin, "ax\x1b[Dc\r"); line != "bc" {
+		t.Fatalf("line %q, expected \"bc\"", line)
+	}
+	if expected := []string{"a", "x", "\x1b[D", "c", "\r"}; strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Fatalf("keys %q, expected %q", keys, expected)
+	}
+	if len(accepted) != 1 || accepted[0] != "bc" {
+		t.Fatalf("accepted lines %q, expected [bc]", accepted)
+	}
+
+	if _, err := io.WriteString(stdin, "\x03"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := term.ReadLine(); err != ErrInterrupted {
+		t.Fatalf("error %v, expected ErrInterrupted", err)
+	}
+	if len(accepted) != 1 {
+		t.Fatalf("accepted lines %q after interrupt, expected [bc]", accepted)
+	}
+}
+
+func TestTerminalCallbackTimeout(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		DisableAutoSaveHistory: true,
+		CallbackTimeout:        10 * time.Millisecond,
+		OnKeyPress: func(key []byte) []byte {
+			if string(key) == "a" {
+				time.Sleep(100 * time.Millisecond)
+				return nil
+			}
+			return key
+		},
+	})
+
+	if line := writeAndReadLine(t, term, stdin, "ab\r"); line != "ab" {
+		t.Fatalf("line %q, expected \"ab\"", line)
+	}
+}
+
+func TestTerminalCallbackTimeoutSerial(t *testing.T) {
+	var running, maxRunning int32
+	var mu sync.Mutex
+	term, stdin := newTestTerminal(t, Config{
+		DisableAutoSaveHistory: true,
+		CallbackTimeout:        10 * time.Millisecond,
+		OnKeyPress: func(key []byte) []byte {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			mu.Lock()
+			if n > maxRunning {
+				maxRunning = n
+			}
+			mu.Unlock()
+			time.Sleep(30 * time.Millisecond)
+			return key
+		},
+	})
+
+	if line := writeAndReadLine(t, term, stdin, "abc\r"); line != "abc" {
+		t.Fatalf("line %q, expected \"abc\"", line)
+	}
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&running) == 0
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning != 1 {
+		t.Fatalf("%d callbacks ran concurrently, expected 1", maxRunning)
+	}
+}
+
+func TestTerminalReadPartial(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	if _, err := io.WriteString(stdin, "half"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return term.rb.String() == "half"
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	line, partial, err := term.ReadBytesContextPartial(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("error %v, expected deadline exceeded", err)
+	}
+	if line != nil || string(partial) != "half" {
+		t.Fatalf("line %q partial %q, expected nil \"half\"", line, partial)
+	}
+	if line := writeAndReadLine(t, term, stdin, "full\r"); line != "full" {
+		t.Fatalf("line %q, expected \"full\"", line)
+	}
+}
+
+func TestTerminalReadRune(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	type result struct {
+		r    rune
+		size int
+		b    byte
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		var res result
+		res.r, res.size, res.err = term.ReadRune()
+		if res.err == nil {
+			res.b, res.err = term.ReadByte()
+		}
+		resultCh <- res
+	}()
+	// let ReadRune request the input before writing it
+	time.Sleep(20 * time.Millisecond)
+	if _, err := term.WriteStdin([]byte("ş!")); err != nil {
+		t.Fatal(err)
+	}
+	res := <-resultCh
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.r != 'ş' || res.size != 2 || res.b != '!' {
+		t.Fatalf("read %q %d %q, expected 'ş' 2 '!'", res.r, res.size, res.b)
+	}
+	if line := writeAndReadLine(t, term, stdin, "line\r"); line != "line" {
+		t.Fatalf("line %q, expected \"line\"", line)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := term.ReadRuneContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("error %v, expected deadline exceeded", err)
+	}
+	if line := writeAndReadLine(t, term, stdin, "next\r"); line != "next" {
+		t.Fatalf("line %q, expected \"next\"", line)
+	}
+}
+
+func TestTerminalReadByteRest(t *testing.T) {
+	term, _ := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	type result struct {
+		b   byte
+		err error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		var res result
+		res.b, res.err = term.ReadByte()
+		resultCh <- res
+	}()
+	// let ReadByte request the input before writing it
+	time.Sleep(20 * time.Millisecond)
+	if _, err := term.WriteStdin([]byte("é\n")); err != nil {
+		t.Fatal(err)
+	}
+	res := <-resultCh
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.b != "é"[0] {
+		t.Fatalf("byte %#x, expected %#x", res.b, "é"[0])
+	}
+	// the rest of "é" isn't dropped, and the line is accepted by the newline after it
+	line, err := term.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "\uFFFD" {
+		t.Fatalf("line %q, expected \"\\uFFFD\"", line)
+	}
+}
+
+func TestTerminalEscapeTimeout(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		DisableAutoSaveHistory: true,
+		EscapeTimeout:          10 * time.Millisecond,
+	})
+	term.Bind("\x1b", func(t *Terminal) {
+		t.rb.WriteString("<esc>")
+	})
+	if _, err := io.WriteString(stdin, "a\x1b"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return term.rb.String() == "a<esc>"
+	})
+	if line := writeAndReadLine(t, term, stdin, "ş\x1bb\r"); line != "a<esc>ş" {
+		t.Fatalf("line %q, expected \"a<esc>ş\"", line)
+	}
+
+	term, stdin = newTestTerminal(t, Config{
+		DisableAutoSaveHistory: true,
+		VimMode:                true,
+		VimModeIndicator: func(normal bool) string {
+			if normal {
+				return "N"
+			}
+			return "I"
+		},
+	})
+	if _, err := io.WriteString(stdin, "abc\x1b"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return strings.HasPrefix(term.rb.Prompt(), "N")
+	})
+	if line := writeAndReadLine(t, term, stdin, "0x\r"); line != "bc" {
+		t.Fatalf("line %q, expected \"bc\"", line)
+	}
+}
+
+func TestTerminalTransientPrompt(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{
+		Prompt:              "> ",
+		ForceUseInteractive: true,
+		TransientPrompt: func(line string) string {
+			return "[" + line + "] "
+		},
+	}, out)
+
+	if line := writeAndReadLine(t, term, stdin, "ab\r"); line != "ab" {
+		t.Fatalf("line %q, expected \"ab\"", line)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "[ab] ab\n")
+	})
+	if s := out.String(); strings.Contains(s, "> ab\n") {
+		t.Fatalf("output %q, expected the transient prompt before the newline", s)
+	}
+	if prompt := term.rb.Prompt(); prompt != "> " {
+		t.Fatalf("prompt %q, expected \"> \"", prompt)
+	}
+	writeAndReadLine(t, term, stdin, "c\r")
+	waitFor(t, func() bool {
+		s := out.String()
+		return strings.Contains(s, "> c") && strings.Contains(s, "[c] c\n")
+	})
+}
+
+func TestTerminalAccessors(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		Prompt:                 "> ",
+		DisableAutoSaveHistory: true,
+	})
+	if term.IsInteractive() {
+		t.Fatal("terminal reading from a pipe is interactive")
+	}
+	if prompt := term.GetPrompt(); prompt != "> " {
+		t.Fatalf("prompt %q, expected \"> \"", prompt)
+	}
+	term.SetPrompt("$ ")
+	if prompt := term.GetPrompt(); prompt != "$ " {
+		t.Fatalf("prompt %q, expected \"$ \"", prompt)
+	}
+	if prompt := term.rb.Prompt(); prompt != "$ " {
+		t.Fatalf("displayed prompt %q, expected \"$ \"", prompt)
+	}
+	if mask := term.GetMask(); mask != 0 {
+		t.Fatalf("mask %q, expected none", mask)
+	}
+	term.SetMask('*')
+	if mask := term.GetMask(); mask != '*' {
+		t.Fatalf("mask %q, expected '*'", mask)
+	}
+	if line := writeAndReadLine(t, term, stdin, "secret\r"); line != "secret" {
+		t.Fatalf("line %q, expected \"secret\"", line)
+	}
+	if prompt := term.rb.Prompt(); prompt != "$ " {
+		t.Fatalf("displayed prompt %q after reading, expected \"$ \"", prompt)
+	}
+}
+
+func TestTerminalConcurrentRedraw(t *testing.T) {
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{Prompt: "> ", ForceUseInteractive: true}, out)
+
+	done := make(chan string)
+	go func() {
+		line, _ := term.ReadLine()
+		done <- line
+	}()
+	if _, err := stdin.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, fn := range []func() error{term.ForceRefresh, term.Bell, term.ClearScreen} {
+		wg.Add(1)
+		go func(fn func() error) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if err := fn(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(fn)
+	}
+	wg.Wait()
+
+	if _, err := stdin.Write([]byte("def\r")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-done; line != "abcdef" {
+		t.Fatalf("line %q, expected \"abcdef\"", line)
+	}
+	waitFor(t, func() bool {
+		s := out.String()
+		return strings.Contains(s, "\a") && strings.Contains(s, "\033[H")
+	})
+}
+
+func TestTerminalReadWriter(t *testing.T) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	out := &syncBuffer{}
+	go func() {
+		_, _ = io.Copy(out, outR)
+	}()
+	term, err := NewTerminal(Config{
+		Prompt: "> ",
+		ReadWriter: struct {
+			io.Reader
+			io.Writer
+		}{inR, outW},
+		ForceUseInteractive: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = term.Close()
+		_ = inW.Close()
+		_ = outR.Close()
+	})
+
+	if term.Stdin() != nil || term.Stdout() != nil || term.Stderr() != nil {
+		t.Fatal("files are set with ReadWriter")
+	}
+	if _, _, err := term.GetSize(); err != ErrNotTerminal {
+		t.Fatalf("GetSize error %v, expected %v", err, ErrNotTerminal)
+	}
+	if err := term.EnterRawMode(); err != ErrNotTerminal {
+		t.Fatalf("EnterRawMode error %v, expected %v", err, ErrNotTerminal)
+	}
+	if line := writeAndReadLine(t, term, inW, "hello\r"); line != "hello" {
+		t.Fatalf("line %q, expected \"hello\"", line)
+	}
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "> hello")
+	})
+}
+
+func TestTerminalReadOnly(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true, ReadOnly: true})
+	term.Bind("q", func(t *Terminal) {
+		t.rb.Set(0, []rune("quit"))
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"abc\x7f\r", ""},
+		{"q\x05\x08\x0b\x17\x19\x14\x1fx\r", "quit"},
+		{"q\x05\x1b[D\x1b[D\x00\x01\x18\x18\x17\r", "quit"},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+	if err := term.PasteFromClipboard(); err != ErrReadOnly {
+		t.Errorf("PasteFromClipboard: error %v, expected ErrReadOnly", err)
+	}
+}
+
+func TestTerminalWriteStdinSplitRune(t *testing.T) {
+	term, _ := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+
+	// "€" is 3 bytes in UTF-8
+	for _, p := range []string{"a\xe2", "\x82", "\xacb\r"} {
+		if _, err := term.WriteStdin([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if line, err := term.ReadLine(); err != nil || line != "a€b" {
+		t.Fatalf("line %q %v, expected \"a€b\"", line, err)
+	}
+}
@@ -0,0 +1,127 @@
+package readline
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TraceEvent is the line of the trace which is written to Config.TraceWriter as JSON.
+type TraceEvent struct {
+	// Time is the wall-clock time of the event in microseconds since the Unix epoch.
+	Time int64 `json:"time"`
+	// Bytes is the input of the event, quoted like a Go string without the quotes, like \x1b[A.
+	Bytes string `json:"bytes"`
+	// Event is the kind of the event, "key" for a key, "escape" for an escape sequence, "read" for the input which is
+	// read by ReadRune or ReadByte.
+	Event string `json:"event"`
+	// Char is the rune of a printable key, or the final character of an escape sequence.
+	Char string `json:"char,omitempty"`
+	// Playback is true if the input is played back from the keyboard macro, it isn't replayed.
+	Playback bool `json:"playback,omitempty"`
+	// Redacted is true if the input is read while echoing is disabled, like by ReadPassword. Bytes and Char are
+	// empty, so it isn't replayed.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// traceState collects the input of the event which is being read in the input loop.
+type traceState struct {
+	w        io.Writer
+	buf      []byte
+	playback bool
+}
+
+// traceInput appends p to the input of the current event.
+func (t *Terminal) traceInput(p []byte) {
+	if t.trace == nil {
+		return
+	}
+	if len(t.trace.buf) <= 0 {
+		t.trace.playback = t.macroBusy
+	}
+	t.trace.buf = append(t.trace.buf, p...)
+}
+
+// traceEvent writes the event with the input of the current event except its last keep bytes, which remain for
+// the next event.
+func (t *Terminal) traceEvent(event string, char rune, keep int) {
+	if t.trace == nil {
+		return
+	}
+	tr := t.trace
+	p := tr.buf[:len(tr.buf)-keep]
+	if char == 0 && event == "key" {
+		if r, size := utf8.DecodeRune(p); size == len(p) && unicode.IsPrint(r) {
+			char = r
+		}
+	}
+	e := TraceEvent{
+		Time:     time.Now().UnixNano() / int64(time.Microsecond),
+		Bytes:    quoteBytes(p),
+		Event:    event,
+		Playback: tr.playback,
+	}
+	if t.rb.NoEcho() {
+		e.Bytes, char, e.Redacted = "", 0, true
+	}
+	if char != 0 {
+		e.Char = string(char)
+	}
+	tr.buf = append(tr.buf[:0], tr.buf[len(p):]...)
+	tr.playback = t.macroBusy
+	data, err := json.Marshal(e)
+	if err == nil {
+		_, err = tr.w.Write(append(data, '\n'))
+	}
+	if err != nil {
+		t.logError(fmt.Errorf("trace: %w", err))
+	}
+}
+
+// TerminalReplay reads the trace written to Config.TraceWriter from r, and writes the input of its events to
+// the stdin of t by WriteStdin. The delays between the events are divided by speed, there are no delays if speed
+// isn't positive. The events of the keyboard macro playback are skipped, since they're played back again.
+func TerminalReplay(t *Terminal, r io.Reader, speed float64) error {
+	br := bufio.NewReader(r)
+	var last int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var e TraceEvent
+			if err := json.Unmarshal(line, &e); err != nil {
+				return err
+			}
+			p, err := strconv.Unquote(`"` + e.Bytes + `"`)
+			if err != nil {
+				return fmt.Errorf("invalid trace bytes %q: %w", e.Bytes, err)
+			}
+			if speed > 0 && last != 0 && e.Time > last {
+				time.Sleep(time.Duration(float64(e.Time-last)/speed) * time.Microsecond)
+			}
+			last = e.Time
+			if !e.Playback {
+				if _, err := t.WriteStdin([]byte(p)); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// quoteBytes quotes p like a Go string without the quotes, so the invalid UTF-8 sequences are kept.
+func quoteBytes(p []byte) string {
+	s := strconv.Quote(string(p))
+	return s[1 : len(s)-1]
+}
@@ -0,0 +1,172 @@
+package readline
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTerminalTrace(t *testing.T) {
+	trace := &syncBuffer{}
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true, TraceWriter: trace})
+
+	steps := []struct {
+		input string
+		buf   string
+		idx   int
+	}{
+		{"a", "a", 1},
+		{"b", "ab", 2},
+		{"\x1b[D", "ab", 1},
+		{"ö", "aöb", 2},
+		{"\x1b[C", "aöb", 3},
+		// Ctrl+X Ctrl+X without the mark rings the bell
+		{"\x18\x18", "aöb", 3},
+	}
+	for _, step := range steps {
+		if _, err := io.WriteString(stdin, step.input); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			return term.rb.String() == step.buf && term.rb.Index() == step.idx
+		})
+	}
+	if line := writeAndReadLine(t, term, stdin, "\r"); line != "aöb" {
+		t.Fatalf("line %q, expected \"aöb\"", line)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(trace.String(), "\n"), "\n")
+	expected := []TraceEvent{
+		{Bytes: "a", Event: "key", Char: "a"},
+		{Bytes: "b", Event: "key", Char: "b"},
+		{Bytes: `\x1b[D`, Event: "escape", Char: "D"},
+		{Bytes: "ö", Event: "key", Char: "ö"},
+		{Bytes: `\x1b[C`, Event: "escape", Char: "C"},
+		{Bytes: `\x18`, Event: "key"},
+		{Bytes: `\x18`, Event: "key"},
+		{Bytes: `\r`, Event: "key"},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("trace %q, expected %d lines", lines, len(expected))
+	}
+	for i, line := range lines {
+		var e TraceEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Time <= 0 {
+			t.Errorf("trace line %q without the time", line)
+		}
+		e.Time = 0
+		if e != expected[i] {
+			t.Errorf("trace line %q, expected %+v", line, expected[i])
+		}
+	}
+
+	// replay the trace step by step
+	replay, _ := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+	for i, step := range steps {
+		input := lines[i]
+		if i == len(steps)-1 {
+			input += "\n" + lines[i+1]
+		}
+		if err := TerminalReplay(replay, strings.NewReader(input), 1); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			return replay.rb.String() == step.buf && replay.rb.Index() == step.idx
+		})
+	}
+	if err := TerminalReplay(replay, strings.NewReader(lines[len(lines)-1]+"\n"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := replay.ReadLine(); err != nil || line != "aöb" {
+		t.Fatalf("replayed line %q %v, expected \"aöb\"", line, err)
+	}
+}
+
+func TestTerminalReplayInvalid(t *testing.T) {
+	term, _ := newTestTerminal(t, Config{DisableAutoSaveHistory: true})
+	if err := TerminalReplay(term, strings.NewReader("{"), 0); err == nil {
+		t.Error("TerminalReplay succeeded with invalid JSON")
+	}
+	if err := TerminalReplay(term, strings.NewReader(`{"time":1,"bytes":"\\q","event":"key"}`), 0); err == nil {
+		t.Error("TerminalReplay succeeded with invalid bytes")
+	}
+}
+
+func TestTerminalTraceMacro(t *testing.T) {
+	trace := &syncBuffer{}
+	term, stdin := newTestTerminal(t, Config{DisableAutoSaveHistory: true, TraceWriter: trace})
+
+	if line := writeAndReadLine(t, term, stdin, "\x18(a\x1bb\x18)\r"); line != "a" {
+		t.Fatalf("line %q, expected \"a\"", line)
+	}
+	start := strings.Count(trace.String(), "\n")
+	if _, err := io.WriteString(stdin, "\x18e"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return term.rb.String() == "a" && term.rb.Index() == 0
+	})
+	if line := writeAndReadLine(t, term, stdin, "\r"); line != "a" {
+		t.Fatalf("line %q, expected \"a\"", line)
+	}
+	lines := strings.Split(strings.TrimSuffix(trace.String(), "\n"), "\n")[start:]
+	expected := []TraceEvent{
+		{Bytes: `\x18`, Event: "key"},
+		{Bytes: "e", Event: "key", Char: "e"},
+		{Bytes: "a", Event: "key", Char: "a", Playback: true},
+		{Bytes: `\x1bb`, Event: "escape", Char: "b", Playback: true},
+		{Bytes: `\r`, Event: "key"},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("trace %q, expected %d lines", lines, len(expected))
+	}
+	for i, line := range lines {
+		var e TraceEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		e.Time = 0
+		if e != expected[i] {
+			t.Errorf("trace line %q, expected %+v", line, expected[i])
+		}
+	}
+}
+
+func TestTerminalTraceReadPassword(t *testing.T) {
+	trace := &syncBuffer{}
+	out := &syncBuffer{}
+	term, stdin := newTestTerminalOutput(t, Config{ForceUseInteractive: true, TraceWriter: trace}, out)
+
+	resultCh := make(chan string, 1)
+	go func() {
+		password, _ := term.ReadPassword("Password: ")
+		resultCh <- password
+	}()
+	waitFor(t, func() bool {
+		return strings.Contains(out.String(), "Password: ")
+	})
+	if _, err := io.WriteString(stdin, "hunter2\r"); err != nil {
+		t.Fatal(err)
+	}
+	if password := <-resultCh; password != "hunter2" {
+		t.Fatalf("password %q, expected \"hunter2\"", password)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(trace.String(), "\n"), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("trace %q, expected 8 lines", lines)
+	}
+	for _, line := range lines {
+		var e TraceEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Bytes != "" || e.Char != "" || !e.Redacted {
+			t.Errorf("trace line %q isn't redacted", line)
+		}
+	}
+}
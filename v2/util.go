@@ -46,19 +46,39 @@ func isFileChar(f *os.File) bool {
 }
 
 var (
-	escapeRgx = regexp.MustCompile(`^(?P<esc>(?P<char>.)((?P<attr>\d+)(;(?P<attr2>\d+))?)?(?P<typ>[^\d;])?)?(?P<rem>.+)?$`)
+	escapeRgx = regexp.MustCompile(`(?s)^(?P<esc>(?P<char>.)((?P<attr>\d+)(;(?P<attr2>\d+)(;(?P<attr3>\d+))?)?)?(?P<typ>[^\d;])?)?(?P<rem>.+)?$`)
 )
 
 type escapeKeyPair struct {
 	Char       rune
 	Attribute  int
 	Attribute2 int
+	Attribute3 int
 	Type       rune
 	Remainder  []byte
+	// Key is the canonical key event of the sequence if it's a known function key or a modified arrow key.
+	Key KeyEvent
+}
+
+// finalChar returns the final character of the sequence, which is Type if it's a CSI or SS3 sequence, and Char
+// otherwise.
+func (p *escapeKeyPair) finalChar() rune {
+	if (p.Char == '[' || p.Char == 'O') && p.Type != 0 {
+		return p.Type
+	}
+	return p.Char
+}
+
+// isModifiedKey returns true if the pair is a "\x1b[27;mod;char~" sequence of the modifyOtherKeys mode.
+func (p *escapeKeyPair) isModifiedKey() bool {
+	return p.Char == '[' && p.Type == '~' && p.Attribute == 27 && p.Attribute2 > 0 && p.Attribute3 >= 0
 }
 
 func decodeEscapeKeyPair(p []byte) *escapeKeyPair {
 	submatches := escapeRgx.FindSubmatch(p)
+	if submatches == nil {
+		return nil
+	}
 	p = submatches[escapeRgx.SubexpIndex("esc")]
 	if len(p) <= 0 {
 		return nil
@@ -66,6 +86,8 @@ func decodeEscapeKeyPair(p []byte) *escapeKeyPair {
 	result := &escapeKeyPair{
 		Attribute:  -1,
 		Attribute2: -1,
+		Attribute3: -1,
+		Key:        escapeKeyEvents["\x1b"+string(p)],
 	}
 	p = submatches[escapeRgx.SubexpIndex("char")]
 	if len(p) > 0 {
@@ -87,10 +109,21 @@ func decodeEscapeKeyPair(p []byte) *escapeKeyPair {
 		}
 		result.Attribute2 = int(i)
 	}
+	p = submatches[escapeRgx.SubexpIndex("attr3")]
+	if len(p) > 0 {
+		i, err := strconv.ParseInt(string(p), 10, 32)
+		if err != nil {
+			i = -1
+		}
+		result.Attribute3 = int(i)
+	}
 	p = submatches[escapeRgx.SubexpIndex("typ")]
 	if len(p) > 0 {
 		result.Type, _ = utf8.DecodeRune(p)
 	}
+	if result.isModifiedKey() {
+		result.Key = modifiedKeyEvent(Modifier(result.Attribute2-1), rune(result.Attribute3))
+	}
 	p = submatches[escapeRgx.SubexpIndex("rem")]
 	if len(p) > 0 {
 		result.Remainder = make([]byte, len(p))
@@ -0,0 +1,251 @@
+package readline
+
+import (
+	"unicode/utf8"
+)
+
+// viMode is the mode of the vi editing.
+type viMode int
+
+const (
+	viInsertMode viMode = iota
+	viNormalMode
+)
+
+// viState is the state of the vi editing.
+type viState struct {
+	mode viMode
+	// pending is the operator which waits for a motion, like 'd', and pendingCount is its repeat count.
+	pending      rune
+	pendingCount int
+	// count is the repeat count of the next command, it's zero if it's not specified.
+	count int
+	// find is the character search command which waits for its character, like 'f', and findCount is its count.
+	find      rune
+	findCount int
+}
+
+// viEnterNormal enters the vi normal mode.
+func (t *Terminal) viEnterNormal() {
+	t.vi = viState{mode: viNormalMode}
+	t.viRefreshPrompt()
+}
+
+// viEnterInsert enters the vi insert mode.
+func (t *Terminal) viEnterInsert() {
+	t.vi = viState{mode: viInsertMode}
+	t.viRefreshPrompt()
+}
+
+func (t *Terminal) viRefreshPrompt() {
+	if t.config.VimModeIndicator != nil {
+		t.rb.SetPrompt(t.config.VimModeIndicator(t.vi.mode == viNormalMode) + t.prompt.Load().(string))
+	}
+}
+
+// viNormalKey processes p in the vi normal mode. It returns false if p should be processed normally.
+func (t *Terminal) viNormalKey(p []byte) bool {
+	if cmd := t.vi.find; cmd != 0 {
+		// the character search is canceled by a control character
+		t.vi.find = 0
+		if p[0] >= 0x20 {
+			ch, _ := utf8.DecodeRune(p)
+			t.lastFindCmd, t.lastFindChar = cmd, ch
+			t.viFind(cmd, ch, t.vi.findCount)
+			return true
+		}
+	}
+	r := rune(p[0])
+	if len(p) > 1 {
+		t.bell()
+		return true
+	}
+	switch r {
+	case CharFeed, CharReturn, CharInterrupt, CharDelete:
+		t.viEnterInsert()
+		return false
+	}
+	if r < 0x20 {
+		return false
+	}
+
+	if r >= '1' && r <= '9' || r == '0' && t.vi.count > 0 {
+		if t.vi.count >= maxNumericArg {
+			t.bell()
+			return true
+		}
+		t.vi.count = t.vi.count*10 + int(r-'0')
+		return true
+	}
+	count := t.vi.count
+	if count <= 0 {
+		count = 1
+	}
+	t.vi.count = 0
+
+	if t.vi.pending != 0 {
+		op := t.vi.pending
+		t.vi.pending = 0
+		if count *= t.vi.pendingCount; count > maxNumericArg {
+			count = maxNumericArg
+		}
+		t.viOperator(op, r, count)
+		return true
+	}
+
+	switch r {
+	case 'h':
+		t.viRepeat(count, t.rb.MoveBackward)
+
+	case 'l', ' ':
+		t.viRepeat(count, t.rb.MoveForward)
+
+	case 'j':
+		t.opNext()
+
+	case 'k':
+		t.opPrev()
+
+	case '0', '^':
+		t.rb.MoveToLineStart()
+
+	case '$':
+		t.rb.MoveToLineEnd()
+
+	case 'w':
+		t.viRepeat(count, t.rb.MoveToNextWord)
+
+	case 'b':
+		t.viRepeat(count, t.rb.MoveToPrevWord)
+
+	case 'e':
+		t.viRepeat(count, t.rb.MoveToEndWord)
+
+	case 'W':
+		t.viRepeat(count, t.rb.MoveToNextBigWord)
+
+	case 'B':
+		t.viRepeat(count, t.rb.MoveToPrevBigWord)
+
+	case 'E':
+		t.viRepeat(count, t.rb.MoveToEndBigWord)
+
+	case 'f', 'F', 't', 'T':
+		t.vi.find = r
+		t.vi.findCount = count
+
+	case ';', ',':
+		cmd := t.lastFindCmd
+		if cmd == 0 {
+			t.bell()
+			break
+		}
+		if r == ',' {
+			// the reverse direction
+			switch cmd {
+			case 'f':
+				cmd = 'F'
+			case 'F':
+				cmd = 'f'
+			case 't':
+				cmd = 'T'
+			case 'T':
+				cmd = 't'
+			}
+		}
+		t.viFind(cmd, t.lastFindChar, count)
+
+	case 'x':
+		t.viRepeat(count, t.rb.Delete)
+
+	case 'X':
+		t.viRepeat(count, t.rb.Backspace)
+
+	case 'p':
+		t.viRepeat(count, t.rb.Yank)
+
+	case 'u':
+		t.viRepeat(count, t.rb.Undo)
+
+	case '~':
+		t.viRepeat(count, t.toggleCaseChar)
+
+	case 'd':
+		t.vi.pending = r
+		t.vi.pendingCount = count
+
+	case 'i':
+		t.viEnterInsert()
+
+	case 'I':
+		t.rb.MoveToLineStart()
+		t.viEnterInsert()
+
+	case 'a':
+		t.rb.MoveForward()
+		t.viEnterInsert()
+
+	case 'A':
+		t.rb.MoveToLineEnd()
+		t.viEnterInsert()
+
+	default:
+		t.bell()
+
+	}
+	return true
+}
+
+// viOperator applies the operator op with the motion r.
+func (t *Terminal) viOperator(op rune, r rune, count int) {
+	switch op {
+	case 'd':
+		switch r {
+		case 'd':
+			t.rb.Erase()
+
+		case 'w', 'e':
+			t.viRepeat(count, t.rb.KillWord)
+
+		case 'b':
+			t.viRepeat(count, t.rb.KillWordFront)
+
+		case 'h':
+			t.viRepeat(count, t.rb.Backspace)
+
+		case 'l':
+			t.viRepeat(count, t.rb.Delete)
+
+		case '$':
+			t.rb.Kill()
+
+		case '0', '^':
+			t.rb.KillFront()
+
+		default:
+			t.bell()
+
+		}
+
+	}
+}
+
+// viFind moves the cursor to the count-th ch by the character search command cmd. 'f' and 'F' move to ch after and
+// before the cursor, and 't' and 'T' move next to it.
+func (t *Terminal) viFind(cmd rune, ch rune, count int) {
+	reverse := cmd == 'F' || cmd == 'T'
+	prevChar := cmd == 't' || cmd == 'T'
+	if !t.rb.MoveToNthChar(ch, count, reverse, prevChar) {
+		t.bell()
+	}
+}
+
+// viRepeat calls f count times, and rings the bell if f fails.
+func (t *Terminal) viRepeat(count int, f func() bool) {
+	for i := 0; i < count; i++ {
+		if !f() {
+			t.bell()
+			return
+		}
+	}
+}
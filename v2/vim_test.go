@@ -0,0 +1,86 @@
+package readline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTerminalVimMode(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{VimMode: true, DisableAutoSaveHistory: true})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"hello\x1bhhx\r", "helo"},
+		{"abcdef\x1b03lx\r", "abcef"},
+		{"abcdef\x1b0llx2x\r", "abf"},
+		{"abc\x1b0Ax\r", "abcx"},
+		{"abc\x1b0ix\r", "xabc"},
+		{"abc\x1bIx\r", "xabc"},
+		{"abc\x1b0ax\r", "axbc"},
+		{"abc\x1bddiXYZ\r", "XYZ"},
+		{"foo bar baz\x1b0dw\r", " bar baz"},
+		{"foo bar baz\x1b02dw\r", " baz"},
+		{"foo bar baz\x1b0wd$\r", "foo "},
+		{"foo bar baz\x1bbd0\r", "baz"},
+		{"foo bar baz\x1b0we\x1b[3~\r", "foo ba baz"},
+		{"abc\x1b[D\x1b[Dx\r", "axbc"},
+		{"abc\x1b0\x1b0x\r", "bc"},
+		{"abc\x1bq\r", "abc"},
+		{"abc\x1b0~\r", "Abc"},
+		{"a.b.c.d\x1b0f.x\r", "ab.c.d"},
+		{"a.b.c.d\x1b02f.x\r", "a.bc.d"},
+		{"a.b.c.d\x1b0t.x\r", ".b.c.d"},
+		{"a.b.c.d\x1bF.x\r", "a.b.cd"},
+		{"a.b.c.d\x1bT.x\r", "a.b.c."},
+		{"a.b.c.d\x1b0f.;x\r", "a.bc.d"},
+		{"a.b.c.d\x1b0f.;;,x\r", "a.bc.d"},
+		{"a.b.c.d\x1b0fxx\r", ".b.c.d"},
+		{"aé b\x1b0féx\r", "a b"},
+		{"aBc d\x1b02~x\r", "Ab d"},
+		{"a.b c.d\x1b0Wx\r", "a.b .d"},
+		{"a.b c.d\x1b0wx\r", "a. c.d"},
+		{"a.b c.d\x1bBx\r", "a.b .d"},
+		{"a.b c.d\x1b0Ex\r", "a. c.d"},
+		{"a.b c.d e\x1b02Wx\r", "a.b c.d "},
+	}
+	for _, test := range tests {
+		if line := writeAndReadLine(t, term, stdin, test.input); line != test.expected {
+			t.Errorf("input %q: line %q, expected %q", test.input, line, test.expected)
+		}
+	}
+}
+
+func TestTerminalVimModeIndicator(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{
+		Prompt:  "> ",
+		VimMode: true,
+		VimModeIndicator: func(normal bool) string {
+			if normal {
+				return "[N]"
+			}
+			return "[I]"
+		},
+	})
+
+	if prompt := term.rb.Prompt(); prompt != "[I]> " {
+		t.Fatalf("prompt %q, expected \"[I]> \"", prompt)
+	}
+	writeAndReadLine(t, term, stdin, "a\x1bh\x1b[D\r")
+	if prompt := term.rb.Prompt(); prompt != "[I]> " {
+		t.Fatalf("prompt %q after return, expected \"[I]> \"", prompt)
+	}
+}
+
+func TestTerminalVimModeCountLimit(t *testing.T) {
+	term, stdin := newTestTerminal(t, Config{VimMode: true, DisableAutoSaveHistory: true})
+
+	// the count doesn't overflow
+	if line := writeAndReadLine(t, term, stdin, "abc\x1b0"+strings.Repeat("9", 30)+"x\r"); line != "" {
+		t.Fatalf("line %q, expected \"\"", line)
+	}
+	if line := writeAndReadLine(t, term, stdin, "abc def\x1b0"+strings.Repeat("9", 30)+"d2w\r"); line != "" {
+		t.Fatalf("line %q, expected \"\"", line)
+	}
+}